@@ -0,0 +1,22 @@
+// Command reindex reconstruye el índice de búsqueda de productos desde la BD.
+// Se ejecuta con la API parada, desde la raíz del proyecto: go run ./cmd/reindex
+package main
+
+import (
+	"context"
+	"log"
+	"main/database"
+	"main/search"
+)
+
+func main() {
+	if err := database.Connect(); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := search.RebuildProducts(context.Background()); err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("indexed %d products", search.Products.Len())
+}
@@ -0,0 +1,159 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// EnsureIndexes crea los índices que necesita la API si no existen. sessionLifetime es
+// lo que dura una sesión sin usarse, lo mismo que su refresh token.
+func EnsureIndexes(ctx context.Context, sessionLifetime time.Duration) error {
+	// Índice de texto para la búsqueda de productos, el nombre pesa más que la descripción
+	_, err := Mg.Db.Collection("Products").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "name", Value: "text"},
+			{Key: "category", Value: "text"},
+			{Key: "description", Value: "text"},
+		},
+		Options: options.Index().
+			SetName("products_text").
+			SetWeights(bson.M{"name": 10, "category": 5, "description": 1}).
+			SetDefaultLanguage("none"),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Slug único para las URLs del catálogo, solo en los productos que lo tienen
+	_, err = Mg.Db.Collection("Products").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "slug", Value: 1}},
+		Options: options.Index().
+			SetName("products_slug").
+			SetUnique(true).
+			SetPartialFilterExpression(bson.M{"slug": bson.M{"$type": "string"}}),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Categorías: slug único y productos por categoría
+	_, err = Mg.Db.Collection("categories").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "slug", Value: 1}},
+		Options: options.Index().SetName("categories_slug").SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = Mg.Db.Collection("Products").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "category_id", Value: 1}},
+		Options: options.Index().SetName("products_category"),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Inventario: un nivel de stock por producto, variante y almacén, movimientos por
+	// producto y fecha. El índice sin variante ya no sirve desde que hay variantes.
+	if err := dropIndex(ctx, Mg.Db.Collection("stock_levels"), "stock_levels_product_warehouse"); err != nil {
+		return err
+	}
+	_, err = Mg.Db.Collection("stock_levels").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "product_id", Value: 1}, {Key: "variant_id", Value: 1}, {Key: "warehouse", Value: 1}},
+		Options: options.Index().SetName("stock_levels_product_variant_warehouse").SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = Mg.Db.Collection("stock_movements").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "product_id", Value: 1}, {Key: "created_at", Value: -1}},
+		Options: options.Index().SetName("stock_movements_product_created"),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Variantes: SKU único en todo el catálogo y una variante por combinación de opciones
+	_, err = Mg.Db.Collection("variants").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "sku", Value: 1}},
+			Options: options.Index().SetName("variants_sku").SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "product_id", Value: 1}, {Key: "key", Value: 1}},
+			Options: options.Index().SetName("variants_product_key").SetUnique(true),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Usuarios y clientes: un email por cuenta en cada realm, el login busca por email.
+	// Las cuentas sin email no cuentan. La migración 004_unique_emails comprueba antes
+	// que no haya repetidos de versiones anteriores.
+	for _, name := range []string{"users", "customers"} {
+		_, err = Mg.Db.Collection(name).Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys: bson.D{{Key: "email", Value: 1}},
+			Options: options.Index().
+				SetName(name + "_email").
+				SetUnique(true).
+				SetPartialFilterExpression(bson.M{"email": bson.M{"$type": "string"}}),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Precios: historial por producto y fecha, programados por estado y fecha de inicio
+	_, err = Mg.Db.Collection("price_changes").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "product_id", Value: 1}, {Key: "created_at", Value: -1}},
+		Options: options.Index().SetName("price_changes_product_created"),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = Mg.Db.Collection("price_schedules").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "status", Value: 1}, {Key: "starts_at", Value: 1}},
+			Options: options.Index().SetName("price_schedules_status_starts"),
+		},
+		{
+			Keys:    bson.D{{Key: "product_id", Value: 1}, {Key: "starts_at", Value: 1}},
+			Options: options.Index().SetName("price_schedules_product_starts"),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Tokens, estados y contadores que caducan: MongoDB los borra al pasar expires_at
+	for _, name := range []string{"jwt", "refresh_tokens", "revoked_tokens", "password_resets", "oidc_states", "login_attempts"} {
+		_, err = Mg.Db.Collection(name).Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetName(name + "_expires").SetExpireAfterSeconds(0),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Las sesiones no tienen fecha de fin: se borran cuando llevan sin usarse lo que dura
+	// su refresh token
+	_, err = Mg.Db.Collection("sessions").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "last_used_at", Value: 1}},
+		Options: options.Index().SetName("sessions_last_used").SetExpireAfterSeconds(int32(sessionLifetime.Seconds())),
+	})
+	return err
+}
+
+// dropIndex borra un índice que ya no se usa, si existe
+func dropIndex(ctx context.Context, collection *mongo.Collection, name string) error {
+	_, err := collection.Indexes().DropOne(ctx, name)
+	if cmdErr, ok := err.(mongo.CommandError); ok && (cmdErr.Name == "IndexNotFound" || cmdErr.Name == "NamespaceNotFound") {
+		return nil
+	}
+	return err
+}
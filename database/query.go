@@ -0,0 +1,223 @@
+package database
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrInvalidCursor se devuelve cuando el cursor no es válido para la consulta
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// SortField es un campo de ordenación
+type SortField struct {
+	Field string
+	Desc  bool
+}
+
+// ListQuery es una consulta paginada sobre una colección. Con Cursor se pagina por
+// clave (keyset) a partir del último documento devuelto; si no, por Page y Limit.
+type ListQuery struct {
+	Filter bson.M
+	Sort   []SortField
+	Page   int
+	Limit  int
+	Cursor string
+	// TextScore añade la relevancia de la búsqueda $text del filtro en el campo score
+	// y, si no hay Sort, ordena por ella. Ordenando por relevancia no hay cursor.
+	TextScore bool
+}
+
+// Page es el resultado de una ListQuery
+type Page struct {
+	Total      int64  `json:"total"`
+	Page       int    `json:"page,omitempty"`
+	Limit      int    `json:"limit"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// Find ejecuta la consulta en la colección y decodifica los documentos en items, que
+// debe ser un puntero a un slice. projection puede ser nil.
+func (q *ListQuery) Find(ctx context.Context, collection *mongo.Collection, projection bson.M, items interface{}) (*Page, error) {
+	filter := q.Filter
+	if filter == nil {
+		filter = bson.M{}
+	}
+
+	// El total cuenta todos los documentos del filtro, no solo los de la página
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	// _id al final para que el orden sea estable y el cursor no repita ni salte documentos
+	sort := bson.D{}
+	byScore := q.TextScore && len(q.Sort) == 0
+	if byScore {
+		sort = append(sort, bson.E{Key: "score", Value: bson.M{"$meta": "textScore"}})
+	}
+	for _, s := range q.Sort {
+		direction := 1
+		if s.Desc {
+			direction = -1
+		}
+		sort = append(sort, bson.E{Key: s.Field, Value: direction})
+	}
+	sort = append(sort, bson.E{Key: "_id", Value: 1})
+
+	opts := options.Find().SetSort(sort).SetLimit(int64(q.Limit) + 1)
+	if q.TextScore {
+		withScore := bson.M{"score": bson.M{"$meta": "textScore"}}
+		for key, value := range projection {
+			withScore[key] = value
+		}
+		projection = withScore
+	}
+	if projection != nil {
+		opts.SetProjection(projection)
+	}
+
+	page := &Page{Total: total, Limit: q.Limit}
+	if q.Cursor != "" {
+		if byScore {
+			return nil, ErrInvalidCursor
+		}
+		values, err := decodeCursor(q.Cursor, q.sortKey(), len(sort))
+		if err != nil {
+			return nil, err
+		}
+		filter = bson.M{"$and": bson.A{filter, keysetFilter(sort, values)}}
+	} else {
+		page.Page = q.Page
+		opts.SetSkip(int64((q.Page - 1) * q.Limit))
+	}
+
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	var docs []bson.Raw
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+
+	// Se pide un documento de más para saber si hay página siguiente. Ordenando por
+	// relevancia no hay cursor y se sigue con page.
+	if len(docs) > q.Limit {
+		docs = docs[:q.Limit]
+		if !byScore {
+			page.NextCursor, err = encodeCursor(docs[len(docs)-1], sort, q.sortKey())
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := decodeInto(docs, items); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// sortKey identifica la ordenación para no aceptar cursores de otra ordenación
+func (q *ListQuery) sortKey() string {
+	fields := make([]string, 0, len(q.Sort))
+	for _, s := range q.Sort {
+		if s.Desc {
+			fields = append(fields, "-"+s.Field)
+		} else {
+			fields = append(fields, s.Field)
+		}
+	}
+	return strings.Join(fields, ",")
+}
+
+// keysetFilter devuelve los documentos que van después de values en el orden sort.
+// Mongo ordena los campos que faltan como null, antes que cualquier otro valor, y
+// $gt/$lt no comparan null con otros tipos, así que null se trata aparte.
+func keysetFilter(sort bson.D, values bson.A) bson.M {
+	or := bson.A{}
+	for i, s := range sort {
+		condition := bson.M{}
+		for j := 0; j < i; j++ {
+			condition[sort[j].Key] = values[j]
+		}
+		desc := s.Value == -1
+		switch {
+		case values[i] == nil && desc:
+			// En orden descendente no hay nada después de null salvo los empates
+			continue
+		case values[i] == nil:
+			condition[s.Key] = bson.M{"$ne": nil}
+		case desc:
+			condition["$or"] = bson.A{bson.M{s.Key: bson.M{"$lt": values[i]}}, bson.M{s.Key: nil}}
+		default:
+			condition[s.Key] = bson.M{"$gt": values[i]}
+		}
+		or = append(or, condition)
+	}
+	return bson.M{"$or": or}
+}
+
+type cursorData struct {
+	Sort   string `bson:"s"`
+	Values bson.A `bson:"v"`
+}
+
+func encodeCursor(doc bson.Raw, sort bson.D, sortKey string) (string, error) {
+	values := bson.A{}
+	for _, s := range sort {
+		// Un campo que falta se guarda como null, que es como lo ordena Mongo
+		value, err := doc.LookupErr(strings.Split(s.Key, ".")...)
+		if err != nil {
+			values = append(values, nil)
+			continue
+		}
+		values = append(values, value)
+	}
+	data, err := bson.Marshal(cursorData{Sort: sortKey, Values: values})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeCursor(cursor string, sortKey string, fields int) (bson.A, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var decoded cursorData
+	if err := bson.Unmarshal(data, &decoded); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	if decoded.Sort != sortKey || len(decoded.Values) != fields {
+		return nil, ErrInvalidCursor
+	}
+	return decoded.Values, nil
+}
+
+// decodeInto decodifica los documentos en el slice al que apunta items
+func decodeInto(docs []bson.Raw, items interface{}) error {
+	slice := reflect.ValueOf(items)
+	if slice.Kind() != reflect.Ptr || slice.Elem().Kind() != reflect.Slice {
+		return errors.New("items must be a pointer to a slice")
+	}
+	elemType := slice.Elem().Type().Elem()
+	result := reflect.MakeSlice(slice.Elem().Type(), 0, len(docs))
+	for _, doc := range docs {
+		elem := reflect.New(elemType)
+		if err := bson.Unmarshal(doc, elem.Interface()); err != nil {
+			return err
+		}
+		result = reflect.Append(result, elem.Elem())
+	}
+	slice.Elem().Set(result)
+	return nil
+}
@@ -0,0 +1,206 @@
+package database
+
+import (
+	"sort"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	order := bson.D{{Key: "price.amount", Value: 1}, {Key: "name", Value: -1}, {Key: "_id", Value: 1}}
+
+	tests := []struct {
+		name string
+		doc  bson.D
+		want bson.A
+	}{
+		{
+			"all fields",
+			bson.D{{Key: "_id", Value: int32(1)}, {Key: "name", Value: "Taza"}, {Key: "price", Value: bson.D{{Key: "amount", Value: int64(899)}}}},
+			bson.A{int64(899), "Taza", int32(1)},
+		},
+		{
+			"missing field",
+			bson.D{{Key: "_id", Value: int32(2)}, {Key: "price", Value: bson.D{{Key: "amount", Value: int64(899)}}}},
+			bson.A{int64(899), nil, int32(2)},
+		},
+		{
+			"null field",
+			bson.D{{Key: "_id", Value: int32(3)}, {Key: "name", Value: nil}},
+			bson.A{nil, nil, int32(3)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, err := bson.Marshal(tt.doc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cursor, err := encodeCursor(raw, order, "price,-name")
+			if err != nil {
+				t.Fatal(err)
+			}
+			values, err := decodeCursor(cursor, "price,-name", len(order))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(values) != len(tt.want) {
+				t.Fatalf("values = %v, want %v", values, tt.want)
+			}
+			for i := range values {
+				if values[i] != tt.want[i] {
+					t.Errorf("values[%d] = %#v, want %#v", i, values[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeCursorInvalid(t *testing.T) {
+	raw, _ := bson.Marshal(bson.D{{Key: "_id", Value: int32(1)}, {Key: "name", Value: "Taza"}})
+	cursor, err := encodeCursor(raw, bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 1}}, "name")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		cursor  string
+		sortKey string
+		fields  int
+	}{
+		{"not base64", "%%%", "name", 2},
+		{"not bson", "aGVsbG8", "name", 2},
+		{"other sort", cursor, "-name", 2},
+		{"other fields", cursor, "name", 3},
+	}
+	for _, tt := range tests {
+		if _, err := decodeCursor(tt.cursor, tt.sortKey, tt.fields); err != ErrInvalidCursor {
+			t.Errorf("%s: err = %v, want ErrInvalidCursor", tt.name, err)
+		}
+	}
+}
+
+// Documento de prueba para paginar: un campo que puede faltar (nil) y el _id
+type pageDoc struct {
+	value interface{}
+	id    int
+}
+
+func (d pageDoc) get(key string) interface{} {
+	if key == "_id" {
+		return d.id
+	}
+	return d.value
+}
+
+// compareValues ordena como Mongo: null antes que los números
+func compareValues(a, b interface{}) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	case a.(int) < b.(int):
+		return -1
+	case a.(int) > b.(int):
+		return 1
+	}
+	return 0
+}
+
+// matches evalúa los filtros que genera keysetFilter con la semántica de Mongo:
+// igualdad con null incluye los que faltan y $gt/$lt no comparan null con números
+func matches(doc pageDoc, filter bson.M) bool {
+	for key, condition := range filter {
+		if key == "$or" {
+			any := false
+			for _, sub := range condition.(bson.A) {
+				if matches(doc, sub.(bson.M)) {
+					any = true
+				}
+			}
+			if !any {
+				return false
+			}
+			continue
+		}
+		value := doc.get(key)
+		op, ok := condition.(bson.M)
+		if !ok {
+			if compareValues(value, condition) != 0 || (value == nil) != (condition == nil) {
+				return false
+			}
+			continue
+		}
+		for name, operand := range op {
+			switch name {
+			case "$ne":
+				if (value == nil) == (operand == nil) {
+					return false
+				}
+			case "$gt":
+				if value == nil || operand == nil || compareValues(value, operand) <= 0 {
+					return false
+				}
+			case "$lt":
+				if value == nil || operand == nil || compareValues(value, operand) >= 0 {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// Paginar de uno en uno con el filtro del cursor tiene que recorrer todos los
+// documentos una sola vez, también los que no tienen el campo de orden
+func TestKeysetFilterMissingValues(t *testing.T) {
+	docs := []pageDoc{{3, 1}, {nil, 2}, {1, 3}, {nil, 4}, {3, 5}, {2, 6}}
+
+	for _, desc := range []bool{false, true} {
+		direction := 1
+		if desc {
+			direction = -1
+		}
+		order := bson.D{{Key: "value", Value: direction}, {Key: "_id", Value: 1}}
+
+		sorted := append([]pageDoc(nil), docs...)
+		sort.Slice(sorted, func(i, j int) bool {
+			if c := compareValues(sorted[i].value, sorted[j].value); c != 0 {
+				return (c < 0) != desc
+			}
+			return sorted[i].id < sorted[j].id
+		})
+
+		visited := make([]int, 0, len(docs))
+		var filter bson.M
+		for len(visited) <= len(docs) {
+			var next *pageDoc
+			for i := range sorted {
+				if filter == nil || matches(sorted[i], filter) {
+					next = &sorted[i]
+					break
+				}
+			}
+			if next == nil {
+				break
+			}
+			visited = append(visited, next.id)
+			filter = keysetFilter(order, bson.A{next.value, next.id})
+		}
+
+		if len(visited) != len(sorted) {
+			t.Fatalf("desc=%v: visited %v, want %d documents", desc, visited, len(sorted))
+		}
+		for i := range sorted {
+			if visited[i] != sorted[i].id {
+				t.Errorf("desc=%v: visited %v", desc, visited)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,67 @@
+// Package events publica los eventos de dominio de la API. Cada evento se guarda en la
+// colección events y se entrega a los suscriptores del proceso en segundo plano.
+package events
+
+import (
+	"context"
+	"log"
+	"main/database"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Tipos de evento
+const (
+	LowStock = "inventory.low_stock"
+)
+
+// Event es un evento publicado
+type Event struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Type      string             `json:"type" bson:"type"`
+	Data      interface{}        `json:"data" bson:"data"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// Handler procesa un evento
+type Handler func(ctx context.Context, event Event)
+
+var (
+	mu       sync.RWMutex
+	handlers = make(map[string][]Handler)
+)
+
+// Subscribe registra un handler para los eventos del tipo
+func Subscribe(eventType string, handler Handler) {
+	mu.Lock()
+	defer mu.Unlock()
+	handlers[eventType] = append(handlers[eventType], handler)
+}
+
+// Publish guarda el evento y lo entrega a los suscriptores sin esperar a que terminen
+func Publish(ctx context.Context, eventType string, data interface{}) error {
+	event := Event{Type: eventType, Data: data, CreatedAt: time.Now()}
+	res, err := database.Mg.Db.Collection("events").InsertOne(ctx, event)
+	if err != nil {
+		return err
+	}
+	event.ID = res.InsertedID.(primitive.ObjectID)
+
+	mu.RLock()
+	subscribers := handlers[eventType]
+	mu.RUnlock()
+
+	for _, handler := range subscribers {
+		go func(handler Handler) {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Println("event handler:", eventType, r)
+				}
+			}()
+			handler(context.Background(), event)
+		}(handler)
+	}
+	return nil
+}
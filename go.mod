@@ -3,10 +3,10 @@ module main
 go 1.15
 
 require (
-	github.com/go-redis/redis v6.15.9+incompatible // indirect
+	github.com/go-redis/redis v6.15.9+incompatible
 	github.com/gofiber/fiber/v2 v2.43.0
 	github.com/gofiber/jwt/v3 v3.3.7 // indirect
-	github.com/golang-jwt/jwt/v4 v4.5.0 // indirect
+	github.com/golang-jwt/jwt/v4 v4.5.0
 	github.com/golang/snappy v0.0.4 // indirect
 	github.com/google/go-cmp v0.5.9 // indirect
 	github.com/joho/godotenv v1.5.1
@@ -18,4 +18,5 @@ require (
 	github.com/youmark/pkcs8 v0.0.0-20201027041543-1326539a0a0a // indirect
 	go.mongodb.org/mongo-driver v1.11.4
 	golang.org/x/crypto v0.8.0
+	golang.org/x/text v0.9.0
 )
@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"main/database"
+	"main/middleware"
+	"main/models"
+	"main/utils"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Prefijo de las claves de API para reconocerlas a simple vista
+const apiKeyPrefix = "gak_"
+
+// GetAPIKeys lista las claves de API
+func GetAPIKeys(c *fiber.Ctx) error {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	cursor, err := database.Mg.Db.Collection("api_keys").Find(c.Context(), bson.M{}, opts)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"statusCode": 500,
+			"message":    "Internal Server Error",
+		})
+	}
+
+	keys := make([]models.APIKey, 0)
+	if err := cursor.All(c.Context(), &keys); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"statusCode": 500,
+			"message":    "Internal Server Error",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"items": keys,
+		"total": len(keys),
+	})
+}
+
+// CreateAPIKey crea una clave de API. La clave solo se devuelve en esta respuesta.
+func CreateAPIKey(c *fiber.Ctx) error {
+	var body struct {
+		Name      string     `json:"name"`
+		Scopes    []string   `json:"scopes"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"statusCode": 400,
+			"message":    "Invalid request body",
+		})
+	}
+	if body.Name == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"statusCode": 400,
+			"message":    "Name is required",
+		})
+	}
+	if len(body.Scopes) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"statusCode": 400,
+			"message":    "At least one scope is required",
+		})
+	}
+	for _, scope := range body.Scopes {
+		if !models.IsValidPermission(scope) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"statusCode": 400,
+				"message":    "Invalid scope " + scope,
+			})
+		}
+	}
+	if body.ExpiresAt != nil && body.ExpiresAt.Before(time.Now()) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"statusCode": 400,
+			"message":    "expires_at must be in the future",
+		})
+	}
+
+	createdBy, err := primitive.ObjectIDFromHex(middleware.CurrentUser(c).Subject)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"statusCode": 401,
+			"message":    "Invalid token",
+		})
+	}
+
+	secret, err := utils.RandomToken(32)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"statusCode": 500,
+			"message":    "Internal Server Error",
+		})
+	}
+	rawKey := apiKeyPrefix + secret
+
+	key := models.APIKey{
+		ID:        primitive.NewObjectID(),
+		Name:      body.Name,
+		Prefix:    rawKey[:len(apiKeyPrefix)+8],
+		KeyHash:   utils.HashToken(rawKey),
+		Scopes:    body.Scopes,
+		CreatedBy: createdBy,
+		CreatedAt: time.Now(),
+		ExpiresAt: body.ExpiresAt,
+	}
+	if _, err := database.Mg.Db.Collection("api_keys").InsertOne(c.Context(), key); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"statusCode": 500,
+			"message":    "Internal Server Error",
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"statusCode": 201,
+		"message":    "Store the key now, it will not be shown again",
+		"key":        rawKey,
+		"api_key":    key,
+	})
+}
+
+// RevokeAPIKey revoca una clave de API
+func RevokeAPIKey(c *fiber.Ctx) error {
+	id := c.Params("id")
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"statusCode": 400,
+			"message":    "Invalid ID",
+		})
+	}
+
+	res, err := database.Mg.Db.Collection("api_keys").UpdateOne(c.Context(),
+		bson.M{"_id": objectId, "revoked_at": nil},
+		bson.M{"$set": bson.M{"revoked_at": time.Now()}},
+	)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"statusCode": 500,
+			"message":    "Internal Server Error",
+		})
+	}
+	if res.MatchedCount == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"statusCode": 404,
+			"message":    "API key not found",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"statusCode": 200,
+		"message":    "API key revoked",
+		"id":         id,
+	})
+}
@@ -4,19 +4,20 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"main/config"
 	"main/database"
+	"main/middleware"
 	"main/models"
+	"main/utils"
+	"sync"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/golang-jwt/jwt/v4"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"golang.org/x/crypto/bcrypt"
 )
 
-const secretKey = "my_secret_key"
-
 func checkUsernameExists(username string) bool {
 
 	// Seleccionar la colección de usuarios en la base de datos
@@ -56,6 +57,43 @@ func FindUserByUsername(email string) (*models.Users, error) {
 	return &user, nil
 }
 
+// Hash con el que se compara la contraseña cuando la cuenta no existe
+var (
+	dummyHashOnce sync.Once
+	dummyHash     string
+)
+
+// checkPassword compara la contraseña con el hash de la cuenta y, si el hash usa un
+// algoritmo o parámetros anticuados, lo actualiza. Sin hash (la cuenta no existe o no
+// tiene contraseña) compara con un hash falso para que el tiempo de respuesta sea el mismo.
+func checkPassword(ctx context.Context, collection string, id primitive.ObjectID, encoded string, password string) bool {
+	if encoded == "" {
+		dummyHashOnce.Do(func() {
+			dummyHash, _ = utils.Passwords.Hash("dummy-password")
+		})
+		utils.Passwords.Verify(password, dummyHash)
+		return false
+	}
+
+	ok, needsRehash, err := utils.Passwords.Verify(password, encoded)
+	if err != nil {
+		log.Println("password verify:", err)
+		return false
+	}
+	if ok && needsRehash {
+		hashed, err := utils.Passwords.Hash(password)
+		if err == nil {
+			_, err = database.Mg.Db.Collection(collection).UpdateOne(ctx, bson.M{"_id": id}, bson.M{
+				"$set": bson.M{"password": hashed},
+			})
+		}
+		if err != nil {
+			log.Println("password rehash:", err)
+		}
+	}
+	return ok
+}
+
 func Login(c *fiber.Ctx) error {
 	// Leer los datos del usuario de la solicitud
 	var user models.Users
@@ -65,34 +103,80 @@ func Login(c *fiber.Ctx) error {
 		})
 	}
 
+	// Rechazar si la cuenta o la IP tienen demasiados intentos fallidos
+	wait, err := checkLoginAllowed(c.Context(), models.RealmUsers, user.Email, c.IP())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"statusCode": 500,
+			"message":    "Internal Server Error",
+		})
+	}
+	if wait > 0 {
+		return loginLockedResponse(c, wait)
+	}
+
 	// Comprobar si existen los datos del usuario en la base de datos
 	filter := bson.M{"email": user.Email}
 	var dbUser models.Users
-	err := database.Mg.Db.Collection("users").FindOne(c.Context(), filter).Decode(&dbUser)
-	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-				"statusCode": 404,
-				"message":    "User not found",
-			})
-		}
+	err = database.Mg.Db.Collection("users").FindOne(c.Context(), filter).Decode(&dbUser)
+	if err != nil && err != mongo.ErrNoDocuments {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"statusCode": 500,
 			"message":    "Internal Server Error",
 		})
 	}
 
-	// Comparar la contraseña proporcionada con la contraseña hash almacenada en la base de datos
-	if err := bcrypt.CompareHashAndPassword([]byte(dbUser.Password), []byte(user.Password)); err != nil {
+	// Comparar la contraseña proporcionada con la contraseña hash almacenada en la base de datos.
+	// Si el usuario no existe se devuelve el mismo error.
+	if !checkPassword(c.Context(), "users", dbUser.ID, dbUser.Password, user.Password) {
+		if err := recordLoginFailure(c.Context(), models.RealmUsers, user.Email, c.IP()); err != nil {
+			log.Println("login attempts:", err)
+		}
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 			"statusCode": 401,
-			"message":    "Passwords do not match",
+			"message":    "Invalid email or password",
 		})
 	}
+	// Las cuentas sin verificar no entran, salvo que UNVERIFIED_LOGIN=limited
+	// les permita un token con scope limitado
+	if !dbUser.IsEmailVerified() && config.Config("UNVERIFIED_LOGIN") != "limited" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"statusCode": 403,
+			"message":    "Email not verified",
+		})
+	}
+
+	// Con 2FA activado se devuelve un challenge token en lugar del JWT
+	if dbUser.TOTPEnabled {
+		challengeToken, err := utils.GenerateChallengeToken(dbUser.ID.Hex())
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"statusCode": 500,
+				"message":    "Error generating token",
+			})
+		}
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"statusCode":          200,
+			"message":             "Two-factor authentication required",
+			"two_factor_required": true,
+			"challenge_token":     challengeToken,
+			"expires_in":          int(utils.ChallengeTokenDuration.Seconds()),
+		})
+	}
+
+	return completeLogin(c, dbUser)
+}
+
+// completeLogin crea la sesión y devuelve los tokens y el perfil del usuario
+func completeLogin(c *fiber.Ctx, dbUser models.Users) error {
+	// El login terminó bien, se borran los intentos fallidos de la cuenta
+	if err := resetLoginFailures(c.Context(), models.RealmUsers, dbUser.Email); err != nil {
+		log.Println("login attempts:", err)
+	}
 
 	// Buscar el perfil del usuario para devolverlo
 	var profile models.Profile
-	err = database.Mg.Db.Collection("profile").FindOne(c.Context(), bson.M{"user_id": dbUser.ID}).Decode(&profile)
+	err := database.Mg.Db.Collection("profile").FindOne(c.Context(), bson.M{"user_id": dbUser.ID}).Decode(&profile)
 	if err != nil {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 			"statusCode": 404,
@@ -100,61 +184,99 @@ func Login(c *fiber.Ctx) error {
 		})
 	}
 
-	// Crear un token JWT
-	token := jwt.New(jwt.SigningMethodHS256)
-	claims := token.Claims.(jwt.MapClaims)
-	claims["email"] = user.Email
-	claims["exp"] = time.Now().Add(time.Hour * 24).Unix()
-	claims["jti"] = user.Email
-	signedToken, err := token.SignedString([]byte(secretKey))
+	// Crear la sesión, el token JWT y el refresh token
+	familyID, err := createSession(c, dbUser.ID)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"statusCode": 500,
 			"message":    "Error generating token",
 		})
 	}
-
-	//Guardar el token JWT en la base de datos MongoDB
-	jwtToken := models.JWTToken{
-		Token: signedToken,
-	}
-	_, err = database.Mg.Db.Collection("jwt").InsertOne(c.Context(), jwtToken)
+	signedToken, refreshToken, err := issueTokens(c.Context(), dbUser, familyID)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"statusCode": 500,
-			"message":    "Internal Server Error",
+			"message":    "Error generating token",
 		})
 	}
 
 	// Devolver el token JWT en la respuesta
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"statusCode": 200,
-		"message":    "Login successfull",
-		"token":      signedToken,
-		"profile":    profile,
+		"statusCode":    200,
+		"message":       "Login successfull",
+		"token":         signedToken,
+		"refresh_token": refreshToken,
+		"expires_in":    int(utils.AccessTokenDuration.Seconds()),
+		"profile":       profile,
 	})
 }
 
 func Logout(c *fiber.Ctx) error {
-	// Obtener el token JWT de la solicitud
-	authHeader := c.Get("Authorization")
-	if authHeader == "" || len(authHeader) < 7 || authHeader[:7] != "Bearer " {
+	// El middleware ya validó el token y guardó sus claims
+	tokenString, _ := c.Locals("token").(string)
+	claims := middleware.CurrentUser(c)
+
+	// Las claves de API no tienen sesión que cerrar
+	if claims == nil || claims.APIKeyID != "" || claims.ExpiresAt == nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"statusCode": 400,
-			"message":    "Invalid authorization header",
+			"message":    "Only session tokens can log out",
 		})
 	}
-	tokenString := c.Get("Authorization")[7:] // El token JWT está en el header Authorization, después del prefijo "Bearer "
 
-	// Parsear el token JWT y validar la firma
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		// Verificar que se está usando el algoritmo de firma correcto
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	// Buscar el token JWT en la base de datos
+	var jwtToken models.JWTToken
+	err := database.Mg.Db.Collection("jwt").FindOne(c.Context(), bson.M{"token": tokenString}).Decode(&jwtToken)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"statusCode": 404,
+				"message":    "Token not found",
+			})
 		}
-		// Devolver la clave secreta usada para firmar el token
-		return []byte(secretKey), nil
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"statusCode": 500,
+			"message":    "Internal Server Error",
+		})
+	}
+
+	// Revocar el token y su familia de refresh tokens
+	if err := utils.RevokeToken(c.Context(), claims.ID, claims.ExpiresAt.Time); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"statusCode": 500,
+			"message":    "Internal Server Error",
+		})
+	}
+	if jwtToken.FamilyID != "" {
+		err = revokeTokenFamily(c.Context(), jwtToken.FamilyID)
+	} else {
+		_, err = database.Mg.Db.Collection("jwt").DeleteOne(c.Context(), bson.M{"_id": jwtToken.ID})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"statusCode": 500,
+			"message":    "Internal Server Error",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"statusCode": 200,
+		"message":    "Logout successful",
 	})
+}
+
+// LogoutAll cierra todas las sesiones del usuario autenticado
+func LogoutAll(c *fiber.Ctx) error {
+	claims := middleware.CurrentUser(c)
+
+	// Las claves de API no tienen sesión que cerrar
+	if claims == nil || claims.APIKeyID != "" || claims.ExpiresAt == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"statusCode": 400,
+			"message":    "Only session tokens can log out",
+		})
+	}
+	userID, err := primitive.ObjectIDFromHex(claims.Subject)
 	if err != nil {
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 			"statusCode": 401,
@@ -162,23 +284,256 @@ func Logout(c *fiber.Ctx) error {
 		})
 	}
 
-	//Eliminar token JWT de la base de datos
-	res, err := database.Mg.Db.Collection("jwt").DeleteOne(c.Context(), bson.M{"token": token})
+	if err := revokeUserSessions(c.Context(), userID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"statusCode": 500,
+			"message":    "Internal Server Error",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"statusCode": 200,
+		"message":    "Logged out of all sessions",
+	})
+}
+
+// issueTokens genera un access token y un refresh token de la familia indicada
+func issueTokens(ctx context.Context, user models.Users, familyID string) (string, string, error) {
+	signedToken, claims, err := utils.GenerateToken(user)
+	if err != nil {
+		return "", "", err
+	}
+	refreshToken, err := storeTokens(ctx, models.RealmUsers, user.ID, familyID, signedToken, claims)
+	if err != nil {
+		return "", "", err
+	}
+	return signedToken, refreshToken, nil
+}
+
+// issueCustomerTokens genera un access token y un refresh token de cliente de la familia indicada
+func issueCustomerTokens(ctx context.Context, customer models.Customer, familyID string) (string, string, error) {
+	customerID, err := primitive.ObjectIDFromHex(customer.ID)
 	if err != nil {
+		return "", "", err
+	}
+	signedToken, claims, err := utils.GenerateCustomerToken(customer)
+	if err != nil {
+		return "", "", err
+	}
+	refreshToken, err := storeTokens(ctx, models.RealmCustomers, customerID, familyID, signedToken, claims)
+	if err != nil {
+		return "", "", err
+	}
+	return signedToken, refreshToken, nil
+}
+
+// storeTokens guarda el access token y crea el refresh token que lo acompaña
+func storeTokens(ctx context.Context, realm string, accountID primitive.ObjectID, familyID string, signedToken string, claims *models.Claims) (string, error) {
+	// Guardar el token JWT en la base de datos MongoDB
+	jwtToken := models.JWTToken{
+		Token:     signedToken,
+		JTI:       claims.ID,
+		UserID:    accountID,
+		Realm:     realm,
+		FamilyID:  familyID,
+		ExpiresAt: claims.ExpiresAt.Time,
+	}
+	if _, err := database.Mg.Db.Collection("jwt").InsertOne(ctx, jwtToken); err != nil {
+		return "", err
+	}
+
+	// Guardar solo el hash del refresh token
+	refreshToken, err := utils.RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	_, err = database.Mg.Db.Collection("refresh_tokens").InsertOne(ctx, models.RefreshToken{
+		TokenHash: utils.HashToken(refreshToken),
+		FamilyID:  familyID,
+		UserID:    accountID,
+		Realm:     realm,
+		CreatedAt: now,
+		ExpiresAt: now.Add(utils.RefreshTokenDuration),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return refreshToken, nil
+}
+
+// revokeTokenFamily invalida todos los refresh tokens y access tokens de la familia
+func revokeTokenFamily(ctx context.Context, familyID string) error {
+	return revokeTokens(ctx, bson.M{"family_id": familyID})
+}
+
+// revokeUserSessions invalida todos los refresh tokens y access tokens del usuario
+func revokeUserSessions(ctx context.Context, userID primitive.ObjectID) error {
+	return revokeTokens(ctx, bson.M{"user_id": userID})
+}
+
+func revokeTokens(ctx context.Context, filter bson.M) error {
+	_, err := database.Mg.Db.Collection("refresh_tokens").UpdateMany(ctx, filter, bson.M{"$set": bson.M{"revoked": true}})
+	if err != nil {
+		return err
+	}
+	_, err = database.Mg.Db.Collection("sessions").DeleteMany(ctx, filter)
+	if err != nil {
+		return err
+	}
+
+	// Añadir los access tokens a la lista de revocados antes de borrarlos
+	cursor, err := database.Mg.Db.Collection("jwt").Find(ctx, filter)
+	if err != nil {
+		return err
+	}
+	var tokens []models.JWTToken
+	if err := cursor.All(ctx, &tokens); err != nil {
+		return err
+	}
+	for _, token := range tokens {
+		if token.JTI == "" {
+			continue
+		}
+		if err := utils.RevokeToken(ctx, token.JTI, token.ExpiresAt); err != nil {
+			return err
+		}
+	}
+
+	_, err = database.Mg.Db.Collection("jwt").DeleteMany(ctx, filter)
+	return err
+}
+
+func Refresh(c *fiber.Ctx) error {
+	return refreshTokens(c, models.RealmUsers)
+}
+
+// refreshTokens cambia un refresh token del realm por un nuevo par de tokens
+func refreshTokens(c *fiber.Ctx, realm string) error {
+	var body struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := c.BodyParser(&body); err != nil || body.RefreshToken == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"statusCode": 400,
+			"message":    "Invalid request body",
+		})
+	}
+
+	collection := database.Mg.Db.Collection("refresh_tokens")
+
+	var stored models.RefreshToken
+	err := collection.FindOne(c.Context(), bson.M{"token_hash": utils.HashToken(body.RefreshToken)}).Decode(&stored)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"statusCode": 401,
+				"message":    "Invalid refresh token",
+			})
+		}
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"statusCode": 500,
 			"message":    "Internal Server Error",
 		})
 	}
-	if res.DeletedCount == 0 {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"statusCode": 404,
-			"message":    "Token not found",
+
+	// Un refresh token de otro realm no sirve aquí
+	storedRealm := stored.Realm
+	if storedRealm == "" {
+		storedRealm = models.RealmUsers
+	}
+	if storedRealm != realm {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"statusCode": 401,
+			"message":    "Invalid refresh token",
+		})
+	}
+
+	// Un refresh token que ya se usó indica que lo han robado: se revoca toda la familia
+	if stored.Revoked || stored.UsedAt != nil {
+		if err := revokeTokenFamily(c.Context(), stored.FamilyID); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"statusCode": 500,
+				"message":    "Internal Server Error",
+			})
+		}
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"statusCode": 401,
+			"message":    "Invalid refresh token",
 		})
 	}
 
+	if time.Now().After(stored.ExpiresAt) {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"statusCode": 401,
+			"message":    "Refresh token expired",
+		})
+	}
+
+	// Marcar el refresh token como usado. Si otra petición se adelantó también se trata como reutilización
+	now := time.Now()
+	res, err := collection.UpdateOne(c.Context(),
+		bson.M{"_id": stored.ID, "used_at": nil},
+		bson.M{"$set": bson.M{"used_at": now}},
+	)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"statusCode": 500,
+			"message":    "Internal Server Error",
+		})
+	}
+	if res.ModifiedCount == 0 {
+		if err := revokeTokenFamily(c.Context(), stored.FamilyID); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"statusCode": 500,
+				"message":    "Internal Server Error",
+			})
+		}
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"statusCode": 401,
+			"message":    "Invalid refresh token",
+		})
+	}
+
+	// Emitir los tokens con los datos actuales de la cuenta
+	var signedToken, refreshToken string
+	if realm == models.RealmCustomers {
+		var customer models.Customer
+		err = database.Mg.Db.Collection("customers").FindOne(c.Context(), bson.M{"_id": stored.UserID}).Decode(&customer)
+		if err == nil {
+			signedToken, refreshToken, err = issueCustomerTokens(c.Context(), customer, stored.FamilyID)
+		}
+	} else {
+		var user models.Users
+		err = database.Mg.Db.Collection("users").FindOne(c.Context(), bson.M{"_id": stored.UserID}).Decode(&user)
+		if err == nil {
+			signedToken, refreshToken, err = issueTokens(c.Context(), user, stored.FamilyID)
+		}
+	}
+	if err == mongo.ErrNoDocuments {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"statusCode": 401,
+			"message":    "Invalid refresh token",
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"statusCode": 500,
+			"message":    "Error generating token",
+		})
+	}
+
+	// Actualizar el último uso de la sesión
+	database.Mg.Db.Collection("sessions").UpdateOne(c.Context(), bson.M{"family_id": stored.FamilyID}, bson.M{
+		"$set": bson.M{"last_used_at": now, "ip": c.IP()},
+	})
+
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"statusCode": 200,
-		"message":    "Logout successful",
+		"statusCode":    200,
+		"message":       "Token refreshed",
+		"token":         signedToken,
+		"refresh_token": refreshToken,
+		"expires_in":    int(utils.AccessTokenDuration.Seconds()),
 	})
 }
@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"main/database"
+	"main/models"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Campos de administración que no se muestran en el catálogo
+var catalogProjection = bson.M{"show": 0, "publish_at": 0, "unpublish_at": 0, "low_stock_threshold": 0}
+
+// catalogItem es un producto tal como se ve en el catálogo público, sin los campos
+// de administración
+type catalogItem struct {
+	ID          string                 `json:"id"`
+	Name        string                 `json:"name"`
+	Slug        string                 `json:"slug,omitempty"`
+	CategoryID  *primitive.ObjectID    `json:"category_id,omitempty"`
+	Category    string                 `json:"category"`
+	Image       string                 `json:"image"`
+	Description string                 `json:"description"`
+	Price       models.Money           `json:"price"`
+	Stock       int                    `json:"stock"`
+	Options     []models.ProductOption `json:"options,omitempty"`
+}
+
+func newCatalogItem(product models.Product) catalogItem {
+	return catalogItem{
+		ID:          product.ID,
+		Name:        product.Name,
+		Slug:        product.Slug,
+		CategoryID:  product.CategoryID,
+		Category:    product.Category,
+		Image:       product.Image,
+		Description: product.Description,
+		Price:       product.Price,
+		Stock:       product.Stock,
+		Options:     product.Options,
+	}
+}
+
+// catalogProduct es la ficha de un producto del catálogo con sus variantes
+type catalogProduct struct {
+	catalogItem
+	Variants []models.Variant `json:"variants"`
+}
+
+type catalogResponse struct {
+	Total      int64         `json:"total"`
+	Page       int           `json:"page,omitempty"`
+	Limit      int           `json:"limit"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+	Items      []catalogItem `json:"items"`
+}
+
+// Campos por los que se puede ordenar el catálogo
+var catalogListOptions = listOptions{
+	sortable: map[string]string{
+		"name":  "name",
+		"price": "price.amount",
+	},
+	defaultSort: "name",
+}
+
+// visibleProducts es el filtro de los productos que se ven en el catálogo en el momento now:
+// show=true y dentro de las fechas de publicación si las tienen
+func visibleProducts(now time.Time) bson.M {
+	return bson.M{
+		"show": true,
+		"$and": bson.A{
+			bson.M{"$or": bson.A{bson.M{"publish_at": nil}, bson.M{"publish_at": bson.M{"$lte": now}}}},
+			bson.M{"$or": bson.A{bson.M{"unpublish_at": nil}, bson.M{"unpublish_at": bson.M{"$gt": now}}}},
+		},
+	}
+}
+
+// catalogPricing lee el parámetro currency del catálogo. Responde 400 si la moneda
+// no se puede usar; entonces devuelve nil.
+func catalogPricing(c *fiber.Ctx) (*pricing, error) {
+	prices, problem := newPricing(c.Query("currency"))
+	if problem != "" {
+		return nil, invalidQueryError(c, []models.FieldError{{Field: "currency", Code: "invalid", Message: problem}})
+	}
+	return prices, nil
+}
+
+// GetCatalog lista los productos visibles del catálogo público con paginación,
+// orden y filtros por category, min_price y max_price. Los precios se devuelven en la
+// moneda de currency, pero el orden y los filtros usan el precio en la moneda base.
+func GetCatalog(c *fiber.Ctx) error {
+	prices, err := catalogPricing(c)
+	if prices == nil {
+		return err
+	}
+
+	query, errs := parseListQuery(c, catalogListOptions)
+
+	query.Filter = visibleProducts(time.Now())
+	if category := c.Query("category"); category != "" {
+		condition, err := categoryCondition(c.Context(), category)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(models.Error{Message: "Internal Server Error", StatusCode: 500})
+		}
+		query.Filter["category_id"] = condition
+	}
+	price := bson.M{}
+	if min, ok := parseAmountFilter(c, "min_price", &errs); ok {
+		price["$gte"] = min
+	}
+	if max, ok := parseAmountFilter(c, "max_price", &errs); ok {
+		price["$lte"] = max
+	}
+	if len(price) > 0 {
+		query.Filter["price.amount"] = price
+	}
+	if len(errs) > 0 {
+		return invalidQueryError(c, errs)
+	}
+
+	products := make([]models.Product, 0)
+	page, err := query.Find(c.Context(), database.Mg.Db.Collection("Products"), catalogProjection, &products)
+	if err != nil {
+		return findErrorResponse(c, err)
+	}
+	items := make([]catalogItem, 0, len(products))
+	for i := range products {
+		if err := prices.apply(c.Context(), &products[i]); err != nil {
+			return pricingError(c, prices, err)
+		}
+		items = append(items, newCatalogItem(products[i]))
+	}
+
+	return c.JSON(catalogResponse{
+		Items:      items,
+		Total:      page.Total,
+		Page:       page.Page,
+		Limit:      page.Limit,
+		NextCursor: page.NextCursor,
+	})
+}
+
+// GetCatalogProduct devuelve un producto visible del catálogo por su ID o su slug, con
+// los precios en la moneda de currency
+func GetCatalogProduct(c *fiber.Ctx) error {
+	prices, err := catalogPricing(c)
+	if prices == nil {
+		return err
+	}
+	ref := c.Params("ref")
+
+	filter := visibleProducts(time.Now())
+	if id, err := primitive.ObjectIDFromHex(ref); err == nil {
+		filter["$or"] = bson.A{bson.M{"_id": id}, bson.M{"slug": ref}}
+	} else {
+		filter["slug"] = ref
+	}
+
+	var product models.Product
+	err = database.Mg.Db.Collection("Products").FindOne(c.Context(), filter,
+		options.FindOne().SetProjection(catalogProjection),
+	).Decode(&product)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(fiber.StatusNotFound).JSON(models.Error{Message: "Not Found", StatusCode: 404})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(models.Error{Message: "Internal Server Error", StatusCode: 500})
+	}
+
+	productID, _ := primitive.ObjectIDFromHex(product.ID)
+	variants, err := loadVariants(c.Context(), productID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.Error{Message: "Internal Server Error", StatusCode: 500})
+	}
+
+	if err := prices.apply(c.Context(), &product); err != nil {
+		return pricingError(c, prices, err)
+	}
+	for i := range variants {
+		if err := prices.applyVariant(c.Context(), &variants[i]); err != nil {
+			return pricingError(c, prices, err)
+		}
+	}
+
+	return c.JSON(catalogProduct{catalogItem: newCatalogItem(product), Variants: variants})
+}
+
+// GetCatalogCategories devuelve el árbol de categorías con productos visibles y
+// cuántos tiene cada una
+func GetCatalogCategories(c *fiber.Ctx) error {
+	tree, err := categoryTree(c.Context(), visibleProducts(time.Now()))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.Error{Message: "Internal Server Error", StatusCode: 500})
+	}
+	return c.JSON(fiber.Map{"items": pruneEmptyCategories(tree)})
+}
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"main/database"
+	"main/models"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// Campos de administración que no pueden salir en el JSON del catálogo
+var adminFields = []string{"show", "publish_at", "unpublish_at", "low_stock_threshold", "prices"}
+
+// Producto tal como está en la BD, con todos los campos de administración
+func storedProduct(id primitive.ObjectID) bson.D {
+	return bson.D{
+		{Key: "_id", Value: id},
+		{Key: "name", Value: "Camiseta"},
+		{Key: "slug", Value: "camiseta"},
+		{Key: "category", Value: "Ropa"},
+		{Key: "image", Value: ""},
+		{Key: "description", Value: "Camiseta de algodón"},
+		{Key: "price", Value: bson.D{{Key: "amount", Value: int64(1999)}, {Key: "currency", Value: "EUR"}}},
+		{Key: "prices", Value: bson.A{bson.D{{Key: "amount", Value: int64(2199)}, {Key: "currency", Value: "USD"}}}},
+		{Key: "show", Value: true},
+		{Key: "stock", Value: 5},
+		{Key: "low_stock_threshold", Value: 2},
+		{Key: "publish_at", Value: time.Now().Add(-time.Hour)},
+		{Key: "unpublish_at", Value: time.Now().Add(time.Hour)},
+	}
+}
+
+func getJSON(t *testing.T, app *fiber.App, url string) map[string]interface{} {
+	t.Helper()
+	res, err := app.Test(httptest.NewRequest("GET", url, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	if res.StatusCode != fiber.StatusOK {
+		t.Fatalf("status = %d, body = %s", res.StatusCode, body)
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	return decoded
+}
+
+func checkCatalogItem(t *testing.T, item map[string]interface{}) {
+	t.Helper()
+	for _, field := range adminFields {
+		if _, ok := item[field]; ok {
+			t.Errorf("catalog item has %q: %v", field, item)
+		}
+	}
+	for _, field := range []string{"id", "name", "slug", "category", "price", "stock"} {
+		if _, ok := item[field]; !ok {
+			t.Errorf("catalog item has no %q: %v", field, item)
+		}
+	}
+}
+
+func TestCatalogJSON(t *testing.T) {
+	models.BaseCurrency = "EUR"
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	app := fiber.New()
+	app.Get("/api/catalog", GetCatalog)
+	app.Get("/api/catalog/:ref", GetCatalogProduct)
+
+	mt.Run("list", func(mt *mtest.T) {
+		database.Mg.Db = mt.DB
+		ns := mt.DB.Name() + ".Products"
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, bson.D{{Key: "n", Value: 1}}),
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, storedProduct(primitive.NewObjectID())),
+		)
+
+		body := getJSON(mt.T, app, "/api/catalog")
+		items, _ := body["items"].([]interface{})
+		if len(items) != 1 {
+			mt.Fatalf("items = %v", body["items"])
+		}
+		checkCatalogItem(mt.T, items[0].(map[string]interface{}))
+	})
+
+	mt.Run("product", func(mt *mtest.T) {
+		database.Mg.Db = mt.DB
+		ns := mt.DB.Name() + ".Products"
+		id := primitive.NewObjectID()
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, storedProduct(id)),
+			mtest.CreateCursorResponse(0, mt.DB.Name()+".variants", mtest.FirstBatch),
+		)
+
+		body := getJSON(mt.T, app, "/api/catalog/"+id.Hex())
+		checkCatalogItem(mt.T, body)
+		if body["id"] != id.Hex() {
+			mt.Errorf("id = %v, want %s", body["id"], id.Hex())
+		}
+		if _, ok := body["variants"].([]interface{}); !ok {
+			mt.Errorf("variants = %v", body["variants"])
+		}
+	})
+}
+
+func TestCatalogCurrency(t *testing.T) {
+	models.BaseCurrency = "EUR"
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	app := fiber.New()
+	app.Get("/api/catalog/:ref", GetCatalogProduct)
+	id := primitive.NewObjectID()
+
+	tests := []struct {
+		name     string
+		currency string
+		rates    []bson.D
+		status   int
+		price    float64
+	}{
+		// El cambio solo hace falta si el producto no tiene precio en la moneda
+		{"fixed price without rate", "usd", nil, fiber.StatusOK, 2199},
+		{"converted", "GBP", []bson.D{{{Key: "_id", Value: "GBP"}, {Key: "rate", Value: "0.85"}}}, fiber.StatusOK, 1699},
+		{"no rate", "GBP", []bson.D{}, fiber.StatusBadRequest, 0},
+		{"unsupported", "XXX", nil, fiber.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			database.Mg.Db = mt.DB
+			if tt.currency != "XXX" {
+				mt.AddMockResponses(
+					mtest.CreateCursorResponse(0, mt.DB.Name()+".Products", mtest.FirstBatch, storedProduct(id)),
+					mtest.CreateCursorResponse(0, mt.DB.Name()+".variants", mtest.FirstBatch),
+				)
+			}
+			if tt.rates != nil {
+				mt.AddMockResponses(mtest.CreateCursorResponse(0, mt.DB.Name()+".exchange_rates", mtest.FirstBatch, tt.rates...))
+			}
+
+			res, err := app.Test(httptest.NewRequest("GET", "/api/catalog/"+id.Hex()+"?currency="+tt.currency, nil))
+			if err != nil {
+				mt.Fatal(err)
+			}
+			body, _ := ioutil.ReadAll(res.Body)
+			if res.StatusCode != tt.status {
+				mt.Fatalf("status = %d, body = %s, want %d", res.StatusCode, body, tt.status)
+			}
+			if tt.status != fiber.StatusOK {
+				return
+			}
+			var decoded struct {
+				Price map[string]interface{} `json:"price"`
+			}
+			if err := json.Unmarshal(body, &decoded); err != nil {
+				mt.Fatal(err)
+			}
+			if decoded.Price["amount"] != tt.price {
+				mt.Errorf("price = %v, want %v", decoded.Price, tt.price)
+			}
+			for _, event := range mt.GetAllStartedEvents() {
+				if event.Command.Lookup(event.CommandName).StringValue() == "exchange_rates" && tt.rates == nil {
+					mt.Error("exchange rate read for a product with a fixed price")
+				}
+			}
+		})
+	}
+}
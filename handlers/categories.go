@@ -0,0 +1,439 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"main/database"
+	"main/models"
+	"main/utils"
+	"sort"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// errUnknownCategory se devuelve cuando un producto referencia una categoría que no existe
+var errUnknownCategory = errors.New("unknown category")
+
+// loadCategories devuelve todas las categorías
+func loadCategories(ctx context.Context) ([]models.Category, error) {
+	cursor, err := database.Mg.Db.Collection("categories").Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	categories := make([]models.Category, 0)
+	if err := cursor.All(ctx, &categories); err != nil {
+		return nil, err
+	}
+	return categories, nil
+}
+
+// findCategory busca una categoría por su ID o su slug. También vale el nombre, que
+// se convierte en slug.
+func findCategory(ctx context.Context, ref string) (*models.Category, error) {
+	filter := bson.M{"slug": bson.M{"$in": bson.A{ref, utils.Slugify(ref)}}}
+	if id, err := primitive.ObjectIDFromHex(ref); err == nil {
+		filter = bson.M{"$or": bson.A{bson.M{"_id": id}, filter}}
+	}
+	var category models.Category
+	if err := database.Mg.Db.Collection("categories").FindOne(ctx, filter).Decode(&category); err != nil {
+		return nil, err
+	}
+	return &category, nil
+}
+
+// categoryWithDescendants devuelve el ID de la categoría y los de todas sus subcategorías
+func categoryWithDescendants(categories []models.Category, id primitive.ObjectID) []primitive.ObjectID {
+	children := make(map[primitive.ObjectID][]primitive.ObjectID)
+	for _, category := range categories {
+		if category.ParentID != nil {
+			children[*category.ParentID] = append(children[*category.ParentID], category.ID)
+		}
+	}
+
+	ids := []primitive.ObjectID{id}
+	for i := 0; i < len(ids); i++ {
+		ids = append(ids, children[ids[i]]...)
+	}
+	return ids
+}
+
+// categoryIDs devuelve los IDs de la categoría ref (ID o slug) y de sus subcategorías.
+// Si la categoría no existe la lista está vacía.
+func categoryIDs(ctx context.Context, ref string) ([]primitive.ObjectID, error) {
+	category, err := findCategory(ctx, ref)
+	if err == mongo.ErrNoDocuments {
+		return []primitive.ObjectID{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	categories, err := loadCategories(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return categoryWithDescendants(categories, category.ID), nil
+}
+
+// categoryCondition devuelve la condición sobre category_id de los productos de la
+// categoría ref (ID o slug) y sus subcategorías. Una categoría que no existe no tiene productos.
+func categoryCondition(ctx context.Context, ref string) (bson.M, error) {
+	ids, err := categoryIDs(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	return bson.M{"$in": ids}, nil
+}
+
+// assignProductCategory comprueba la categoría del producto y copia su nombre. Si solo
+// viene el nombre (clientes antiguos) se busca la categoría por su slug.
+func assignProductCategory(ctx context.Context, product *models.Product) error {
+	var category models.Category
+	switch {
+	case product.CategoryID != nil:
+		err := database.Mg.Db.Collection("categories").FindOne(ctx, bson.M{"_id": *product.CategoryID}).Decode(&category)
+		if err == mongo.ErrNoDocuments {
+			return errUnknownCategory
+		}
+		if err != nil {
+			return err
+		}
+	case product.Category != "":
+		err := database.Mg.Db.Collection("categories").FindOne(ctx, bson.M{"slug": utils.Slugify(product.Category)}).Decode(&category)
+		if err == mongo.ErrNoDocuments {
+			return errUnknownCategory
+		}
+		if err != nil {
+			return err
+		}
+	default:
+		return nil
+	}
+
+	product.CategoryID = &category.ID
+	product.Category = category.Name
+	return nil
+}
+
+// buildCategoryTree arma el árbol de categorías con el número de productos de cada una
+func buildCategoryTree(categories []models.Category, counts map[primitive.ObjectID]int) []*models.CategoryNode {
+	nodes := make(map[primitive.ObjectID]*models.CategoryNode, len(categories))
+	for _, category := range categories {
+		nodes[category.ID] = &models.CategoryNode{
+			Category:     category,
+			ProductCount: counts[category.ID],
+			Children:     make([]*models.CategoryNode, 0),
+		}
+	}
+
+	roots := make([]*models.CategoryNode, 0)
+	for _, category := range categories {
+		node := nodes[category.ID]
+		if category.ParentID != nil {
+			if parent, ok := nodes[*category.ParentID]; ok {
+				parent.Children = append(parent.Children, node)
+				continue
+			}
+		}
+		roots = append(roots, node)
+	}
+
+	sortCategoryNodes(roots)
+	for _, root := range roots {
+		sumCategoryCounts(root)
+	}
+	return roots
+}
+
+func sortCategoryNodes(nodes []*models.CategoryNode) {
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].SortOrder != nodes[j].SortOrder {
+			return nodes[i].SortOrder < nodes[j].SortOrder
+		}
+		return nodes[i].Name < nodes[j].Name
+	})
+	for _, node := range nodes {
+		sortCategoryNodes(node.Children)
+	}
+}
+
+func sumCategoryCounts(node *models.CategoryNode) int {
+	node.TotalCount = node.ProductCount
+	for _, child := range node.Children {
+		node.TotalCount += sumCategoryCounts(child)
+	}
+	return node.TotalCount
+}
+
+// pruneEmptyCategories quita del árbol las categorías sin productos
+func pruneEmptyCategories(nodes []*models.CategoryNode) []*models.CategoryNode {
+	kept := make([]*models.CategoryNode, 0, len(nodes))
+	for _, node := range nodes {
+		if node.TotalCount == 0 {
+			continue
+		}
+		node.Children = pruneEmptyCategories(node.Children)
+		kept = append(kept, node)
+	}
+	return kept
+}
+
+// countProductsByCategory cuenta los productos del filtro por categoría
+func countProductsByCategory(ctx context.Context, filter bson.M) (map[primitive.ObjectID]int, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$group", Value: bson.M{"_id": "$category_id", "count": bson.M{"$sum": 1}}}},
+	}
+	cursor, err := database.Mg.Db.Collection("Products").Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	var groups []struct {
+		CategoryID *primitive.ObjectID `bson:"_id"`
+		Count      int                 `bson:"count"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+
+	counts := make(map[primitive.ObjectID]int, len(groups))
+	for _, g := range groups {
+		if g.CategoryID != nil {
+			counts[*g.CategoryID] = g.Count
+		}
+	}
+	return counts, nil
+}
+
+// categoryTree devuelve el árbol de categorías con los productos que cumplen el filtro
+func categoryTree(ctx context.Context, filter bson.M) ([]*models.CategoryNode, error) {
+	categories, err := loadCategories(ctx)
+	if err != nil {
+		return nil, err
+	}
+	counts, err := countProductsByCategory(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	return buildCategoryTree(categories, counts), nil
+}
+
+// GetCategories lista las categorías ordenadas por sort_order y nombre
+func GetCategories(c *fiber.Ctx) error {
+	categories, err := loadCategories(c.Context())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.Error{Message: "Internal Server Error", StatusCode: 500})
+	}
+	sort.Slice(categories, func(i, j int) bool {
+		if categories[i].SortOrder != categories[j].SortOrder {
+			return categories[i].SortOrder < categories[j].SortOrder
+		}
+		return categories[i].Name < categories[j].Name
+	})
+	return c.JSON(fiber.Map{"items": categories, "total": len(categories)})
+}
+
+// GetCategoryTree devuelve el árbol de categorías con el número de productos de cada una
+func GetCategoryTree(c *fiber.Ctx) error {
+	tree, err := categoryTree(c.Context(), bson.M{})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.Error{Message: "Internal Server Error", StatusCode: 500})
+	}
+	return c.JSON(fiber.Map{"items": tree})
+}
+
+// GetCategory devuelve una categoría por su ID o su slug
+func GetCategory(c *fiber.Ctx) error {
+	category, err := findCategory(c.Context(), c.Params("ref"))
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(fiber.StatusNotFound).JSON(models.Error{Message: "Not Found", StatusCode: 404})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(models.Error{Message: "Internal Server Error", StatusCode: 500})
+	}
+	return c.JSON(category)
+}
+
+// validateCategoryParent comprueba que el padre exista y que no sea la propia
+// categoría ni una de sus subcategorías
+func validateCategoryParent(ctx context.Context, id primitive.ObjectID, parentID *primitive.ObjectID) (string, error) {
+	if parentID == nil {
+		return "", nil
+	}
+	categories, err := loadCategories(ctx)
+	if err != nil {
+		return "", err
+	}
+	found := false
+	for _, category := range categories {
+		if category.ID == *parentID {
+			found = true
+		}
+	}
+	if !found {
+		return "Unknown parent category", nil
+	}
+	if !id.IsZero() {
+		for _, descendant := range categoryWithDescendants(categories, id) {
+			if descendant == *parentID {
+				return "A category cannot be moved under itself or its subcategories", nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// CreateCategory crea una categoría
+func CreateCategory(c *fiber.Ctx) error {
+	var category models.Category
+	if err := c.BodyParser(&category); err != nil || category.Name == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.Error{Message: "Invalid request body, name is required", StatusCode: 400})
+	}
+	category.ID = primitive.NilObjectID
+
+	message, err := validateCategoryParent(c.Context(), category.ID, category.ParentID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.Error{Message: "Internal Server Error", StatusCode: 500})
+	}
+	if message != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.Error{Message: message, StatusCode: 400})
+	}
+
+	collection := database.Mg.Db.Collection("categories")
+	slugSource := category.Slug
+	if slugSource == "" {
+		slugSource = category.Name
+	}
+	category.Slug, err = utils.UniqueSlug(c.Context(), collection, slugSource, nil)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.Error{Message: "Internal Server Error", StatusCode: 500})
+	}
+
+	res, err := collection.InsertOne(c.Context(), category)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.Error{Message: "Internal Server Error", StatusCode: 500})
+	}
+	category.ID = res.InsertedID.(primitive.ObjectID)
+
+	return c.Status(fiber.StatusCreated).JSON(category)
+}
+
+// UpdateCategory cambia una categoría. Si cambia el nombre se actualiza en sus productos.
+func UpdateCategory(c *fiber.Ctx) error {
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.Error{Message: "Invalid ID", StatusCode: 400})
+	}
+
+	var body models.Category
+	if err := c.BodyParser(&body); err != nil || body.Name == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.Error{Message: "Invalid request body, name is required", StatusCode: 400})
+	}
+
+	collection := database.Mg.Db.Collection("categories")
+	var current models.Category
+	if err := collection.FindOne(c.Context(), bson.M{"_id": id}).Decode(&current); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(fiber.StatusNotFound).JSON(models.Error{Message: "Not Found", StatusCode: 404})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(models.Error{Message: "Internal Server Error", StatusCode: 500})
+	}
+
+	message, err := validateCategoryParent(c.Context(), id, body.ParentID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.Error{Message: "Internal Server Error", StatusCode: 500})
+	}
+	if message != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.Error{Message: message, StatusCode: 400})
+	}
+
+	// El slug solo cambia si se envía, para no romper los enlaces del catálogo
+	slug := current.Slug
+	if body.Slug != "" && body.Slug != current.Slug {
+		slug, err = utils.UniqueSlug(c.Context(), collection, body.Slug, id)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(models.Error{Message: "Internal Server Error", StatusCode: 500})
+		}
+	}
+
+	updated := models.Category{
+		ID:        id,
+		Name:      body.Name,
+		Slug:      slug,
+		ParentID:  body.ParentID,
+		SortOrder: body.SortOrder,
+		Image:     body.Image,
+	}
+	_, err = collection.UpdateOne(c.Context(), bson.M{"_id": id}, bson.M{"$set": bson.M{
+		"name":       updated.Name,
+		"slug":       updated.Slug,
+		"parent_id":  updated.ParentID,
+		"sort_order": updated.SortOrder,
+		"image":      updated.Image,
+	}})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.Error{Message: "Internal Server Error", StatusCode: 500})
+	}
+
+	if updated.Name != current.Name {
+		if err := renameProductsCategory(c.Context(), id, updated.Name); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(models.Error{Message: "Internal Server Error", StatusCode: 500})
+		}
+	}
+
+	return c.JSON(updated)
+}
+
+// renameProductsCategory copia el nuevo nombre de la categoría en sus productos y los reindexa
+func renameProductsCategory(ctx context.Context, id primitive.ObjectID, name string) error {
+	collection := database.Mg.Db.Collection("Products")
+	filter := bson.M{"category_id": id}
+	if _, err := collection.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"category": name}}); err != nil {
+		return err
+	}
+
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		return err
+	}
+	var products []models.Product
+	if err := cursor.All(ctx, &products); err != nil {
+		return err
+	}
+	for _, product := range products {
+		reindexProduct(ctx, product.ID)
+	}
+	return nil
+}
+
+// DeleteCategory borra una categoría que no tenga subcategorías ni productos
+func DeleteCategory(c *fiber.Ctx) error {
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.Error{Message: "Invalid ID", StatusCode: 400})
+	}
+
+	children, err := database.Mg.Db.Collection("categories").CountDocuments(c.Context(), bson.M{"parent_id": id})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.Error{Message: "Internal Server Error", StatusCode: 500})
+	}
+	products, err := database.Mg.Db.Collection("Products").CountDocuments(c.Context(), bson.M{"category_id": id})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.Error{Message: "Internal Server Error", StatusCode: 500})
+	}
+	if children > 0 || products > 0 {
+		return c.Status(fiber.StatusConflict).JSON(models.Error{Message: "Category has subcategories or products", StatusCode: 409})
+	}
+
+	result, err := database.Mg.Db.Collection("categories").DeleteOne(c.Context(), bson.M{"_id": id})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.Error{Message: "Internal Server Error", StatusCode: 500})
+	}
+	if result.DeletedCount == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(models.Error{Message: "Not Found", StatusCode: 404})
+	}
+
+	return c.JSON(fiber.Map{"statusCode": 200, "message": "Category deleted successfully", "id": id.Hex()})
+}
@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"fmt"
+	"main/models"
+	"sort"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// testCategories arma un catálogo de prueba:
+//
+//	Ropa (1) > Camisetas (2) > Manga corta (3)
+//	         > Pantalones (4)
+//	Hogar (5) > Cocina (6)
+//	Libros (0)
+func testCategories() ([]models.Category, map[string]primitive.ObjectID) {
+	ids := map[string]primitive.ObjectID{}
+	category := func(name string, parent string, order int) models.Category {
+		ids[name] = primitive.NewObjectID()
+		c := models.Category{ID: ids[name], Name: name, SortOrder: order}
+		if parent != "" {
+			parentID := ids[parent]
+			c.ParentID = &parentID
+		}
+		return c
+	}
+	categories := []models.Category{
+		category("Ropa", "", 1),
+		category("Pantalones", "Ropa", 2),
+		category("Camisetas", "Ropa", 1),
+		category("Manga corta", "Camisetas", 0),
+		category("Hogar", "", 2),
+		category("Cocina", "Hogar", 0),
+		category("Libros", "", 0),
+	}
+	// Los hijos pueden llegar antes que el padre
+	categories = append([]models.Category{categories[len(categories)-1]}, categories[:len(categories)-1]...)
+	return categories, ids
+}
+
+// renderTree escribe el árbol como "nombre:propios/total(hijos)"
+func renderTree(nodes []*models.CategoryNode) string {
+	parts := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		part := fmt.Sprintf("%s:%d/%d", node.Name, node.ProductCount, node.TotalCount)
+		if len(node.Children) > 0 {
+			part += "(" + renderTree(node.Children) + ")"
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestCategoryWithDescendants(t *testing.T) {
+	categories, ids := testCategories()
+
+	tests := []struct {
+		category string
+		want     []string
+	}{
+		{"Ropa", []string{"Ropa", "Camisetas", "Manga corta", "Pantalones"}},
+		{"Camisetas", []string{"Camisetas", "Manga corta"}},
+		{"Manga corta", []string{"Manga corta"}},
+		{"Libros", []string{"Libros"}},
+	}
+	for _, tt := range tests {
+		got := categoryWithDescendants(categories, ids[tt.category])
+		if got[0] != ids[tt.category] {
+			t.Errorf("%s: first ID is not the category itself", tt.category)
+		}
+		want := make([]string, 0, len(tt.want))
+		for _, name := range tt.want {
+			want = append(want, ids[name].Hex())
+		}
+		have := make([]string, 0, len(got))
+		for _, id := range got {
+			have = append(have, id.Hex())
+		}
+		sort.Strings(want)
+		sort.Strings(have)
+		if strings.Join(have, ",") != strings.Join(want, ",") {
+			t.Errorf("%s: got %d IDs, want %v", tt.category, len(got), tt.want)
+		}
+	}
+
+	// Una categoría que no está en la lista solo se devuelve a sí misma
+	unknown := primitive.NewObjectID()
+	if got := categoryWithDescendants(categories, unknown); len(got) != 1 || got[0] != unknown {
+		t.Errorf("unknown category: got %v", got)
+	}
+}
+
+func TestBuildCategoryTree(t *testing.T) {
+	categories, ids := testCategories()
+	orphanParent := primitive.NewObjectID()
+	categories = append(categories, models.Category{ID: primitive.NewObjectID(), Name: "Huérfana", ParentID: &orphanParent, SortOrder: 3})
+
+	tests := []struct {
+		name   string
+		counts map[string]int
+		want   string
+		pruned string
+	}{
+		{
+			"no products",
+			nil,
+			"Libros:0/0 Ropa:0/0(Camisetas:0/0(Manga corta:0/0) Pantalones:0/0) Hogar:0/0(Cocina:0/0) Huérfana:0/0",
+			"",
+		},
+		{
+			"counts add up to the root",
+			map[string]int{"Ropa": 1, "Camisetas": 2, "Manga corta": 3, "Pantalones": 4, "Cocina": 5},
+			"Libros:0/0 Ropa:1/10(Camisetas:2/5(Manga corta:3/3) Pantalones:4/4) Hogar:0/5(Cocina:5/5) Huérfana:0/0",
+			"Ropa:1/10(Camisetas:2/5(Manga corta:3/3) Pantalones:4/4) Hogar:0/5(Cocina:5/5)",
+		},
+		{
+			"only a leaf",
+			map[string]int{"Manga corta": 7},
+			"Libros:0/0 Ropa:0/7(Camisetas:0/7(Manga corta:7/7) Pantalones:0/0) Hogar:0/0(Cocina:0/0) Huérfana:0/0",
+			"Ropa:0/7(Camisetas:0/7(Manga corta:7/7))",
+		},
+		{
+			"only a root",
+			map[string]int{"Libros": 2},
+			"Libros:2/2 Ropa:0/0(Camisetas:0/0(Manga corta:0/0) Pantalones:0/0) Hogar:0/0(Cocina:0/0) Huérfana:0/0",
+			"Libros:2/2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counts := map[primitive.ObjectID]int{}
+			for name, count := range tt.counts {
+				counts[ids[name]] = count
+			}
+			// Productos de una categoría que ya no existe no cuentan
+			counts[primitive.NewObjectID()] = 9
+
+			tree := buildCategoryTree(categories, counts)
+			if got := renderTree(tree); got != tt.want {
+				t.Errorf("tree = %s\nwant   %s", got, tt.want)
+			}
+			if got := renderTree(pruneEmptyCategories(tree)); got != tt.pruned {
+				t.Errorf("pruned = %s\nwant     %s", got, tt.pruned)
+			}
+		})
+	}
+}
+
+func TestSumCategoryCounts(t *testing.T) {
+	leaf := &models.CategoryNode{ProductCount: 3}
+	node := &models.CategoryNode{ProductCount: 1, TotalCount: 99, Children: []*models.CategoryNode{
+		leaf,
+		{ProductCount: 0, Children: []*models.CategoryNode{{ProductCount: 2}}},
+	}}
+	if got := sumCategoryCounts(node); got != 6 || node.TotalCount != 6 {
+		t.Errorf("sumCategoryCounts = %d, TotalCount = %d, want 6", got, node.TotalCount)
+	}
+	if leaf.TotalCount != 3 {
+		t.Errorf("leaf TotalCount = %d, want 3", leaf.TotalCount)
+	}
+}
@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"main/database"
+	"main/models"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// checkBasePrice comprueba un precio que tiene que estar en la moneda base. Sin
+// moneda se entiende que es la base.
+func checkBasePrice(price *models.Money, field string) []models.FieldError {
+	errs := make([]models.FieldError, 0)
+	price.Currency = strings.ToUpper(price.Currency)
+	if price.Currency == "" {
+		price.Currency = models.BaseCurrency
+	}
+	if price.Currency != models.BaseCurrency {
+		errs = append(errs, models.FieldError{Field: field + ".currency", Code: "invalid", Message: fmt.Sprintf("Price must be in %s, use prices for other currencies", models.BaseCurrency)})
+	}
+	if price.Amount < 0 {
+		errs = append(errs, models.FieldError{Field: field + ".amount", Code: "invalid", Message: "Price cannot be negative"})
+	}
+	return errs
+}
+
+// checkProductPrices comprueba el precio base del producto y su lista de precios en
+// otras monedas, con una moneda admitida y distinta en cada uno
+func checkProductPrices(product *models.Product) []models.FieldError {
+	errs := checkBasePrice(&product.Price, "price")
+
+	seen := map[string]bool{models.BaseCurrency: true}
+	for i := range product.Prices {
+		price := &product.Prices[i]
+		field := fmt.Sprintf("prices[%d]", i)
+		price.Currency = strings.ToUpper(price.Currency)
+		switch {
+		case !models.IsValidCurrency(price.Currency):
+			errs = append(errs, models.FieldError{Field: field + ".currency", Code: "invalid", Message: "Unsupported currency " + price.Currency})
+		case seen[price.Currency]:
+			errs = append(errs, models.FieldError{Field: field + ".currency", Code: "duplicate", Message: "There is already a price in " + price.Currency})
+		}
+		seen[price.Currency] = true
+		if price.Amount < 0 {
+			errs = append(errs, models.FieldError{Field: field + ".amount", Code: "invalid", Message: "Price cannot be negative"})
+		}
+	}
+	if product.Prices == nil {
+		product.Prices = []models.Money{}
+	}
+	return errs
+}
+
+// errNoExchangeRate es el error de pricing cuando un precio se tiene que convertir y
+// la moneda pedida no tiene cambio
+var errNoExchangeRate = errors.New("no exchange rate")
+
+// loadExchangeRate devuelve el cambio desde la moneda base a currency
+func loadExchangeRate(ctx context.Context, currency string) (string, error) {
+	var rate models.ExchangeRate
+	err := database.Mg.Db.Collection("exchange_rates").FindOne(ctx, bson.M{"_id": currency}).Decode(&rate)
+	if err == mongo.ErrNoDocuments {
+		return "", errNoExchangeRate
+	}
+	if err != nil {
+		return "", err
+	}
+	return rate.Rate, nil
+}
+
+// pricing pone los precios del catálogo en la moneda pedida: el precio fijado en esa
+// moneda si el producto lo tiene y si no el precio base con el cambio de la tabla. El
+// cambio solo se lee la primera vez que hace falta.
+type pricing struct {
+	currency string
+	rate     string
+}
+
+// newPricing prepara los precios en currency. Devuelve el motivo si la moneda no está
+// admitida.
+func newPricing(currency string) (*pricing, string) {
+	currency = strings.ToUpper(currency)
+	if currency == "" || currency == models.BaseCurrency {
+		return &pricing{currency: models.BaseCurrency}, ""
+	}
+	if !models.IsValidCurrency(currency) {
+		return nil, "Unsupported currency " + currency
+	}
+	return &pricing{currency: currency}, ""
+}
+
+// convert pasa un precio en la moneda base a la moneda pedida. Devuelve
+// errNoExchangeRate si hace falta el cambio y no lo hay.
+func (p *pricing) convert(ctx context.Context, price models.Money) (models.Money, error) {
+	if price.Currency == p.currency {
+		return price, nil
+	}
+	if p.rate == "" {
+		rate, err := loadExchangeRate(ctx, p.currency)
+		if err != nil {
+			return models.Money{}, err
+		}
+		p.rate = rate
+	}
+	return price.Convert(p.currency, p.rate)
+}
+
+// apply cambia el precio del producto al de la moneda pedida y quita la lista de precios
+func (p *pricing) apply(ctx context.Context, product *models.Product) error {
+	price, ok := product.PriceIn(p.currency)
+	if !ok {
+		var err error
+		if price, err = p.convert(ctx, product.Price); err != nil {
+			return err
+		}
+	}
+	product.Price = price
+	product.Prices = nil
+	return nil
+}
+
+// applyVariant cambia el precio propio de la variante a la moneda pedida
+func (p *pricing) applyVariant(ctx context.Context, variant *models.Variant) error {
+	if variant.Price == nil {
+		return nil
+	}
+	price, err := p.convert(ctx, *variant.Price)
+	if err != nil {
+		return err
+	}
+	variant.Price = &price
+	return nil
+}
+
+// pricingError responde al error de apply: 400 si la moneda no tiene el cambio que hace falta
+func pricingError(c *fiber.Ctx, p *pricing, err error) error {
+	if err == errNoExchangeRate {
+		return invalidQueryError(c, []models.FieldError{{Field: "currency", Code: "invalid", Message: "There is no exchange rate for " + p.currency}})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(models.Error{Message: "Internal Server Error", StatusCode: 500})
+}
+
+// GetExchangeRates devuelve la tabla de cambios desde la moneda base
+func GetExchangeRates(c *fiber.Ctx) error {
+	cursor, err := database.Mg.Db.Collection("exchange_rates").Find(c.Context(), bson.M{},
+		options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}),
+	)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.Error{Message: "Internal Server Error", StatusCode: 500})
+	}
+	rates := make([]models.ExchangeRate, 0)
+	if err := cursor.All(c.Context(), &rates); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.Error{Message: "Internal Server Error", StatusCode: 500})
+	}
+
+	return c.JSON(fiber.Map{"base": models.BaseCurrency, "items": rates})
+}
+
+// SetExchangeRate crea o cambia el cambio de una moneda. rate es cuántas unidades de
+// la moneda vale una de la moneda base, como número o como texto ("1.0842").
+func SetExchangeRate(c *fiber.Ctx) error {
+	currency := strings.ToUpper(c.Params("currency"))
+	if !models.IsValidCurrency(currency) || currency == models.BaseCurrency {
+		return c.Status(fiber.StatusBadRequest).JSON(models.Error{Message: "Unsupported currency " + currency, StatusCode: 400})
+	}
+
+	var body struct {
+		Rate json.Number `json:"rate"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.Error{Message: "Invalid request body", StatusCode: 400})
+	}
+	rate, ok := new(big.Rat).SetString(body.Rate.String())
+	if !ok || rate.Sign() <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ValidationError{
+			Message:    "Invalid exchange rate",
+			StatusCode: 400,
+			Errors:     []models.FieldError{{Field: "rate", Code: "invalid", Message: "Rate must be a positive decimal number"}},
+		})
+	}
+
+	exchangeRate := models.ExchangeRate{Currency: currency, Rate: body.Rate.String(), UpdatedAt: time.Now()}
+	_, err := database.Mg.Db.Collection("exchange_rates").ReplaceOne(c.Context(), bson.M{"_id": currency}, exchangeRate,
+		options.Replace().SetUpsert(true),
+	)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.Error{Message: "Internal Server Error", StatusCode: 500})
+	}
+
+	return c.JSON(exchangeRate)
+}
+
+// DeleteExchangeRate borra el cambio de una moneda, que deja de poderse pedir en el catálogo
+func DeleteExchangeRate(c *fiber.Ctx) error {
+	currency := strings.ToUpper(c.Params("currency"))
+	result, err := database.Mg.Db.Collection("exchange_rates").DeleteOne(c.Context(), bson.M{"_id": currency})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.Error{Message: "Internal Server Error", StatusCode: 500})
+	}
+	if result.DeletedCount == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(models.Error{Message: "Not Found", StatusCode: 404})
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"log"
+	"main/config"
+	"main/database"
+	"main/middleware"
+	"main/models"
+	"main/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CustomerLogin autentica a un cliente y devuelve tokens del realm de clientes
+func CustomerLogin(c *fiber.Ctx) error {
+	var body struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"statusCode": 400,
+			"message":    "Invalid request body",
+		})
+	}
+
+	// Rechazar si la cuenta o la IP tienen demasiados intentos fallidos
+	wait, err := checkLoginAllowed(c.Context(), models.RealmCustomers, body.Email, c.IP())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"statusCode": 500,
+			"message":    "Internal Server Error",
+		})
+	}
+	if wait > 0 {
+		return loginLockedResponse(c, wait)
+	}
+
+	var customer models.Customer
+	err = database.Mg.Db.Collection("customers").FindOne(c.Context(), bson.M{"email": body.Email}).Decode(&customer)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"statusCode": 500,
+			"message":    "Internal Server Error",
+		})
+	}
+
+	// Igual que en Login, mismo error y mismo tiempo de respuesta exista o no el email
+	customerID, _ := primitive.ObjectIDFromHex(customer.ID)
+	if !checkPassword(c.Context(), "customers", customerID, customer.Password, body.Password) {
+		if err := recordLoginFailure(c.Context(), models.RealmCustomers, body.Email, c.IP()); err != nil {
+			log.Println("login attempts:", err)
+		}
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"statusCode": 401,
+			"message":    "Invalid email or password",
+		})
+	}
+
+	if !customer.IsEmailVerified() && config.Config("UNVERIFIED_LOGIN") != "limited" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"statusCode": 403,
+			"message":    "Email not verified",
+		})
+	}
+
+	if err := resetLoginFailures(c.Context(), models.RealmCustomers, body.Email); err != nil {
+		log.Println("login attempts:", err)
+	}
+
+	// Crear la sesión, el token JWT y el refresh token
+	familyID, err := createSession(c, customerID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"statusCode": 500,
+			"message":    "Error generating token",
+		})
+	}
+	signedToken, refreshToken, err := issueCustomerTokens(c.Context(), customer, familyID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"statusCode": 500,
+			"message":    "Error generating token",
+		})
+	}
+
+	customer.Password = ""
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"statusCode":    200,
+		"message":       "Login successfull",
+		"token":         signedToken,
+		"refresh_token": refreshToken,
+		"expires_in":    int(utils.AccessTokenDuration.Seconds()),
+		"customer":      customer,
+	})
+}
+
+// CustomerRefresh rota los tokens de un cliente
+func CustomerRefresh(c *fiber.Ctx) error {
+	return refreshTokens(c, models.RealmCustomers)
+}
+
+// GetMe devuelve el registro del cliente autenticado
+func GetMe(c *fiber.Ctx) error {
+	customerID, err := primitive.ObjectIDFromHex(middleware.CurrentUser(c).Subject)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"statusCode": 401,
+			"message":    "Invalid token",
+		})
+	}
+
+	// Get all fields except password
+	projection := bson.M{"password": 0}
+
+	var customer models.Customer
+	err = database.Mg.Db.Collection("customers").FindOne(c.Context(), bson.M{"_id": customerID}, options.FindOne().SetProjection(projection)).Decode(&customer)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"statusCode": 404,
+				"message":    "Customer not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"statusCode": 500,
+			"message":    "Internal Server Error",
+		})
+	}
+	return c.Status(fiber.StatusOK).JSON(&customer)
+}
+
+// UpdateMe actualiza el nombre y el teléfono del cliente autenticado
+func UpdateMe(c *fiber.Ctx) error {
+	customerID, err := primitive.ObjectIDFromHex(middleware.CurrentUser(c).Subject)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"statusCode": 401,
+			"message":    "Invalid token",
+		})
+	}
+
+	customer := new(models.Customer)
+	if err := c.BodyParser(customer); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"statusCode": 400,
+			"message":    "Invalid request body",
+		})
+	}
+
+	// Crear un mapa con los campos que el cliente puede cambiar
+	update := bson.M{
+		"name":  customer.Name,
+		"phone": customer.Phone,
+	}
+
+	// Crear un bson.M con los datos no nulos
+	updateNotNull := bson.M{}
+	for key, value := range update {
+		if value != "" {
+			updateNotNull[key] = value
+		}
+	}
+	if len(updateNotNull) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"statusCode": 400,
+			"message":    "Nothing to update",
+		})
+	}
+
+	var updated models.Customer
+	err = database.Mg.Db.Collection("customers").FindOneAndUpdate(c.Context(),
+		bson.M{"_id": customerID},
+		bson.M{"$set": updateNotNull},
+		options.FindOneAndUpdate().SetReturnDocument(options.After).SetProjection(bson.M{"password": 0}),
+	).Decode(&updated)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"statusCode": 404,
+				"message":    "Customer not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"statusCode": 500,
+			"message":    "Internal Server Error",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(&updated)
+}
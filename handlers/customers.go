@@ -4,6 +4,7 @@ import (
 	"context"
 	"main/database"
 	"main/models"
+	"main/utils"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -11,7 +12,6 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
-	"golang.org/x/crypto/bcrypt"
 )
 
 /*
@@ -46,16 +46,36 @@ func CreateCustomer(c *fiber.Ctx) error {
 		})
 	}
 
+	// Check email format
+	if !isValidEmail(customer.Email) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"statusCode": 400,
+			"message":    "Invalid email",
+		})
+	}
+
 	// Check if email exists
-	if checkEmailExists(customer.Email) {
+	taken, err := emailTaken(c.Context(), models.RealmCustomers, customer.Email, primitive.NilObjectID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"statusCode": 500,
+			"message":    "Internal server error",
+		})
+	}
+	if taken {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"statusCode": 400,
 			"message":    "Email already exists",
 		})
 	}
 
+	// Check password policy
+	if errs := utils.Policy.Validate(customer.Password, customer.Email, customer.Name); len(errs) > 0 {
+		return passwordPolicyError(c, errs)
+	}
+
 	// Hash the password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(customer.Password), 8)
+	hashedPassword, err := utils.Passwords.Hash(customer.Password)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"statusCode": 500,
@@ -65,75 +85,87 @@ func CreateCustomer(c *fiber.Ctx) error {
 
 	// Insert customer
 	res, err := collection.InsertOne(c.Context(), bson.M{
-		"name":         customer.Name,
-		"email":        customer.Email,
-		"password":     hashedPassword,
-		"phone":        customer.Phone,
-		"affiliate_id": customer.AffiliateID,
+		"name":           customer.Name,
+		"email":          customer.Email,
+		"password":       hashedPassword,
+		"phone":          customer.Phone,
+		"affiliate_id":   customer.AffiliateID,
+		"email_verified": false,
 	})
 	if err != nil {
+		// Otro alta con el mismo email pudo entrar entre la comprobación y el insert
+		if mongo.IsDuplicateKeyError(err) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"statusCode": 400,
+				"message":    "Email already exists",
+			})
+		}
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"statusCode": 500,
 			"message":    "Internal server error",
 		})
 	}
 
+	// Send verification link
+	if insertedID, ok := res.InsertedID.(primitive.ObjectID); ok {
+		sendVerificationEmailAsync(models.RealmCustomers, insertedID, customer.Email)
+	}
+
 	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
-		"id":           res.InsertedID,
-		"name":         customer.Name,
-		"email":        customer.Email,
-		"phone":        customer.Phone,
-		"affiliate_id": customer.AffiliateID,
+		"id":             res.InsertedID,
+		"name":           customer.Name,
+		"email":          customer.Email,
+		"phone":          customer.Phone,
+		"affiliate_id":   customer.AffiliateID,
+		"email_verified": false,
 	})
 
 }
 
+// Campos por los que se pueden ordenar los clientes
+var customerListOptions = listOptions{
+	sortable: map[string]string{
+		"name":  "name",
+		"email": "email",
+	},
+}
+
 func GetCustomers(c *fiber.Ctx) error {
 
 	//Creating a new context with a timout of 10 seconds
 	ctx, cancel := context.WithTimeout(c.Context(), 10*time.Second)
 	defer cancel()
 
-	//Extracting search query parameter, and initializing the query
-	search := c.Query("search")
-	query := bson.D{{}}
+	//Parsing pagination and sort parameters
+	query, errs := parseListQuery(c, customerListOptions)
+	if len(errs) > 0 {
+		return invalidQueryError(c, errs)
+	}
 
-	if len(c.Query("search")) > 0 {
-		//Setting query field to name and value to a regex object that matches the search query with options to ignore case sensitivity
-		query = bson.D{{Key: "name", Value: primitive.Regex{Pattern: search, Options: "i"}}}
+	//Extracting search query parameter
+	search := c.Query("search")
+	if len(search) > 0 {
+		//Setting query field to name and value to a regex object that matches the escaped search query with options to ignore case sensitivity
+		query.Filter["name"] = primitive.Regex{Pattern: utils.ContainsPattern(search), Options: "i"}
 	}
 
 	//Setting projection to exclude password field
 	projection := bson.M{"password": 0}
 
-	//Getting all customers that match the search query and projecting all fields except password
-	cursor, err := database.Mg.Db.Collection("customers").Find(ctx, query, options.Find().SetProjection(projection))
-
+	//Getting the page of customers that match the search query
+	customers := make([]bson.M, 0)
+	page, err := query.Find(ctx, database.Mg.Db.Collection("customers"), projection, &customers)
 	if err != nil {
-		//Returning an error response if an error occurs
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"statusCode": 500,
-			"message":    "Unable to retrieve customers",
-		})
-	}
-
-	//Closing cursor once all customers have been returned
-	defer cursor.Close(ctx)
-
-	var customers []bson.M
-
-	if err := cursor.All(ctx, &customers); err != nil {
-		//Returning an error response if an error occurs
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"statusCode": 500,
-			"message":    "Unable to retrieve customers",
-		})
+		return findErrorResponse(c, err)
 	}
 
 	//Returning a success response with customers and total number of customers found
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"items": customers,
-		"total": len(customers),
+		"items":       customers,
+		"total":       page.Total,
+		"page":        page.Page,
+		"limit":       page.Limit,
+		"next_cursor": page.NextCursor,
 	})
 }
 
@@ -190,26 +222,56 @@ func UpdateCustomer(c *fiber.Ctx) error {
 	}
 	filter := bson.M{"_id": objectId}
 
-	count, err := database.Mg.Db.Collection("customers").CountDocuments(c.Context(), filter)
+	// La contraseña solo se cambia en /api/customers/me/password, que pide la actual
+	if customer.Password != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"statusCode": 400,
+			"message":    "Password cannot be changed here, use /api/customers/me/password",
+		})
+	}
+
+	var current struct {
+		Email string `bson:"email"`
+	}
+	err = database.Mg.Db.Collection("customers").FindOne(c.Context(), filter,
+		options.FindOne().SetProjection(bson.M{"email": 1}),
+	).Decode(&current)
 	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"statusCode": 404,
+				"message":    "User not found",
+			})
+		}
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"statusCode": 500,
 			"message":    "Internal Server Error",
 		})
 	}
-	if count == 0 {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"statusCode": 404,
-			"message":    "User not found",
-		})
+
+	// Un email nuevo tiene que ser válido y no usarlo otra cuenta, y hay que volver a verificarlo
+	emailChanged := customer.Email != "" && customer.Email != current.Email
+	if emailChanged {
+		problem, err := checkNewEmail(c.Context(), models.RealmCustomers, objectId, customer.Email)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"statusCode": 500,
+				"message":    "Internal Server Error",
+			})
+		}
+		if problem != "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"statusCode": 400,
+				"message":    problem,
+			})
+		}
 	}
 
 	// Crear un mapa con los campos actualizables
 	update := bson.M{
-		"name":     customer.Name,
-		"email":    customer.Email,
-		"password": customer.Password,
-		"phone":    customer.Phone,
+		"name":  customer.Name,
+		"email": customer.Email,
+		"phone": customer.Phone,
 	}
 
 	// Crear un bson.M con los datos no nulos
@@ -220,15 +282,32 @@ func UpdateCustomer(c *fiber.Ctx) error {
 		}
 	}
 
+	if emailChanged {
+		verified := false
+		updateNotNull["email_verified"] = verified
+		customer.EmailVerified = &verified
+	}
+
 	_, err = database.Mg.Db.Collection("customers").UpdateOne(c.Context(), filter, bson.M{"$set": updateNotNull})
 
 	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"statusCode": 400,
+				"message":    "Email already exists",
+			})
+		}
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"statusCode": 500,
 			"message":    "Internal Server Error",
 		})
 	}
 
+	// Send verification link to the new email
+	if emailChanged {
+		sendVerificationEmailAsync(models.RealmCustomers, objectId, customer.Email)
+	}
+
 	return c.Status(fiber.StatusOK).JSON(&customer)
 }
 
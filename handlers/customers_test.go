@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"main/database"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestCreateCustomerEmailExists(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	app := fiber.New()
+	app.Post("/api/customers", CreateCustomer)
+	post := func(mt *mtest.T) int {
+		req := httptest.NewRequest("POST", "/api/customers", strings.NewReader(`{"name":"Ana","email":"ana@example.com","password":"Correcto-caballo-9"}`))
+		req.Header.Set("Content-Type", "application/json")
+		res, err := app.Test(req)
+		if err != nil {
+			mt.Fatal(err)
+		}
+		return res.StatusCode
+	}
+
+	mt.Run("in customers", func(mt *mtest.T) {
+		database.Mg.Db = mt.DB
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, mt.DB.Name()+".customers", mtest.FirstBatch, bson.D{{Key: "n", Value: 1}}))
+
+		if status := post(mt); status != fiber.StatusBadRequest {
+			mt.Errorf("status = %d, want 400", status)
+		}
+		// El email se busca entre los clientes, no entre los usuarios del staff
+		started := mt.GetStartedEvent()
+		if started == nil || started.Command.Lookup("aggregate").StringValue() != "customers" {
+			mt.Errorf("email checked with %v", started)
+		}
+	})
+
+	mt.Run("duplicate on insert", func(mt *mtest.T) {
+		database.Mg.Db = mt.DB
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, mt.DB.Name()+".customers", mtest.FirstBatch),
+			mtest.CreateWriteErrorsResponse(mtest.WriteError{Index: 0, Code: 11000, Message: "E11000 duplicate key error"}),
+		)
+
+		if status := post(mt); status != fiber.StatusBadRequest {
+			mt.Errorf("status = %d, want 400", status)
+		}
+	})
+}
@@ -0,0 +1,365 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"main/config"
+	"main/database"
+	"main/events"
+	"main/mailer"
+	"main/middleware"
+	"main/models"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Campos por los que se pueden ordenar los movimientos de stock
+var stockMovementListOptions = listOptions{
+	sortable: map[string]string{
+		"created_at": "created_at",
+	},
+	defaultSort: "-created_at",
+}
+
+// LowStockEvent son los datos del evento de stock bajo
+type LowStockEvent struct {
+	ProductID string `json:"product_id" bson:"product_id"`
+	Name      string `json:"name" bson:"name"`
+	Warehouse string `json:"warehouse" bson:"warehouse"`
+	Stock     int    `json:"stock" bson:"stock"`
+	Threshold int    `json:"threshold" bson:"threshold"`
+}
+
+// currentActor devuelve quién hace la petición para los registros: una API key o un usuario
+func currentActor(c *fiber.Ctx) (string, string) {
+	claims := middleware.CurrentUser(c)
+	if claims == nil {
+		return "user", ""
+	}
+	if claims.APIKeyID != "" {
+		return "api_key", claims.APIKeyID
+	}
+	return "user", claims.Subject
+}
+
+// findProduct busca un producto por su ID
+func findProduct(ctx context.Context, id primitive.ObjectID) (*models.Product, error) {
+	var product models.Product
+	if err := database.Mg.Db.Collection("Products").FindOne(ctx, bson.M{"_id": id}).Decode(&product); err != nil {
+		return nil, err
+	}
+	return &product, nil
+}
+
+// productNotFound responde al error de findProduct
+func productNotFound(c *fiber.Ctx, err error) error {
+	if err == mongo.ErrNoDocuments {
+		return c.Status(fiber.StatusNotFound).JSON(models.Error{Message: "Not Found", StatusCode: 404})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(models.Error{Message: "Internal Server Error", StatusCode: 500})
+}
+
+// stockVariant devuelve la variante del movimiento de stock. Es obligatoria si el
+// producto tiene variantes y no se puede indicar si no las tiene. Si la variante no es
+// válida devuelve el motivo.
+func stockVariant(ctx context.Context, productID primitive.ObjectID, ref string) (*primitive.ObjectID, string, error) {
+	collection := database.Mg.Db.Collection("variants")
+	if ref == "" {
+		count, err := collection.CountDocuments(ctx, bson.M{"product_id": productID})
+		if err != nil || count > 0 {
+			return nil, "variant_id is required for products with variants", err
+		}
+		return nil, "", nil
+	}
+
+	variantID, err := primitive.ObjectIDFromHex(ref)
+	if err != nil {
+		return nil, "Invalid variant ID", nil
+	}
+	count, err := collection.CountDocuments(ctx, bson.M{"_id": variantID, "product_id": productID})
+	if err != nil || count == 0 {
+		return nil, "Variant not found for this product", err
+	}
+	return &variantID, "", nil
+}
+
+// hasProductStock indica si el producto tiene stock sin variante. Ese stock no se podría
+// mover ni ajustar una vez que el producto tiene variantes.
+func hasProductStock(ctx context.Context, productID primitive.ObjectID) (bool, error) {
+	count, err := database.Mg.Db.Collection("stock_levels").CountDocuments(ctx, bson.M{
+		"product_id": productID,
+		"variant_id": nil,
+		"quantity":   bson.M{"$ne": 0},
+	})
+	return count > 0, err
+}
+
+// GetProductStock devuelve el stock total del producto y el de cada almacén
+func GetProductStock(c *fiber.Ctx) error {
+	productID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.Error{Message: "Invalid ID", StatusCode: 400})
+	}
+	product, err := findProduct(c.Context(), productID)
+	if err != nil {
+		return productNotFound(c, err)
+	}
+
+	cursor, err := database.Mg.Db.Collection("stock_levels").Find(c.Context(), bson.M{"product_id": productID},
+		options.Find().SetSort(bson.D{{Key: "warehouse", Value: 1}}),
+	)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.Error{Message: "Internal Server Error", StatusCode: 500})
+	}
+	levels := make([]models.StockLevel, 0)
+	if err := cursor.All(c.Context(), &levels); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.Error{Message: "Internal Server Error", StatusCode: 500})
+	}
+
+	return c.JSON(fiber.Map{
+		"product_id":          product.ID,
+		"stock":               product.Stock,
+		"low_stock_threshold": product.LowStockThreshold,
+		"low_stock":           product.LowStockThreshold != nil && product.Stock <= *product.LowStockThreshold,
+		"warehouses":          levels,
+	})
+}
+
+// AdjustStock registra un movimiento de stock de un producto en un almacén. quantity es
+// positiva en entradas, salidas y devoluciones; en los ajustes lleva signo. En los
+// productos con variantes el movimiento es de una variante y hay que indicar variant_id.
+func AdjustStock(c *fiber.Ctx) error {
+	productID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.Error{Message: "Invalid ID", StatusCode: 400})
+	}
+
+	var body struct {
+		VariantID string `json:"variant_id"`
+		Warehouse string `json:"warehouse"`
+		Type      string `json:"type"`
+		Quantity  int    `json:"quantity"`
+		Reason    string `json:"reason"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.Error{Message: "Invalid request body", StatusCode: 400})
+	}
+	if body.Warehouse == "" {
+		body.Warehouse = models.DefaultWarehouse
+	}
+
+	errs := make([]models.FieldError, 0)
+	if !models.IsValidMovementType(body.Type) {
+		errs = append(errs, models.FieldError{Field: "type", Code: "invalid", Message: "Type must be receipt, sale, adjustment or return"})
+	}
+	if body.Quantity == 0 || (body.Type != models.MovementAdjustment && body.Quantity < 0) {
+		errs = append(errs, models.FieldError{Field: "quantity", Code: "invalid", Message: "Quantity must be positive, or non-zero for adjustments"})
+	}
+	if body.Reason == "" {
+		errs = append(errs, models.FieldError{Field: "reason", Code: "required", Message: "Reason is required"})
+	}
+	if len(errs) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ValidationError{Message: "Invalid stock movement", StatusCode: 400, Errors: errs})
+	}
+
+	delta := body.Quantity
+	if body.Type == models.MovementSale {
+		delta = -body.Quantity
+	}
+
+	if _, err := findProduct(c.Context(), productID); err != nil {
+		return productNotFound(c, err)
+	}
+	variantID, problem, err := stockVariant(c.Context(), productID, body.VariantID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.Error{Message: "Internal Server Error", StatusCode: 500})
+	}
+	if problem != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ValidationError{
+			Message:    "Invalid stock movement",
+			StatusCode: 400,
+			Errors:     []models.FieldError{{Field: "variant_id", Code: "invalid", Message: problem}},
+		})
+	}
+
+	// Cambiar el stock del almacén sin dejarlo en negativo
+	now := time.Now()
+	levels := database.Mg.Db.Collection("stock_levels")
+	filter := bson.M{"product_id": productID, "variant_id": variantID, "warehouse": body.Warehouse}
+	if delta < 0 {
+		filter["quantity"] = bson.M{"$gte": -delta}
+	}
+	var level models.StockLevel
+	err = levels.FindOneAndUpdate(c.Context(), filter,
+		bson.M{"$inc": bson.M{"quantity": delta}, "$set": bson.M{"updated_at": now}},
+		options.FindOneAndUpdate().SetUpsert(delta > 0).SetReturnDocument(options.After),
+	).Decode(&level)
+	if err == mongo.ErrNoDocuments {
+		return c.Status(fiber.StatusConflict).JSON(models.Error{Message: "Insufficient stock", StatusCode: 409})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.Error{Message: "Internal Server Error", StatusCode: 500})
+	}
+
+	actorType, actorID := currentActor(c)
+	movement := models.StockMovement{
+		ProductID: productID,
+		VariantID: variantID,
+		Warehouse: body.Warehouse,
+		Type:      body.Type,
+		Quantity:  delta,
+		Balance:   level.Quantity,
+		Reason:    body.Reason,
+		ActorID:   actorID,
+		ActorType: actorType,
+		CreatedAt: now,
+	}
+	res, err := database.Mg.Db.Collection("stock_movements").InsertOne(c.Context(), movement)
+	if err != nil {
+		// Sin movimiento en el registro se deshace el cambio de stock
+		if _, undoErr := levels.UpdateOne(c.Context(), bson.M{"_id": level.ID}, bson.M{"$inc": bson.M{"quantity": -delta}}); undoErr != nil {
+			log.Println("stock undo:", undoErr)
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(models.Error{Message: "Internal Server Error", StatusCode: 500})
+	}
+	movement.ID = res.InsertedID.(primitive.ObjectID)
+
+	if variantID != nil {
+		_, err = database.Mg.Db.Collection("variants").UpdateOne(c.Context(), bson.M{"_id": *variantID}, bson.M{"$inc": bson.M{"stock": delta}})
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(models.Error{Message: "Internal Server Error", StatusCode: 500})
+		}
+	}
+
+	var product models.Product
+	err = database.Mg.Db.Collection("Products").FindOneAndUpdate(c.Context(), bson.M{"_id": productID},
+		bson.M{"$inc": bson.M{"stock": delta}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&product)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.Error{Message: "Internal Server Error", StatusCode: 500})
+	}
+
+	// Mantener el índice de búsqueda al día
+	reindexProduct(c.Context(), productID.Hex())
+
+	// Avisar solo cuando el stock baja del umbral, no en cada movimiento por debajo
+	if t := product.LowStockThreshold; t != nil && product.Stock <= *t && product.Stock-delta > *t {
+		err := events.Publish(c.Context(), events.LowStock, LowStockEvent{
+			ProductID: product.ID,
+			Name:      product.Name,
+			Warehouse: body.Warehouse,
+			Stock:     product.Stock,
+			Threshold: *t,
+		})
+		if err != nil {
+			log.Println("low stock event:", err)
+		}
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"movement": movement,
+		"stock":    product.Stock,
+	})
+}
+
+// GetStockMovements lista los movimientos de stock del producto, los últimos primero.
+// Se pueden filtrar por variant_id, warehouse y type.
+func GetStockMovements(c *fiber.Ctx) error {
+	productID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.Error{Message: "Invalid ID", StatusCode: 400})
+	}
+
+	query, errs := parseListQuery(c, stockMovementListOptions)
+	if len(errs) > 0 {
+		return invalidQueryError(c, errs)
+	}
+	query.Filter["product_id"] = productID
+	if variantID := c.Query("variant_id"); variantID != "" {
+		id, err := primitive.ObjectIDFromHex(variantID)
+		if err != nil {
+			return invalidQueryError(c, []models.FieldError{{Field: "variant_id", Code: "invalid", Message: "Invalid variant ID"}})
+		}
+		query.Filter["variant_id"] = id
+	}
+	if warehouse := c.Query("warehouse"); warehouse != "" {
+		query.Filter["warehouse"] = warehouse
+	}
+	if movementType := c.Query("type"); movementType != "" {
+		query.Filter["type"] = movementType
+	}
+
+	movements := make([]models.StockMovement, 0)
+	page, err := query.Find(c.Context(), database.Mg.Db.Collection("stock_movements"), nil, &movements)
+	if err != nil {
+		return findErrorResponse(c, err)
+	}
+
+	return c.JSON(fiber.Map{
+		"items":       movements,
+		"total":       page.Total,
+		"page":        page.Page,
+		"limit":       page.Limit,
+		"next_cursor": page.NextCursor,
+	})
+}
+
+// SetLowStockThreshold cambia el umbral de stock bajo del producto, null lo quita
+func SetLowStockThreshold(c *fiber.Ctx) error {
+	productID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.Error{Message: "Invalid ID", StatusCode: 400})
+	}
+
+	var body struct {
+		Threshold *int `json:"threshold"`
+	}
+	if err := c.BodyParser(&body); err != nil || (body.Threshold != nil && *body.Threshold < 0) {
+		return c.Status(fiber.StatusBadRequest).JSON(models.Error{Message: "Invalid request body", StatusCode: 400})
+	}
+
+	update := bson.M{"$unset": bson.M{"low_stock_threshold": ""}}
+	if body.Threshold != nil {
+		update = bson.M{"$set": bson.M{"low_stock_threshold": *body.Threshold}}
+	}
+	result, err := database.Mg.Db.Collection("Products").UpdateOne(c.Context(), bson.M{"_id": productID}, update)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.Error{Message: "Internal Server Error", StatusCode: 500})
+	}
+	if result.MatchedCount == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(models.Error{Message: "Not Found", StatusCode: 404})
+	}
+
+	// Mantener el índice de búsqueda al día
+	reindexProduct(c.Context(), productID.Hex())
+
+	return c.JSON(fiber.Map{"product_id": productID.Hex(), "low_stock_threshold": body.Threshold})
+}
+
+// NotifyLowStock envía el aviso de stock bajo a INVENTORY_ALERT_EMAIL si está configurado
+func NotifyLowStock(ctx context.Context, event events.Event) {
+	to := config.Config("INVENTORY_ALERT_EMAIL")
+	data, ok := event.Data.(LowStockEvent)
+	if to == "" || !ok {
+		return
+	}
+
+	err := mailer.Default.Send(mailer.Message{
+		To:      to,
+		Subject: fmt.Sprintf("Low stock: %s", data.Name),
+		Body: fmt.Sprintf(
+			"The stock of %s (%s) is %d, at or below the threshold of %d.\nLast movement in warehouse %s.\n",
+			data.Name, data.ProductID, data.Stock, data.Threshold, data.Warehouse,
+		),
+	})
+	if err != nil {
+		log.Println("low stock notification:", err)
+	}
+}
@@ -0,0 +1,15 @@
+package handlers
+
+import (
+	"main/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// JWKS publica las claves públicas con las que se firman los tokens
+func JWKS(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"keys": utils.Keys.JWKS(),
+	})
+}
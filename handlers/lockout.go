@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"context"
+	"main/models"
+	"main/utils"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Límites de intentos fallidos de login
+const (
+	// Ventana durante la que se cuentan los fallos; cada fallo la renueva
+	loginAttemptsWindow = time.Minute * 15
+	// A partir de estos fallos hay que esperar 1s, 2s, 4s... hasta loginMaxDelay desde
+	// el último fallo; antes se responde 429 con Retry-After
+	loginDelayAfter = 3
+	loginMaxDelay   = time.Second * 8
+	// Fallos para bloquear temporalmente la cuenta o la IP
+	loginMaxAccountFailures = 10
+	loginMaxIPFailures      = 50
+)
+
+func accountAttemptsKey(realm string, email string) string {
+	return "login:" + realm + ":" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipAttemptsKey(ip string) string {
+	return "login:ip:" + ip
+}
+
+// checkLoginAllowed devuelve cuánto tiene que esperar el cliente para volver a
+// intentar el login, o 0 si puede intentarlo ya. Con la cuenta o la IP bloqueadas es
+// lo que queda de la ventana; si no, lo que falta del retardo desde el último fallo.
+func checkLoginAllowed(ctx context.Context, realm string, email string, ip string) (time.Duration, error) {
+	accountFailures, accountExpires, err := utils.Attempts(ctx, accountAttemptsKey(realm, email))
+	if err != nil {
+		return 0, err
+	}
+	ipFailures, ipExpires, err := utils.Attempts(ctx, ipAttemptsKey(ip))
+	if err != nil {
+		return 0, err
+	}
+
+	var wait time.Duration
+	if accountFailures >= loginMaxAccountFailures {
+		wait = time.Until(accountExpires)
+	}
+	if ipFailures >= loginMaxIPFailures && time.Until(ipExpires) > wait {
+		wait = time.Until(ipExpires)
+	}
+	if wait == 0 && accountFailures >= loginDelayAfter {
+		delay := time.Second << uint(accountFailures-loginDelayAfter)
+		if delay > loginMaxDelay {
+			delay = loginMaxDelay
+		}
+		lastFailure := accountExpires.Add(-loginAttemptsWindow)
+		wait = time.Until(lastFailure.Add(delay))
+	}
+	if wait < 0 {
+		wait = 0
+	}
+	return wait, nil
+}
+
+// recordLoginFailure suma un fallo a la cuenta y a la IP, y guarda la IP con la cuenta
+// para que UnlockUser pueda limpiarla
+func recordLoginFailure(ctx context.Context, realm string, email string, ip string) error {
+	if _, err := utils.IncrementAttempts(ctx, accountAttemptsKey(realm, email), loginAttemptsWindow); err != nil {
+		return err
+	}
+	if _, err := utils.IncrementAttempts(ctx, ipAttemptsKey(ip), loginAttemptsWindow); err != nil {
+		return err
+	}
+	return utils.AddAttemptSource(ctx, accountAttemptsKey(realm, email), ip, loginAttemptsWindow)
+}
+
+// resetLoginFailures borra los fallos de la cuenta tras un login correcto
+func resetLoginFailures(ctx context.Context, realm string, email string) error {
+	return utils.ResetAttempts(ctx, accountAttemptsKey(realm, email))
+}
+
+// loginLockedResponse es la respuesta cuando hay que esperar wait para volver a intentarlo
+func loginLockedResponse(c *fiber.Ctx, wait time.Duration) error {
+	seconds := int((wait + time.Second - 1) / time.Second)
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(seconds))
+	return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+		"statusCode": 429,
+		"message":    "Too many failed attempts, try again later",
+	})
+}
+
+// UnlockUser borra los intentos fallidos de login de un usuario y de las IPs desde
+// las que falló
+func UnlockUser(c *fiber.Ctx) error {
+	return unlockAccount(c, models.RealmUsers)
+}
+
+// UnlockCustomer borra los intentos fallidos de login de un cliente y de las IPs desde
+// las que falló
+func UnlockCustomer(c *fiber.Ctx) error {
+	return unlockAccount(c, models.RealmCustomers)
+}
+
+// unlockAccount desbloquea la cuenta :id del realm
+func unlockAccount(c *fiber.Ctx, realm string) error {
+	name := "User"
+	if realm == models.RealmCustomers {
+		name = "Customer"
+	}
+
+	id := c.Params("id")
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"statusCode": 400,
+			"message":    "Invalid ID",
+		})
+	}
+
+	var account struct {
+		Email string `bson:"email"`
+	}
+	err = realmCollection(realm).FindOne(c.Context(), bson.M{"_id": objectId}).Decode(&account)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"statusCode": 404,
+				"message":    name + " not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"statusCode": 500,
+			"message":    "Internal Server Error",
+		})
+	}
+
+	// Las IPs desde las que falló la cuenta se desbloquean con ella
+	accountKey := accountAttemptsKey(realm, account.Email)
+	ips, err := utils.AttemptSources(c.Context(), accountKey)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"statusCode": 500,
+			"message":    "Internal Server Error",
+		})
+	}
+	for _, ip := range ips {
+		if err := utils.ResetAttempts(c.Context(), ipAttemptsKey(ip)); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"statusCode": 500,
+				"message":    "Internal Server Error",
+			})
+		}
+	}
+
+	if err := utils.ResetAttempts(c.Context(), accountKey); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"statusCode": 500,
+			"message":    "Internal Server Error",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"statusCode": 200,
+		"message":    name + " unlocked",
+		"id":         id,
+	})
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"context"
+	"main/database"
+	"main/models"
+	"main/utils"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// counterResponse simula el contador de login_attempts con count fallos, el último hace ago
+func counterResponse(mt *mtest.T, count int64, ago time.Duration) bson.D {
+	ns := mt.DB.Name() + ".login_attempts"
+	if count == 0 {
+		return mtest.CreateCursorResponse(0, ns, mtest.FirstBatch)
+	}
+	return mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, bson.D{
+		{Key: "key", Value: "k"},
+		{Key: "count", Value: count},
+		{Key: "expires_at", Value: time.Now().Add(loginAttemptsWindow - ago)},
+	})
+}
+
+func TestCheckLoginAllowed(t *testing.T) {
+	utils.Cache = nil
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	tests := []struct {
+		name       string
+		account    int64
+		accountAgo time.Duration
+		ip         int64
+		min, max   time.Duration
+	}{
+		{"no failures", 0, 0, 0, 0, 0},
+		{"below delay", loginDelayAfter - 1, 0, 0, 0, 0},
+		{"first delay", loginDelayAfter, 0, 0, 900 * time.Millisecond, time.Second},
+		{"delay already waited", loginDelayAfter, 2 * time.Second, 0, 0, 0},
+		{"max delay", loginDelayAfter + 6, time.Second, 0, loginMaxDelay - 2*time.Second, loginMaxDelay - time.Second},
+		{"account locked", loginMaxAccountFailures, time.Minute, 0, loginAttemptsWindow - 2*time.Minute, loginAttemptsWindow - time.Minute},
+		{"ip locked", 0, 0, loginMaxIPFailures, loginAttemptsWindow - time.Minute, loginAttemptsWindow},
+	}
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			database.Mg.Db = mt.DB
+			mt.AddMockResponses(counterResponse(mt, tt.account, tt.accountAgo), counterResponse(mt, tt.ip, 0))
+
+			// Sin esperas en el servidor: la respuesta es inmediata aunque haya retardo
+			start := time.Now()
+			wait, err := checkLoginAllowed(context.Background(), models.RealmUsers, "ana@example.com", "10.0.0.1")
+			if err != nil {
+				mt.Fatal(err)
+			}
+			if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+				mt.Errorf("checkLoginAllowed took %v", elapsed)
+			}
+			if wait < tt.min || wait > tt.max {
+				mt.Errorf("wait = %v, want between %v and %v", wait, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func TestLoginLockedResponse(t *testing.T) {
+	tests := []struct {
+		wait time.Duration
+		want string
+	}{
+		{time.Millisecond, "1"},
+		{time.Second, "1"},
+		{1500 * time.Millisecond, "2"},
+		{loginAttemptsWindow, "900"},
+	}
+	for _, tt := range tests {
+		app := fiber.New()
+		app.Get("/", func(c *fiber.Ctx) error { return loginLockedResponse(c, tt.wait) })
+		res, err := app.Test(httptest.NewRequest("GET", "/", nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != fiber.StatusTooManyRequests || res.Header.Get(fiber.HeaderRetryAfter) != tt.want {
+			t.Errorf("wait %v: status = %d, Retry-After = %q, want 429 and %q", tt.wait, res.StatusCode, res.Header.Get(fiber.HeaderRetryAfter), tt.want)
+		}
+	}
+}
+
+func TestUnlockResetsIPs(t *testing.T) {
+	utils.Cache = nil
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	tests := []struct {
+		realm string
+		route string
+		do    fiber.Handler
+		coll  string
+	}{
+		{models.RealmUsers, "/api/users/:id/unlock", UnlockUser, "users"},
+		{models.RealmCustomers, "/api/customers/:id/unlock", UnlockCustomer, "customers"},
+	}
+	for _, tt := range tests {
+		app := fiber.New()
+		app.Post(tt.route, tt.do)
+
+		mt.Run(tt.realm, func(mt *mtest.T) {
+			database.Mg.Db = mt.DB
+			ns := mt.DB.Name() + ".login_attempts"
+			mt.AddMockResponses(
+				mtest.CreateCursorResponse(0, mt.DB.Name()+"."+tt.coll, mtest.FirstBatch, bson.D{{Key: "email", Value: "ana@example.com"}}),
+				mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, bson.D{{Key: "sources", Value: bson.A{"10.0.0.1", "10.0.0.2"}}}),
+				mtest.CreateSuccessResponse(),
+				mtest.CreateSuccessResponse(),
+				mtest.CreateSuccessResponse(),
+			)
+
+			url := strings.Replace(tt.route, ":id", primitive.NewObjectID().Hex(), 1)
+			res, err := app.Test(httptest.NewRequest("POST", url, nil))
+			if err != nil {
+				mt.Fatal(err)
+			}
+			if res.StatusCode != fiber.StatusOK {
+				mt.Fatalf("status = %d", res.StatusCode)
+			}
+
+			// La cuenta se busca en la colección del realm
+			if find := mt.GetAllStartedEvents()[0]; find.Command.Lookup("find").StringValue() != tt.coll {
+				mt.Errorf("account looked up in %s, want %s", find.Command.Lookup("find"), tt.coll)
+			}
+
+			// Se borran los contadores de las dos IPs y el de la cuenta
+			deleted := map[string]bool{}
+			for _, event := range mt.GetAllStartedEvents() {
+				if event.CommandName != "delete" {
+					continue
+				}
+				deletes, _ := event.Command.Lookup("deletes").Array().Values()
+				for _, d := range deletes {
+					keys, _ := d.Document().Lookup("q", "key", "$in").Array().Values()
+					for _, key := range keys {
+						deleted[key.StringValue()] = true
+					}
+				}
+			}
+			for _, key := range []string{ipAttemptsKey("10.0.0.1"), ipAttemptsKey("10.0.0.2"), accountAttemptsKey(tt.realm, "ana@example.com")} {
+				if !deleted[key] {
+					mt.Errorf("counter %q not reset, deleted %v", key, deleted)
+				}
+			}
+		})
+	}
+}
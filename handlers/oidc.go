@@ -0,0 +1,306 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log"
+	"main/config"
+	"main/database"
+	"main/models"
+	"main/oidc"
+	"main/utils"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Cookie que ata el state del login OIDC al navegador que lo empezó
+const oidcStateCookie = "oidc_state"
+
+var errOIDCDisabled = errors.New("oidc is not configured")
+
+var (
+	oidcMu       sync.Mutex
+	oidcProvider *oidc.Provider
+)
+
+// getOIDCProvider hace el discovery del proveedor la primera vez que se usa
+func getOIDCProvider(ctx context.Context) (*oidc.Provider, error) {
+	oidcMu.Lock()
+	defer oidcMu.Unlock()
+
+	if oidcProvider != nil {
+		return oidcProvider, nil
+	}
+	issuer := config.Config("OIDC_ISSUER")
+	if issuer == "" {
+		return nil, errOIDCDisabled
+	}
+	provider, err := oidc.Discover(ctx, issuer,
+		config.Config("OIDC_CLIENT_ID"),
+		config.Config("OIDC_CLIENT_SECRET"),
+		config.Config("OIDC_REDIRECT_URL"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	oidcProvider = provider
+	return oidcProvider, nil
+}
+
+// OIDCLogin redirige al proveedor de identidad con authorization code + PKCE
+func OIDCLogin(c *fiber.Ctx) error {
+	provider, err := getOIDCProvider(c.Context())
+	if err != nil {
+		if err == errOIDCDisabled {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"statusCode": 404,
+				"message":    "OIDC login is not enabled",
+			})
+		}
+		log.Println("oidc:", err)
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
+			"statusCode": 502,
+			"message":    "Identity provider unavailable",
+		})
+	}
+
+	state, err := utils.RandomToken(16)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"statusCode": 500,
+			"message":    "Internal Server Error",
+		})
+	}
+	nonce, err := utils.RandomToken(16)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"statusCode": 500,
+			"message":    "Internal Server Error",
+		})
+	}
+	verifier, challenge, err := oidc.NewPKCE()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"statusCode": 500,
+			"message":    "Internal Server Error",
+		})
+	}
+
+	_, err = database.Mg.Db.Collection("oidc_states").InsertOne(c.Context(), models.OIDCState{
+		State:        state,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		ExpiresAt:    time.Now().Add(utils.OIDCStateDuration),
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"statusCode": 500,
+			"message":    "Internal Server Error",
+		})
+	}
+
+	// Sin esta cookie el callback no acepta el state, así nadie puede terminar su
+	// login en el navegador de otro (login CSRF)
+	cookie, err := utils.GenerateOIDCStateToken(state)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"statusCode": 500,
+			"message":    "Internal Server Error",
+		})
+	}
+	setOIDCStateCookie(c, cookie, time.Now().Add(utils.OIDCStateDuration))
+
+	return c.Redirect(provider.AuthCodeURL(state, nonce, challenge), fiber.StatusFound)
+}
+
+// setOIDCStateCookie guarda la cookie del state, o la borra con una fecha pasada. Lax
+// para que el navegador la envíe en la redirección de vuelta del proveedor.
+func setOIDCStateCookie(c *fiber.Ctx, value string, expires time.Time) {
+	c.Cookie(&fiber.Cookie{
+		Name:     oidcStateCookie,
+		Value:    value,
+		Path:     "/api/auth/oidc",
+		Expires:  expires,
+		Secure:   strings.HasPrefix(config.Config("APP_URL"), "https://"),
+		HTTPOnly: true,
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+}
+
+// OIDCCallback recibe el code del proveedor, valida el ID token, vincula o crea el
+// usuario y termina el login con los tokens de la API
+func OIDCCallback(c *fiber.Ctx) error {
+	if errParam := c.Query("error"); errParam != "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"statusCode": 401,
+			"message":    "Identity provider error: " + errParam,
+		})
+	}
+
+	provider, err := getOIDCProvider(c.Context())
+	if err != nil {
+		if err == errOIDCDisabled {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"statusCode": 404,
+				"message":    "OIDC login is not enabled",
+			})
+		}
+		log.Println("oidc:", err)
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
+			"statusCode": 502,
+			"message":    "Identity provider unavailable",
+		})
+	}
+
+	// El state tiene que ser el de la cookie de este navegador
+	cookieState, err := utils.ParseOIDCStateToken(c.Cookies(oidcStateCookie))
+	setOIDCStateCookie(c, "", time.Unix(0, 0))
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookieState), []byte(c.Query("state"))) != 1 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"statusCode": 400,
+			"message":    "Invalid or expired login request",
+		})
+	}
+
+	// El state es de un solo uso
+	var state models.OIDCState
+	err = database.Mg.Db.Collection("oidc_states").FindOneAndDelete(c.Context(), bson.M{
+		"state":      c.Query("state"),
+		"expires_at": bson.M{"$gt": time.Now()},
+	}).Decode(&state)
+	if err != nil || c.Query("code") == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"statusCode": 400,
+			"message":    "Invalid or expired login request",
+		})
+	}
+
+	token, err := provider.Exchange(c.Context(), c.Query("code"), state.CodeVerifier)
+	if err != nil {
+		log.Println("oidc:", err)
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"statusCode": 401,
+			"message":    "Could not complete login with the identity provider",
+		})
+	}
+
+	claims, err := provider.VerifyIDToken(c.Context(), token.IDToken, state.Nonce)
+	if err != nil {
+		log.Println("oidc:", err)
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"statusCode": 401,
+			"message":    "Invalid ID token",
+		})
+	}
+
+	user, err := findOrProvisionOIDCUser(c.Context(), provider.Issuer, claims)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"statusCode": 403,
+				"message":    "No account linked to this identity",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"statusCode": 500,
+			"message":    "Internal Server Error",
+		})
+	}
+
+	// El segundo factor de la API se sigue pidiendo si el usuario lo activó
+	if user.TOTPEnabled {
+		challengeToken, err := utils.GenerateChallengeToken(user.ID.Hex())
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"statusCode": 500,
+				"message":    "Error generating token",
+			})
+		}
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"statusCode":          200,
+			"message":             "Two-factor authentication required",
+			"two_factor_required": true,
+			"challenge_token":     challengeToken,
+			"expires_in":          int(utils.ChallengeTokenDuration.Seconds()),
+		})
+	}
+
+	return completeLogin(c, *user)
+}
+
+// findOrProvisionOIDCUser busca el usuario vinculado a la identidad. Si no hay ninguno
+// vincula el usuario con el mismo email verificado o, con OIDC_AUTO_PROVISION=true,
+// crea el usuario y su perfil. Devuelve mongo.ErrNoDocuments si no puede hacer nada.
+func findOrProvisionOIDCUser(ctx context.Context, issuer string, claims *oidc.IDTokenClaims) (*models.Users, error) {
+	collection := database.Mg.Db.Collection("users")
+
+	var user models.Users
+	err := collection.FindOne(ctx, bson.M{"oidc_issuer": issuer, "oidc_subject": claims.Subject}).Decode(&user)
+	if err == nil {
+		return &user, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+
+	// Sin email verificado por el proveedor no se vincula ni se crea nada
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, mongo.ErrNoDocuments
+	}
+
+	link := bson.M{"oidc_issuer": issuer, "oidc_subject": claims.Subject, "email_verified": true}
+	err = collection.FindOneAndUpdate(ctx,
+		bson.M{"email": claims.Email, "oidc_subject": bson.M{"$exists": false}},
+		bson.M{"$set": link},
+	).Decode(&user)
+	if err == nil {
+		return &user, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+
+	if config.Config("OIDC_AUTO_PROVISION") != "true" {
+		return nil, mongo.ErrNoDocuments
+	}
+
+	role := config.Config("OIDC_DEFAULT_ROLE")
+	if !models.IsValidRole(role) {
+		role = models.RoleViewer
+	}
+	verified := true
+	name := claims.Name
+	if name == "" {
+		name = claims.Email
+	}
+	user = models.Users{
+		ID:            primitive.NewObjectID(),
+		Name:          name,
+		Email:         claims.Email,
+		Role:          role,
+		EmailVerified: &verified,
+		OIDCIssuer:    issuer,
+		OIDCSubject:   claims.Subject,
+	}
+	if _, err := collection.InsertOne(ctx, user); err != nil {
+		return nil, err
+	}
+
+	_, err = database.Mg.Db.Collection("profile").InsertOne(ctx, models.Profile{
+		UserID:    user.ID,
+		FirstName: claims.GivenName,
+		LastName:  claims.FamilyName,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"main/database"
+	"main/oidc"
+	"main/oidc/oidctest"
+	"main/utils"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// setupOIDC configura las claves de la API y el proveedor OIDC contra un proveedor local
+func setupOIDC(t *testing.T) (*oidctest.Server, *fiber.App) {
+	t.Helper()
+	setTestKeys()
+
+	server := oidctest.NewServer("client")
+	provider, err := oidc.Discover(context.Background(), server.URL, "client", "", "http://localhost/api/auth/oidc/callback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	oidcProvider = provider
+	t.Cleanup(func() {
+		oidcProvider = nil
+		server.Close()
+	})
+
+	app := fiber.New()
+	app.Get("/api/auth/oidc/login", OIDCLogin)
+	app.Get("/api/auth/oidc/callback", OIDCCallback)
+	return server, app
+}
+
+func stateCookie(t *testing.T, res *http.Response) *http.Cookie {
+	t.Helper()
+	for _, cookie := range res.Cookies() {
+		if cookie.Name == oidcStateCookie {
+			return cookie
+		}
+	}
+	t.Fatal("response without oidc_state cookie")
+	return nil
+}
+
+func callback(t *testing.T, app *fiber.App, state string, cookie string) *http.Response {
+	t.Helper()
+	req := httptest.NewRequest("GET", "/api/auth/oidc/callback?code=code&state="+url.QueryEscape(state), nil)
+	if cookie != "" {
+		req.AddCookie(&http.Cookie{Name: oidcStateCookie, Value: cookie})
+	}
+	res, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestOIDCLoginSetsStateCookie(t *testing.T) {
+	_, app := setupOIDC(t)
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("login", func(mt *mtest.T) {
+		database.Mg.Db = mt.DB
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+		res, err := app.Test(httptest.NewRequest("GET", "/api/auth/oidc/login", nil))
+		if err != nil {
+			mt.Fatal(err)
+		}
+		if res.StatusCode != fiber.StatusFound {
+			mt.Fatalf("status = %d", res.StatusCode)
+		}
+		location, err := url.Parse(res.Header.Get("Location"))
+		if err != nil {
+			mt.Fatal(err)
+		}
+		state := location.Query().Get("state")
+		if state == "" || location.Query().Get("code_challenge_method") != "S256" {
+			mt.Fatalf("redirect = %s", location)
+		}
+
+		cookie := stateCookie(mt.T, res)
+		if !cookie.HttpOnly || cookie.SameSite != http.SameSiteLaxMode || cookie.Path != "/api/auth/oidc" {
+			mt.Errorf("cookie = %+v", cookie)
+		}
+		got, err := utils.ParseOIDCStateToken(cookie.Value)
+		if err != nil || got != state {
+			mt.Errorf("cookie state = %q, %v, want %q", got, err, state)
+		}
+	})
+}
+
+func TestOIDCCallback(t *testing.T) {
+	server, app := setupOIDC(t)
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	signedState := func(state string) string {
+		token, err := utils.GenerateOIDCStateToken(state)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	// Sin la cookie del navegador que empezó el login no se llega a consultar la BD
+	rejected := []struct {
+		name   string
+		cookie string
+	}{
+		{"without cookie", ""},
+		{"cookie of another state", signedState("other-state")},
+		{"forged cookie", "not-a-token"},
+	}
+	for _, tt := range rejected {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			database.Mg.Db = mt.DB
+			res := callback(mt.T, app, "state-1", tt.cookie)
+			if res.StatusCode != fiber.StatusBadRequest {
+				mt.Errorf("status = %d, want 400", res.StatusCode)
+			}
+			if events := mt.GetAllStartedEvents(); len(events) != 0 {
+				mt.Errorf("callback sent %d commands to the database", len(events))
+			}
+		})
+	}
+
+	storedState := func() bson.D {
+		return mtest.CreateSuccessResponse(bson.E{Key: "value", Value: bson.D{
+			{Key: "state", Value: "state-1"},
+			{Key: "nonce", Value: "nonce-1"},
+			{Key: "code_verifier", Value: "verifier-1"},
+		}})
+	}
+
+	mt.Run("valid state", func(mt *mtest.T) {
+		database.Mg.Db = mt.DB
+		server.SetIDToken(server.Sign(server.Claims("sub-1", "nonce-1")))
+		mt.AddMockResponses(
+			storedState(),
+			mtest.CreateCursorResponse(0, mt.DB.Name()+".users", mtest.FirstBatch, bson.D{
+				{Key: "_id", Value: primitive.NewObjectID()},
+				{Key: "email", Value: "staff@example.com"},
+				{Key: "totp_enabled", Value: true},
+			}),
+		)
+
+		res := callback(mt.T, app, "state-1", signedState("state-1"))
+		body, _ := ioutil.ReadAll(res.Body)
+		if res.StatusCode != fiber.StatusOK {
+			mt.Fatalf("status = %d, body = %s", res.StatusCode, body)
+		}
+		var decoded map[string]interface{}
+		if err := json.Unmarshal(body, &decoded); err != nil {
+			mt.Fatal(err)
+		}
+		if decoded["two_factor_required"] != true {
+			mt.Errorf("body = %s", body)
+		}
+		if got := server.CodeVerifier(); got != "verifier-1" {
+			mt.Errorf("code_verifier sent = %q", got)
+		}
+		// La cookie se borra al usarla
+		if cookie := stateCookie(mt.T, res); cookie.Value != "" {
+			mt.Errorf("cookie not cleared: %+v", cookie)
+		}
+	})
+
+	mt.Run("wrong nonce", func(mt *mtest.T) {
+		database.Mg.Db = mt.DB
+		server.SetIDToken(server.Sign(server.Claims("sub-1", "other-nonce")))
+		mt.AddMockResponses(storedState())
+
+		res := callback(mt.T, app, "state-1", signedState("state-1"))
+		if res.StatusCode != fiber.StatusUnauthorized {
+			mt.Errorf("status = %d, want 401", res.StatusCode)
+		}
+	})
+}
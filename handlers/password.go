@@ -0,0 +1,325 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"main/config"
+	"main/database"
+	"main/mailer"
+	"main/middleware"
+	"main/models"
+	"main/utils"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Duración de los enlaces para restablecer la contraseña
+const passwordResetDuration = time.Hour
+
+// ForgotPassword envía un enlace para restablecer la contraseña.
+// Responde lo mismo exista o no el email para no revelar qué cuentas hay registradas.
+func ForgotPassword(c *fiber.Ctx) error {
+	var body struct {
+		Email string `json:"email"`
+	}
+	if err := c.BodyParser(&body); err != nil || body.Email == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"statusCode": 400,
+			"message":    "Invalid request body",
+		})
+	}
+
+	response := fiber.Map{
+		"statusCode": 200,
+		"message":    "If the email is registered you will receive a link to reset your password",
+	}
+
+	var user models.Users
+	err := database.Mg.Db.Collection("users").FindOne(c.Context(), bson.M{"email": body.Email}).Decode(&user)
+	if err != nil {
+		if err != mongo.ErrNoDocuments {
+			log.Println("forgot password:", err)
+		}
+		return c.Status(fiber.StatusOK).JSON(response)
+	}
+
+	// El correo se envía en segundo plano para que el tiempo de respuesta no delate la cuenta
+	go func() {
+		if err := sendPasswordReset(context.Background(), user); err != nil {
+			log.Println("forgot password:", err)
+		}
+	}()
+
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+func sendPasswordReset(ctx context.Context, user models.Users) error {
+	collection := database.Mg.Db.Collection("password_resets")
+
+	// Solo vale el último enlace enviado
+	if _, err := collection.DeleteMany(ctx, bson.M{"user_id": user.ID, "used_at": nil}); err != nil {
+		return err
+	}
+
+	token, err := utils.RandomToken(32)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	_, err = collection.InsertOne(ctx, models.PasswordReset{
+		TokenHash: utils.HashToken(token),
+		UserID:    user.ID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(passwordResetDuration),
+	})
+	if err != nil {
+		return err
+	}
+
+	return mailer.Default.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf(
+			"Use the following link to reset your password. It expires in %d minutes.\n\n%s/reset-password?token=%s\n\nIf you did not request it you can ignore this email.\n",
+			int(passwordResetDuration.Minutes()), config.Config("APP_URL"), token,
+		),
+	})
+}
+
+// passwordPolicyError responde 400 con los requisitos de la política que no se cumplen
+func passwordPolicyError(c *fiber.Ctx, errs []models.FieldError) error {
+	return c.Status(fiber.StatusBadRequest).JSON(models.ValidationError{
+		Message:    "Password does not meet the password policy",
+		StatusCode: 400,
+		Errors:     errs,
+	})
+}
+
+// ResetPassword cambia la contraseña con un token de ForgotPassword y cierra todas las sesiones
+func ResetPassword(c *fiber.Ctx) error {
+	var body struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := c.BodyParser(&body); err != nil || body.Token == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"statusCode": 400,
+			"message":    "Invalid request body",
+		})
+	}
+	if body.Password == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"statusCode": 400,
+			"message":    "Password is required",
+		})
+	}
+
+	// Validar la contraseña antes de gastar el token para que se pueda reintentar
+	now := time.Now()
+	filter := bson.M{
+		"token_hash": utils.HashToken(body.Token),
+		"used_at":    nil,
+		"expires_at": bson.M{"$gt": now},
+	}
+	var reset models.PasswordReset
+	err := database.Mg.Db.Collection("password_resets").FindOne(c.Context(), filter).Decode(&reset)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"statusCode": 400,
+				"message":    "Invalid or expired token",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"statusCode": 500,
+			"message":    "Internal Server Error",
+		})
+	}
+
+	var user models.Users
+	if err := database.Mg.Db.Collection("users").FindOne(c.Context(), bson.M{"_id": reset.UserID}).Decode(&user); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"statusCode": 400,
+			"message":    "Invalid or expired token",
+		})
+	}
+	if errs := utils.Policy.Validate(body.Password, user.Email, user.Name); len(errs) > 0 {
+		return passwordPolicyError(c, errs)
+	}
+
+	// Marcar el token como usado en la misma operación que lo busca para que sea de un solo uso
+	err = database.Mg.Db.Collection("password_resets").FindOneAndUpdate(c.Context(), filter,
+		bson.M{"$set": bson.M{"used_at": now}},
+	).Decode(&reset)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"statusCode": 400,
+				"message":    "Invalid or expired token",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"statusCode": 500,
+			"message":    "Internal Server Error",
+		})
+	}
+
+	hashedPassword, err := utils.Passwords.Hash(body.Password)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"statusCode": 500,
+			"message":    "Error hashing password",
+		})
+	}
+
+	_, err = database.Mg.Db.Collection("users").UpdateOne(c.Context(), bson.M{"_id": reset.UserID}, bson.M{
+		"$set": bson.M{"password": hashedPassword},
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"statusCode": 500,
+			"message":    "Internal Server Error",
+		})
+	}
+
+	if err := revokeUserSessions(c.Context(), reset.UserID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"statusCode": 500,
+			"message":    "Internal Server Error",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"statusCode": 200,
+		"message":    "Password reset successfully",
+	})
+}
+
+// changePasswordBody es el cuerpo de los endpoints para cambiar la contraseña
+type changePasswordBody struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// ChangePassword cambia la contraseña del usuario autenticado. Solo la puede cambiar
+// el propio usuario; para otra cuenta está el flujo de forgot-password.
+func ChangePassword(c *fiber.Ctx) error {
+	claims := middleware.CurrentUser(c)
+	if claims == nil || claims.APIKeyID != "" || claims.Subject != c.Params("id") {
+		return c.Status(fiber.StatusForbidden).JSON(models.Error{
+			Message:    "Forbidden",
+			StatusCode: 403,
+		})
+	}
+
+	user, err := currentDBUser(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"statusCode": 401,
+			"message":    "Invalid token",
+		})
+	}
+
+	return changePassword(c, models.RealmUsers, user.ID, user.Email, user.Name, user.Password)
+}
+
+// ChangeMyPassword cambia la contraseña del cliente autenticado
+func ChangeMyPassword(c *fiber.Ctx) error {
+	customerID, err := primitive.ObjectIDFromHex(middleware.CurrentUser(c).Subject)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"statusCode": 401,
+			"message":    "Invalid token",
+		})
+	}
+
+	var customer models.Customer
+	if err := database.Mg.Db.Collection("customers").FindOne(c.Context(), bson.M{"_id": customerID}).Decode(&customer); err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"statusCode": 401,
+			"message":    "Invalid token",
+		})
+	}
+
+	return changePassword(c, models.RealmCustomers, customerID, customer.Email, customer.Name, customer.Password)
+}
+
+// changePassword comprueba la contraseña actual, guarda el hash de la nueva y cierra
+// todas las sesiones de la cuenta. Los fallos cuentan para el bloqueo de Login.
+func changePassword(c *fiber.Ctx, realm string, id primitive.ObjectID, email string, name string, encoded string) error {
+	var body changePasswordBody
+	if err := c.BodyParser(&body); err != nil || body.CurrentPassword == "" || body.NewPassword == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"statusCode": 400,
+			"message":    "Invalid request body",
+		})
+	}
+
+	wait, err := checkLoginAllowed(c.Context(), realm, email, c.IP())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"statusCode": 500,
+			"message":    "Internal Server Error",
+		})
+	}
+	if wait > 0 {
+		return loginLockedResponse(c, wait)
+	}
+
+	collection := realmCollection(realm)
+	if !checkPassword(c.Context(), collection.Name(), id, encoded, body.CurrentPassword) {
+		if err := recordLoginFailure(c.Context(), realm, email, c.IP()); err != nil {
+			log.Println("login attempts:", err)
+		}
+		return c.Status(fiber.StatusBadRequest).JSON(models.ValidationError{
+			Message:    "Current password is incorrect",
+			StatusCode: 400,
+			Errors: []models.FieldError{
+				{Field: "current_password", Code: "invalid", Message: "Current password is incorrect"},
+			},
+		})
+	}
+	if err := resetLoginFailures(c.Context(), realm, email); err != nil {
+		log.Println("login attempts:", err)
+	}
+
+	if errs := utils.Policy.Validate(body.NewPassword, email, name); len(errs) > 0 {
+		return passwordPolicyError(c, errs)
+	}
+
+	hashedPassword, err := utils.Passwords.Hash(body.NewPassword)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"statusCode": 500,
+			"message":    "Error hashing password",
+		})
+	}
+
+	_, err = collection.UpdateOne(c.Context(), bson.M{"_id": id}, bson.M{
+		"$set": bson.M{"password": hashedPassword},
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"statusCode": 500,
+			"message":    "Internal Server Error",
+		})
+	}
+
+	// Cerrar todas las sesiones, también la actual
+	if err := revokeUserSessions(c.Context(), id); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"statusCode": 500,
+			"message":    "Internal Server Error",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"statusCode": 200,
+		"message":    "Password changed successfully",
+	})
+}
@@ -0,0 +1,536 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"main/database"
+	"main/models"
+	"sort"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Tiempo máximo que un producto se queda reservado para crear un precio programado
+const priceScheduleLockTime = 10 * time.Second
+
+// Campos por los que se puede ordenar el historial de precios
+var priceHistoryListOptions = listOptions{
+	sortable: map[string]string{
+		"created_at": "created_at",
+	},
+	defaultSort: "-created_at",
+}
+
+// Campos por los que se pueden ordenar los precios programados
+var priceScheduleListOptions = listOptions{
+	sortable: map[string]string{
+		"starts_at":  "starts_at",
+		"created_at": "created_at",
+	},
+	defaultSort: "starts_at",
+}
+
+// productPrices devuelve los precios del producto por moneda, el base y los fijados
+func productPrices(product *models.Product) map[string]models.Money {
+	prices := make(map[string]models.Money)
+	if product == nil {
+		return prices
+	}
+	if product.Price.Currency != "" {
+		prices[product.Price.Currency] = product.Price
+	}
+	for _, price := range product.Prices {
+		prices[price.Currency] = price
+	}
+	return prices
+}
+
+// recordPriceChanges guarda en el historial un cambio por cada moneda en la que el
+// precio es distinto entre before y after. change lleva el origen y quién lo hace;
+// before es nil en los productos nuevos.
+func recordPriceChanges(ctx context.Context, productID primitive.ObjectID, before, after *models.Product, change models.PriceChange) error {
+	old, updated := productPrices(before), productPrices(after)
+
+	currencies := make([]string, 0, len(old)+len(updated))
+	for currency := range old {
+		currencies = append(currencies, currency)
+	}
+	for currency := range updated {
+		if _, ok := old[currency]; !ok {
+			currencies = append(currencies, currency)
+		}
+	}
+	sort.Strings(currencies)
+
+	now := time.Now()
+	docs := make([]interface{}, 0)
+	for _, currency := range currencies {
+		oldPrice, hadOld := old[currency]
+		newPrice, hasNew := updated[currency]
+		if hadOld && hasNew && oldPrice == newPrice {
+			continue
+		}
+		entry := change
+		entry.ProductID = productID
+		entry.Currency = currency
+		entry.CreatedAt = now
+		if hadOld {
+			entry.OldPrice = &oldPrice
+		}
+		if hasNew {
+			entry.NewPrice = &newPrice
+		}
+		docs = append(docs, entry)
+	}
+	if len(docs) == 0 {
+		return nil
+	}
+
+	_, err := database.Mg.Db.Collection("price_changes").InsertMany(ctx, docs)
+	return err
+}
+
+// setScheduledPrice cambia el precio base del producto si es from, o siempre si from es
+// nil, y lo apunta en el historial. Devuelve el producto como estaba antes o nil si no
+// se ha cambiado.
+func setScheduledPrice(ctx context.Context, schedule *models.ScheduledPrice, from *models.Money, to models.Money, change models.PriceChange) (*models.Product, error) {
+	filter := bson.M{"_id": schedule.ProductID}
+	if from != nil {
+		filter["price"] = *from
+	}
+
+	var before models.Product
+	err := database.Mg.Db.Collection("Products").FindOneAndUpdate(ctx, filter,
+		bson.M{"$set": bson.M{"price": to}},
+		options.FindOneAndUpdate().SetReturnDocument(options.Before),
+	).Decode(&before)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	after := before
+	after.Price = to
+	change.ScheduleID = &schedule.ID
+	if err := recordPriceChanges(ctx, schedule.ProductID, &before, &after, change); err != nil {
+		log.Println("price history:", err)
+	}
+
+	// Mantener el índice de búsqueda al día
+	reindexProduct(ctx, after.ID)
+
+	return &before, nil
+}
+
+// restoreSchedule devuelve a to un programado que está en from cuando no se ha podido
+// aplicar o retirar, para que se vuelva a intentar. Quita los campos de unset.
+func restoreSchedule(ctx context.Context, id primitive.ObjectID, from, to string, unset ...string) {
+	update := bson.M{"$set": bson.M{"status": to}}
+	if len(unset) > 0 {
+		fields := bson.M{}
+		for _, field := range unset {
+			fields[field] = ""
+		}
+		update["$unset"] = fields
+	}
+	_, err := database.Mg.Db.Collection("price_schedules").UpdateOne(ctx, bson.M{"_id": id, "status": from}, update)
+	if err != nil {
+		log.Println("price scheduler:", err)
+	}
+}
+
+// applyNextScheduledPrice aplica el siguiente precio programado que ya ha empezado. El
+// precio que tenía el producto se guarda en la misma operación que reclama el
+// programado, para que siempre se pueda volver a él. Los precios sin fecha de fin se
+// quedan y el programado termina al aplicarse. Devuelve false si no queda ninguno.
+func applyNextScheduledPrice(ctx context.Context, now time.Time) (bool, error) {
+	collection := database.Mg.Db.Collection("price_schedules")
+
+	var schedule models.ScheduledPrice
+	err := collection.FindOne(ctx,
+		bson.M{"status": models.ScheduleStatusPending, "starts_at": bson.M{"$lte": now}},
+		options.FindOne().SetSort(bson.D{{Key: "starts_at", Value: 1}}),
+	).Decode(&schedule)
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	product, err := findProduct(ctx, schedule.ProductID)
+	if err == mongo.ErrNoDocuments {
+		// El producto ya no existe
+		_, err := collection.UpdateOne(ctx,
+			bson.M{"_id": schedule.ID, "status": models.ScheduleStatusPending},
+			bson.M{"$set": bson.M{"status": models.ScheduleStatusCancelled, "ended_at": now}},
+		)
+		return err == nil, err
+	}
+	if err != nil {
+		return false, err
+	}
+
+	status := models.ScheduleStatusActive
+	set := bson.M{"status": status, "applied_at": now, "previous_price": product.Price}
+	if schedule.EndsAt == nil {
+		status = models.ScheduleStatusEnded
+		set["status"] = status
+		set["ended_at"] = now
+	}
+	res, err := collection.UpdateOne(ctx, bson.M{"_id": schedule.ID, "status": models.ScheduleStatusPending}, bson.M{"$set": set})
+	if err != nil {
+		return false, err
+	}
+	if res.MatchedCount == 0 {
+		// Lo ha reclamado otra instancia
+		return true, nil
+	}
+
+	change := models.PriceChange{Source: models.PriceSourceSchedule, ActorType: "system"}
+	before, err := setScheduledPrice(ctx, &schedule, nil, schedule.Price, change)
+	if err != nil {
+		restoreSchedule(ctx, schedule.ID, status, models.ScheduleStatusPending, "applied_at", "previous_price", "ended_at")
+		return false, err
+	}
+	if before == nil {
+		// El producto se ha borrado mientras tanto
+		restoreSchedule(ctx, schedule.ID, status, models.ScheduleStatusCancelled)
+		return true, nil
+	}
+	if before.Price != product.Price {
+		// El precio ha cambiado entre la lectura y el cambio; si no se puede corregir se
+		// queda el de un momento antes
+		_, err := collection.UpdateOne(ctx, bson.M{"_id": schedule.ID}, bson.M{"$set": bson.M{"previous_price": before.Price}})
+		if err != nil {
+			log.Println("price scheduler:", err)
+		}
+	}
+	return true, nil
+}
+
+// revertScheduledPrice vuelve al precio que había antes del programado. Si mientras
+// tanto el precio se ha cambiado a mano se deja el nuevo.
+func revertScheduledPrice(ctx context.Context, schedule *models.ScheduledPrice, change models.PriceChange) error {
+	if schedule.PreviousPrice == nil {
+		return nil
+	}
+	change.Source = models.PriceSourceScheduleEnd
+	_, err := setScheduledPrice(ctx, schedule, &schedule.Price, *schedule.PreviousPrice, change)
+	return err
+}
+
+// ApplyScheduledPrices retira los precios programados que han terminado y aplica los
+// que empiezan. Cada programado se reclama con una sola operación para que no lo
+// procesen dos instancias de la API.
+func ApplyScheduledPrices(ctx context.Context, now time.Time) error {
+	collection := database.Mg.Db.Collection("price_schedules")
+
+	// Los que no llegaron a aplicarse antes de su fin ya no se aplican
+	_, err := collection.UpdateMany(ctx,
+		bson.M{"status": models.ScheduleStatusPending, "ends_at": bson.M{"$lte": now}},
+		bson.M{"$set": bson.M{"status": models.ScheduleStatusExpired, "ended_at": now}},
+	)
+	if err != nil {
+		return err
+	}
+
+	// Primero se retiran, por si en un producto uno termina cuando empieza otro
+	for {
+		var schedule models.ScheduledPrice
+		err := collection.FindOneAndUpdate(ctx,
+			bson.M{"status": models.ScheduleStatusActive, "ends_at": bson.M{"$lte": now}},
+			bson.M{"$set": bson.M{"status": models.ScheduleStatusEnded, "ended_at": now}},
+		).Decode(&schedule)
+		if err == mongo.ErrNoDocuments {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := revertScheduledPrice(ctx, &schedule, models.PriceChange{ActorType: "system"}); err != nil {
+			restoreSchedule(ctx, schedule.ID, models.ScheduleStatusEnded, models.ScheduleStatusActive, "ended_at")
+			return err
+		}
+	}
+
+	for {
+		more, err := applyNextScheduledPrice(ctx, now)
+		if err != nil || !more {
+			return err
+		}
+	}
+}
+
+// StartPriceScheduler aplica y retira los precios programados cada interval, en segundo plano
+func StartPriceScheduler(interval time.Duration) {
+	go func() {
+		for {
+			if err := ApplyScheduledPrices(context.Background(), time.Now()); err != nil {
+				log.Println("price scheduler:", err)
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// schedulesOverlap indica si dos precios programados coinciden en el tiempo. Uno sin
+// fin solo ocupa su inicio, porque a partir de ahí es el precio normal del producto.
+func schedulesOverlap(a, b *models.ScheduledPrice) bool {
+	end := func(s *models.ScheduledPrice) time.Time {
+		if s.EndsAt == nil {
+			return s.StartsAt
+		}
+		return *s.EndsAt
+	}
+	if a.EndsAt == nil && b.EndsAt == nil {
+		return a.StartsAt.Equal(b.StartsAt)
+	}
+	if a.EndsAt == nil {
+		return !a.StartsAt.Before(b.StartsAt) && a.StartsAt.Before(end(b))
+	}
+	if b.EndsAt == nil {
+		return !b.StartsAt.Before(a.StartsAt) && b.StartsAt.Before(end(a))
+	}
+	return a.StartsAt.Before(end(b)) && b.StartsAt.Before(end(a))
+}
+
+// GetPriceHistory lista los cambios de precio del producto, los últimos primero. Se
+// pueden filtrar por currency.
+func GetPriceHistory(c *fiber.Ctx) error {
+	productID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.Error{Message: "Invalid ID", StatusCode: 400})
+	}
+
+	query, errs := parseListQuery(c, priceHistoryListOptions)
+	if len(errs) > 0 {
+		return invalidQueryError(c, errs)
+	}
+	query.Filter["product_id"] = productID
+	if currency := c.Query("currency"); currency != "" {
+		query.Filter["currency"] = currency
+	}
+
+	changes := make([]models.PriceChange, 0)
+	page, err := query.Find(c.Context(), database.Mg.Db.Collection("price_changes"), nil, &changes)
+	if err != nil {
+		return findErrorResponse(c, err)
+	}
+
+	return c.JSON(fiber.Map{
+		"items":       changes,
+		"total":       page.Total,
+		"page":        page.Page,
+		"limit":       page.Limit,
+		"next_cursor": page.NextCursor,
+	})
+}
+
+// GetPriceSchedules lista los precios programados del producto. Se pueden filtrar por status.
+func GetPriceSchedules(c *fiber.Ctx) error {
+	productID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.Error{Message: "Invalid ID", StatusCode: 400})
+	}
+
+	query, errs := parseListQuery(c, priceScheduleListOptions)
+	if len(errs) > 0 {
+		return invalidQueryError(c, errs)
+	}
+	query.Filter["product_id"] = productID
+	if status := c.Query("status"); status != "" {
+		query.Filter["status"] = status
+	}
+
+	schedules := make([]models.ScheduledPrice, 0)
+	page, err := query.Find(c.Context(), database.Mg.Db.Collection("price_schedules"), nil, &schedules)
+	if err != nil {
+		return findErrorResponse(c, err)
+	}
+
+	return c.JSON(fiber.Map{
+		"items":       schedules,
+		"total":       page.Total,
+		"page":        page.Page,
+		"limit":       page.Limit,
+		"next_cursor": page.NextCursor,
+	})
+}
+
+// lockProductSchedules reserva el producto para comprobar los solapes y crear un precio
+// programado sin que otra petición lo haga a la vez. Devuelve false si ya está reservado
+// y mongo.ErrNoDocuments si el producto no existe. La reserva caduca sola por si la
+// petición no llega a liberarla.
+func lockProductSchedules(ctx context.Context, productID primitive.ObjectID) (bool, error) {
+	now := time.Now()
+	res, err := database.Mg.Db.Collection("Products").UpdateOne(ctx,
+		bson.M{"_id": productID, "schedules_locked_until": bson.M{"$not": bson.M{"$gt": now}}},
+		bson.M{"$set": bson.M{"schedules_locked_until": now.Add(priceScheduleLockTime)}},
+	)
+	if err != nil {
+		return false, err
+	}
+	if res.MatchedCount > 0 {
+		return true, nil
+	}
+	if _, err := findProduct(ctx, productID); err != nil {
+		return false, err
+	}
+	return false, nil
+}
+
+// unlockProductSchedules libera la reserva de lockProductSchedules
+func unlockProductSchedules(ctx context.Context, productID primitive.ObjectID) {
+	_, err := database.Mg.Db.Collection("Products").UpdateOne(ctx,
+		bson.M{"_id": productID},
+		bson.M{"$unset": bson.M{"schedules_locked_until": ""}},
+	)
+	if err != nil {
+		log.Println("price schedules:", err)
+	}
+}
+
+// CreatePriceSchedule programa un precio en la moneda base entre starts_at y ends_at.
+// Sin ends_at el precio se queda. No puede coincidir con otro precio programado.
+func CreatePriceSchedule(c *fiber.Ctx) error {
+	productID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.Error{Message: "Invalid ID", StatusCode: 400})
+	}
+
+	var body struct {
+		Price    models.Money `json:"price"`
+		StartsAt *time.Time   `json:"starts_at"`
+		EndsAt   *time.Time   `json:"ends_at"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.Error{Message: "Invalid request body", StatusCode: 400})
+	}
+
+	now := time.Now()
+	errs := checkBasePrice(&body.Price, "price")
+	if body.StartsAt == nil {
+		errs = append(errs, models.FieldError{Field: "starts_at", Code: "required", Message: "starts_at is required"})
+	}
+	if body.EndsAt != nil {
+		if body.StartsAt != nil && !body.EndsAt.After(*body.StartsAt) {
+			errs = append(errs, models.FieldError{Field: "ends_at", Code: "invalid", Message: "ends_at must be after starts_at"})
+		} else if !body.EndsAt.After(now) {
+			errs = append(errs, models.FieldError{Field: "ends_at", Code: "invalid", Message: "ends_at must be in the future"})
+		}
+	}
+	if len(errs) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ValidationError{Message: "Invalid price schedule", StatusCode: 400, Errors: errs})
+	}
+
+	locked, err := lockProductSchedules(c.Context(), productID)
+	if err != nil {
+		return productNotFound(c, err)
+	}
+	if !locked {
+		return c.Status(fiber.StatusConflict).JSON(models.Error{Message: "Another price schedule is being created for this product", StatusCode: 409})
+	}
+	defer unlockProductSchedules(c.Context(), productID)
+
+	actorType, actorID := currentActor(c)
+	schedule := models.ScheduledPrice{
+		ProductID: productID,
+		Price:     body.Price,
+		StartsAt:  *body.StartsAt,
+		EndsAt:    body.EndsAt,
+		Status:    models.ScheduleStatusPending,
+		ActorID:   actorID,
+		ActorType: actorType,
+		CreatedAt: now,
+	}
+
+	collection := database.Mg.Db.Collection("price_schedules")
+	cursor, err := collection.Find(c.Context(), bson.M{
+		"product_id": productID,
+		"status":     bson.M{"$in": bson.A{models.ScheduleStatusPending, models.ScheduleStatusActive}},
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.Error{Message: "Internal Server Error", StatusCode: 500})
+	}
+	var existing []models.ScheduledPrice
+	if err := cursor.All(c.Context(), &existing); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.Error{Message: "Internal Server Error", StatusCode: 500})
+	}
+	for i := range existing {
+		if schedulesOverlap(&schedule, &existing[i]) {
+			return c.Status(fiber.StatusConflict).JSON(models.Error{Message: "It overlaps with price schedule " + existing[i].ID.Hex(), StatusCode: 409})
+		}
+	}
+
+	res, err := collection.InsertOne(c.Context(), schedule)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.Error{Message: "Internal Server Error", StatusCode: 500})
+	}
+	schedule.ID = res.InsertedID.(primitive.ObjectID)
+
+	return c.Status(fiber.StatusCreated).JSON(schedule)
+}
+
+// CancelPriceSchedule cancela un precio programado. Si ya se está aplicando termina
+// ahora y el producto vuelve al precio de antes.
+func CancelPriceSchedule(c *fiber.Ctx) error {
+	productID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.Error{Message: "Invalid ID", StatusCode: 400})
+	}
+	scheduleID, err := primitive.ObjectIDFromHex(c.Params("scheduleId"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.Error{Message: "Invalid schedule ID", StatusCode: 400})
+	}
+
+	collection := database.Mg.Db.Collection("price_schedules")
+	filter := bson.M{"_id": scheduleID, "product_id": productID}
+	now := time.Now()
+
+	var schedule models.ScheduledPrice
+	err = collection.FindOneAndUpdate(c.Context(),
+		bson.M{"_id": scheduleID, "product_id": productID, "status": models.ScheduleStatusPending},
+		bson.M{"$set": bson.M{"status": models.ScheduleStatusCancelled, "ended_at": now}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&schedule)
+	if err == nil {
+		return c.JSON(schedule)
+	}
+	if err != mongo.ErrNoDocuments {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.Error{Message: "Internal Server Error", StatusCode: 500})
+	}
+
+	err = collection.FindOneAndUpdate(c.Context(),
+		bson.M{"_id": scheduleID, "product_id": productID, "status": models.ScheduleStatusActive},
+		bson.M{"$set": bson.M{"status": models.ScheduleStatusCancelled, "ended_at": now}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&schedule)
+	if err == nil {
+		actorType, actorID := currentActor(c)
+		if err := revertScheduledPrice(c.Context(), &schedule, models.PriceChange{ActorType: actorType, ActorID: actorID}); err != nil {
+			restoreSchedule(c.Context(), schedule.ID, models.ScheduleStatusCancelled, models.ScheduleStatusActive, "ended_at")
+			return c.Status(fiber.StatusInternalServerError).JSON(models.Error{Message: "Internal Server Error", StatusCode: 500})
+		}
+		return c.JSON(schedule)
+	}
+	if err != mongo.ErrNoDocuments {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.Error{Message: "Internal Server Error", StatusCode: 500})
+	}
+
+	count, err := collection.CountDocuments(c.Context(), filter)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.Error{Message: "Internal Server Error", StatusCode: 500})
+	}
+	if count > 0 {
+		return c.Status(fiber.StatusConflict).JSON(models.Error{Message: "Price schedule has already finished", StatusCode: 409})
+	}
+	return c.Status(fiber.StatusNotFound).JSON(models.Error{Message: "Not Found", StatusCode: 404})
+}
@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"context"
+	"main/database"
+	"main/models"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestSchedulesOverlap(t *testing.T) {
+	base := time.Date(2024, 11, 29, 0, 0, 0, 0, time.UTC)
+	at := func(hours int) time.Time { return base.Add(time.Duration(hours) * time.Hour) }
+	window := func(from, to int) *models.ScheduledPrice {
+		end := at(to)
+		return &models.ScheduledPrice{StartsAt: at(from), EndsAt: &end}
+	}
+	open := func(from int) *models.ScheduledPrice {
+		return &models.ScheduledPrice{StartsAt: at(from)}
+	}
+
+	tests := []struct {
+		name string
+		a, b *models.ScheduledPrice
+		want bool
+	}{
+		{"same window", window(0, 24), window(0, 24), true},
+		{"partial", window(0, 24), window(12, 36), true},
+		{"contained", window(0, 48), window(12, 24), true},
+		{"consecutive", window(0, 24), window(24, 48), false},
+		{"disjoint", window(0, 24), window(36, 48), false},
+		{"open at same start", open(0), open(0), true},
+		{"open at different start", open(0), open(1), false},
+		{"open inside window", open(12), window(0, 24), true},
+		{"open at window start", open(0), window(0, 24), true},
+		{"open at window end", open(24), window(0, 24), false},
+		{"open before window", open(-1), window(0, 24), false},
+		{"open after window", open(36), window(0, 24), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// La relación es simétrica
+			if got := schedulesOverlap(tt.a, tt.b); got != tt.want {
+				t.Errorf("schedulesOverlap(a, b) = %v, want %v", got, tt.want)
+			}
+			if got := schedulesOverlap(tt.b, tt.a); got != tt.want {
+				t.Errorf("schedulesOverlap(b, a) = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// scheduleUpdates devuelve los update enviados a price_schedules
+func scheduleUpdates(mt *mtest.T) []bson.Raw {
+	updates := make([]bson.Raw, 0)
+	for _, event := range mt.GetAllStartedEvents() {
+		if event.CommandName != "update" || event.Command.Lookup("update").StringValue() != "price_schedules" {
+			continue
+		}
+		values, _ := event.Command.Lookup("updates").Array().Values()
+		updates = append(updates, values[0].Document())
+	}
+	return updates
+}
+
+func TestApplyScheduledPricesFailure(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	now := time.Now()
+	end := now.Add(time.Hour)
+	productID := primitive.NewObjectID()
+	schedule := bson.D{
+		{Key: "_id", Value: primitive.NewObjectID()},
+		{Key: "product_id", Value: productID},
+		{Key: "price", Value: bson.D{{Key: "amount", Value: int64(799)}, {Key: "currency", Value: "EUR"}}},
+		{Key: "starts_at", Value: now.Add(-time.Minute)},
+		{Key: "ends_at", Value: end},
+		{Key: "status", Value: models.ScheduleStatusPending},
+	}
+	noDocument := mtest.CreateSuccessResponse(bson.E{Key: "value", Value: nil})
+	updated := mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1})
+	writeFails := mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 1, Message: "write failed"})
+
+	mt.Run("apply", func(mt *mtest.T) {
+		database.Mg.Db = mt.DB
+		mt.AddMockResponses(
+			updated,
+			noDocument,
+			mtest.CreateCursorResponse(0, mt.DB.Name()+".price_schedules", mtest.FirstBatch, schedule),
+			mtest.CreateCursorResponse(0, mt.DB.Name()+".Products", mtest.FirstBatch, bson.D{
+				{Key: "_id", Value: productID},
+				{Key: "price", Value: bson.D{{Key: "amount", Value: int64(999)}, {Key: "currency", Value: "EUR"}}},
+			}),
+			updated,
+			writeFails,
+			updated,
+		)
+		if err := ApplyScheduledPrices(context.Background(), now); err == nil {
+			mt.Fatal("ApplyScheduledPrices succeeded with the price write failing")
+		}
+
+		updates := scheduleUpdates(mt)
+		if len(updates) != 3 {
+			mt.Fatalf("%d updates to price_schedules, want 3", len(updates))
+		}
+		// El programado se reclama junto con el precio de antes
+		claim := updates[1].Lookup("u", "$set").Document()
+		if claim.Lookup("status").StringValue() != models.ScheduleStatusActive || claim.Lookup("previous_price", "amount").AsInt64() != 999 {
+			mt.Errorf("claim = %v, want active with previous_price 999", claim)
+		}
+		// y vuelve a pendiente para intentarlo otra vez
+		restore := updates[2]
+		if restore.Lookup("q", "status").StringValue() != models.ScheduleStatusActive ||
+			restore.Lookup("u", "$set", "status").StringValue() != models.ScheduleStatusPending {
+			mt.Errorf("restore = %v, want active back to pending", restore)
+		}
+		if _, err := restore.LookupErr("u", "$unset", "previous_price"); err != nil {
+			mt.Errorf("restore = %v, want previous_price removed", restore)
+		}
+	})
+
+	mt.Run("revert", func(mt *mtest.T) {
+		database.Mg.Db = mt.DB
+		active := append(bson.D{}, schedule...)
+		active[5] = bson.E{Key: "status", Value: models.ScheduleStatusEnded}
+		active = append(active, bson.E{Key: "previous_price", Value: bson.D{{Key: "amount", Value: int64(999)}, {Key: "currency", Value: "EUR"}}})
+		mt.AddMockResponses(
+			updated,
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: active}),
+			writeFails,
+			updated,
+		)
+		if err := ApplyScheduledPrices(context.Background(), end.Add(time.Minute)); err == nil {
+			mt.Fatal("ApplyScheduledPrices succeeded with the price write failing")
+		}
+
+		// El programado vuelve a activo para retirarlo en la siguiente vuelta
+		updates := scheduleUpdates(mt)
+		if len(updates) != 2 {
+			mt.Fatalf("%d updates to price_schedules, want 2", len(updates))
+		}
+		restore := updates[1]
+		if restore.Lookup("q", "status").StringValue() != models.ScheduleStatusEnded ||
+			restore.Lookup("u", "$set", "status").StringValue() != models.ScheduleStatusActive {
+			mt.Errorf("restore = %v, want ended back to active", restore)
+		}
+	})
+}
+
+func TestCreatePriceScheduleLock(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	app := fiber.New()
+	app.Post("/api/products/:id/price-schedules", CreatePriceSchedule)
+	productID := primitive.NewObjectID()
+	create := func(mt *mtest.T) int {
+		start := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+		body := `{"price":{"amount":799,"currency":"EUR"},"starts_at":"` + start + `"}`
+		req := httptest.NewRequest("POST", "/api/products/"+productID.Hex()+"/price-schedules", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		res, err := app.Test(req)
+		if err != nil {
+			mt.Fatal(err)
+		}
+		return res.StatusCode
+	}
+	product := func(mt *mtest.T) bson.D {
+		return mtest.CreateCursorResponse(0, mt.DB.Name()+".Products", mtest.FirstBatch, bson.D{{Key: "_id", Value: productID}})
+	}
+	commands := func(mt *mtest.T) []string {
+		names := make([]string, 0)
+		for _, event := range mt.GetAllStartedEvents() {
+			names = append(names, event.CommandName+" "+event.Command.Lookup(event.CommandName).StringValue())
+		}
+		return names
+	}
+
+	mt.Run("locked", func(mt *mtest.T) {
+		database.Mg.Db = mt.DB
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 0}), product(mt))
+		if status := create(mt); status != fiber.StatusConflict {
+			mt.Errorf("status = %d, want 409", status)
+		}
+		if got := strings.Join(commands(mt), ", "); got != "update Products, find Products" {
+			mt.Errorf("commands = %s", got)
+		}
+	})
+
+	mt.Run("not found", func(mt *mtest.T) {
+		database.Mg.Db = mt.DB
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 0}),
+			mtest.CreateCursorResponse(0, mt.DB.Name()+".Products", mtest.FirstBatch),
+		)
+		if status := create(mt); status != fiber.StatusNotFound {
+			mt.Errorf("status = %d, want 404", status)
+		}
+	})
+
+	mt.Run("created", func(mt *mtest.T) {
+		database.Mg.Db = mt.DB
+		updated := mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1})
+		mt.AddMockResponses(
+			updated,
+			mtest.CreateCursorResponse(0, mt.DB.Name()+".price_schedules", mtest.FirstBatch),
+			mtest.CreateSuccessResponse(),
+			updated,
+		)
+		if status := create(mt); status != fiber.StatusCreated {
+			mt.Fatalf("status = %d, want 201", status)
+		}
+		// Los solapes se comprueban y el programado se crea con el producto reservado
+		want := "update Products, find price_schedules, insert price_schedules, update Products"
+		if got := strings.Join(commands(mt), ", "); got != want {
+			mt.Errorf("commands = %s, want %s", got, want)
+		}
+		events := mt.GetAllStartedEvents()
+		lock, _ := events[0].Command.Lookup("updates").Array().Values()
+		unlock, _ := events[3].Command.Lookup("updates").Array().Values()
+		if _, err := lock[0].Document().LookupErr("u", "$set", "schedules_locked_until"); err != nil {
+			mt.Errorf("lock = %v", lock[0])
+		}
+		if _, err := unlock[0].Document().LookupErr("u", "$unset", "schedules_locked_until"); err != nil {
+			mt.Errorf("unlock = %v", unlock[0])
+		}
+	})
+}
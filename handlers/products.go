@@ -1,74 +1,217 @@
 package handlers
 
 import (
-	"fmt"
+	"context"
+	"log"
 	"main/database"
 	"main/models"
+	"main/search"
+	"main/utils"
 	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/golang-jwt/jwt/v4"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-func isTokenValid(token string) bool {
+// Campos por los que se pueden ordenar los productos
+var productListOptions = listOptions{
+	sortable: map[string]string{
+		"name":     "name",
+		"price":    "price.amount",
+		"category": "category",
+	},
+}
 
-	_, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+// Campos en los que se busca el texto de search
+var productSearchFields = []string{"name", "category", "description"}
+
+// productSearchFilter devuelve el filtro para buscar searchText según el modo match:
+// text usa el índice de texto, prefix busca palabras que empiezan por cada término
+// y contains busca el texto literal. En prefix y contains el texto se escapa.
+func productSearchFilter(searchText string, match string) (bson.M, bool) {
+	switch match {
+	case "", "text":
+		return bson.M{"$text": bson.M{"$search": searchText}}, true
+	case "prefix":
+		and := bson.A{}
+		for _, term := range utils.SearchTerms(searchText) {
+			and = append(and, anyFieldMatches(productSearchFields, utils.PrefixPattern(term)))
 		}
-		mySigningKey := []byte("my_secret_key")
-		return mySigningKey, nil
-	})
+		if len(and) == 0 {
+			return bson.M{}, true
+		}
+		return bson.M{"$and": and}, true
+	case "contains":
+		return anyFieldMatches(productSearchFields, utils.ContainsPattern(searchText)), true
+	}
+	return nil, false
+}
 
-	return err == nil
+// anyFieldMatches devuelve un filtro que se cumple si algún campo cumple el patrón
+func anyFieldMatches(fields []string, pattern string) bson.M {
+	or := bson.A{}
+	for _, field := range fields {
+		or = append(or, bson.M{field: primitive.Regex{Pattern: pattern, Options: "i"}})
+	}
+	return bson.M{"$or": or}
+}
+
+// reindexProduct actualiza el producto en el índice de búsqueda con lo que hay en la BD
+func reindexProduct(ctx context.Context, id string) {
+	if err := search.ReindexProduct(ctx, id); err != nil {
+		log.Println("search index:", err)
+	}
 }
 
+// GetAllProducts lista los productos con paginación (page/limit o cursor), orden
+// (sort=price,-name) y filtros por category, min_price, max_price y show. Con search
+// devuelve la relevancia y un fragmento con las coincidencias de cada producto.
 func GetAllProducts(c *fiber.Ctx) error {
-	token := c.Get("Authorization")
-	if token == "" || len(token) < 7 || token[:7] != "Bearer " {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"message": "Invalid authorization header",
-		})
+	query, errs := parseListQuery(c, productListOptions)
+
+	searchText := strings.TrimSpace(c.Query("search"))
+	if searchText != "" {
+		filter, ok := productSearchFilter(searchText, c.Query("match"))
+		if !ok {
+			errs = append(errs, models.FieldError{Field: "match", Code: "invalid", Message: "Match must be text, prefix or contains"})
+		}
+		for key, value := range filter {
+			query.Filter[key] = value
+		}
+		query.TextScore = filter["$text"] != nil
+	}
+	if category := c.Query("category"); category != "" {
+		condition, err := categoryCondition(c.Context(), category)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(models.Error{Message: "Internal Server Error", StatusCode: 500})
+		}
+		query.Filter["category_id"] = condition
+	}
+	price := bson.M{}
+	if min, ok := parseAmountFilter(c, "min_price", &errs); ok {
+		price["$gte"] = min
+	}
+	if max, ok := parseAmountFilter(c, "max_price", &errs); ok {
+		price["$lte"] = max
+	}
+	if len(price) > 0 {
+		query.Filter["price.amount"] = price
 	}
-	if !isTokenValid(strings.Split(token, " ")[1]) {
-		e := models.Error{Message: "Unauthorized", StatusCode: 401}
-		return c.Status(401).JSON(e)
+	if show, ok := parseBoolFilter(c, "show", &errs); ok {
+		query.Filter["show"] = show
+	}
+	if len(errs) > 0 {
+		return invalidQueryError(c, errs)
 	}
-	query := bson.D{{}}
 
-	if len(c.Query("search")) > 0 {
-		query = bson.D{{Key: "name", Value: primitive.Regex{Pattern: c.Query("search"), Options: "i"}}}
+	if searchText != "" {
+		return searchProducts(c, query, searchText)
 	}
 
-	cursor, err := database.Mg.Db.Collection("Products").Find(c.Context(), query)
+	products := make([]models.Product, 0)
+	page, err := query.Find(c.Context(), database.Mg.Db.Collection("Products"), nil, &products)
 	if err != nil {
-		//return c.Status(500).SendString(err.Error())
-		e := models.Error{Message: err.Error(), StatusCode: 500}
-		return c.JSON(e)
+		return findErrorResponse(c, err)
 	}
 
-	var products []models.Product = make([]models.Product, 0)
+	response := models.ProductResponse{
+		Items:      products,
+		Total:      page.Total,
+		Page:       page.Page,
+		Limit:      page.Limit,
+		NextCursor: page.NextCursor,
+	}
 
-	if err := cursor.All(c.Context(), &products); err != nil {
-		//return c.Status(500).SendString(err.Error())
-		e := models.Error{Message: err.Error(), StatusCode: 500}
-		return c.JSON(e)
+	return c.JSON(response)
+}
+
+// searchProducts ejecuta la búsqueda y añade a cada producto el fragmento con las coincidencias
+func searchProducts(c *fiber.Ctx, query *database.ListQuery, searchText string) error {
+	hits := make([]models.ProductHit, 0)
+	page, err := query.Find(c.Context(), database.Mg.Db.Collection("Products"), nil, &hits)
+	if err != nil {
+		return findErrorResponse(c, err)
 	}
 
-	response := models.ProductResponse{Items: products, Total: len(products)}
+	terms := utils.SearchTerms(searchText)
+	for i := range hits {
+		hits[i].Snippet = utils.Highlight(hits[i].Description, terms)
+		if hits[i].Snippet == "" {
+			hits[i].Snippet = utils.Highlight(hits[i].Name, terms)
+		}
+	}
 
-	return c.JSON(response)
+	return c.JSON(models.ProductSearchResponse{
+		Items:      hits,
+		Total:      page.Total,
+		Page:       page.Page,
+		Limit:      page.Limit,
+		NextCursor: page.NextCursor,
+	})
 }
 
-func NewProduct(c *fiber.Ctx) error {
-	token := c.Get("Authorization")
-	if !isTokenValid(strings.Split(token, " ")[1]) {
-		e := models.Error{Message: "Unauthorized", StatusCode: 401}
-		return c.Status(401).JSON(e)
+// SearchProducts busca en el índice de búsqueda de productos con tolerancia a erratas
+// y devuelve los recuentos por categoría y por rango de precio
+func SearchProducts(c *fiber.Ctx) error {
+	query, errs := parseListQuery(c, listOptions{})
+	if query.Cursor != "" {
+		errs = append(errs, models.FieldError{Field: "cursor", Code: "invalid", Message: "Search results use page, not cursor"})
+	}
+	if len(query.Sort) > 0 {
+		errs = append(errs, models.FieldError{Field: "sort", Code: "invalid", Message: "Search results are sorted by relevance"})
+	}
+
+	req := search.Request{
+		Query:  c.Query("q"),
+		Offset: (query.Page - 1) * query.Limit,
+		Limit:  query.Limit,
+	}
+	if min, ok := parseAmountFilter(c, "min_price", &errs); ok {
+		req.MinPrice = &min
 	}
+	if max, ok := parseAmountFilter(c, "max_price", &errs); ok {
+		req.MaxPrice = &max
+	}
+	if show, ok := parseBoolFilter(c, "show", &errs); ok {
+		req.Show = &show
+	}
+	if len(errs) > 0 {
+		return invalidQueryError(c, errs)
+	}
+	// La categoría se filtra como en GetAllProducts: por ID o slug y con sus subcategorías
+	if category := c.Query("category"); category != "" {
+		ids, err := categoryIDs(c.Context(), category)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(models.Error{Message: "Internal Server Error", StatusCode: 500})
+		}
+		req.Categories = ids
+	}
+
+	result := search.Products.Search(req)
+
+	hits := make([]models.ProductHit, 0, len(result.Hits))
+	for _, hit := range result.Hits {
+		snippet := utils.Highlight(hit.Product.Description, hit.Terms)
+		if snippet == "" {
+			snippet = utils.Highlight(hit.Product.Name, hit.Terms)
+		}
+		hits = append(hits, models.ProductHit{Product: hit.Product, Score: hit.Score, Snippet: snippet})
+	}
+
+	return c.JSON(fiber.Map{
+		"total":  result.Total,
+		"page":   query.Page,
+		"limit":  query.Limit,
+		"items":  hits,
+		"facets": result.Facets,
+	})
+}
+
+func NewProduct(c *fiber.Ctx) error {
 	collection := database.Mg.Db.Collection("Products")
 
 	product := new(models.Product)
@@ -81,6 +224,40 @@ func NewProduct(c *fiber.Ctx) error {
 
 	product.ID = ""
 
+	if err := assignProductCategory(c.Context(), product); err != nil {
+		if err == errUnknownCategory {
+			e := models.Error{Message: "Unknown category", StatusCode: 400}
+			return c.Status(400).JSON(e)
+		}
+		e := models.Error{Message: err.Error(), StatusCode: 500}
+		return c.JSON(e)
+	}
+
+	if product.PublishAt != nil && product.UnpublishAt != nil && !product.UnpublishAt.After(*product.PublishAt) {
+		e := models.Error{Message: "unpublish_at must be after publish_at", StatusCode: 400}
+		return c.Status(400).JSON(e)
+	}
+
+	if errs := checkProductPrices(product); len(errs) > 0 {
+		e := models.ValidationError{Message: "Invalid price", StatusCode: 400, Errors: errs}
+		return c.Status(400).JSON(e)
+	}
+
+	// Slug para el catálogo, del enviado o del nombre
+	slugSource := product.Slug
+	if slugSource == "" {
+		slugSource = product.Name
+	}
+	slug, err := utils.UniqueSlug(c.Context(), collection, slugSource, nil)
+	if err != nil {
+		e := models.Error{Message: err.Error(), StatusCode: 500}
+		return c.JSON(e)
+	}
+	product.Slug = slug
+
+	// El stock empieza en cero y solo cambia con movimientos de stock
+	product.Stock = 0
+
 	insertionResult, err := collection.InsertOne(c.Context(), product)
 	if err != nil {
 		//return c.Status(500).SendString(err.Error())
@@ -94,15 +271,19 @@ func NewProduct(c *fiber.Ctx) error {
 	createdProduct := &models.Product{}
 	createdRecord.Decode(createdProduct)
 
+	actorType, actorID := currentActor(c)
+	change := models.PriceChange{Source: models.PriceSourceProductCreate, ActorType: actorType, ActorID: actorID}
+	if err := recordPriceChanges(c.Context(), insertionResult.InsertedID.(primitive.ObjectID), nil, createdProduct, change); err != nil {
+		log.Println("price history:", err)
+	}
+
+	// Mantener el índice de búsqueda al día
+	reindexProduct(c.Context(), createdProduct.ID)
+
 	return c.Status(201).JSON(createdProduct)
 }
 
 func EditProduct(c *fiber.Ctx) error {
-	token := c.Get("Authorization")
-	if !isTokenValid(strings.Split(token, " ")[1]) {
-		e := models.Error{Message: "Unauthorized", StatusCode: 401}
-		return c.Status(401).JSON(e)
-	}
 	idParam := c.Params("id")
 	productID, err := primitive.ObjectIDFromHex(idParam)
 
@@ -120,19 +301,50 @@ func EditProduct(c *fiber.Ctx) error {
 		return c.JSON(e)
 	}
 
+	if err := assignProductCategory(c.Context(), product); err != nil {
+		if err == errUnknownCategory {
+			e := models.Error{Message: "Unknown category", StatusCode: 400}
+			return c.Status(400).JSON(e)
+		}
+		e := models.Error{Message: err.Error(), StatusCode: 500}
+		return c.JSON(e)
+	}
+
+	if errs := checkProductPrices(product); len(errs) > 0 {
+		e := models.ValidationError{Message: "Invalid price", StatusCode: 400, Errors: errs}
+		return c.Status(400).JSON(e)
+	}
+
+	fields := bson.D{
+		{Key: "name", Value: product.Name},
+		{Key: "category_id", Value: product.CategoryID},
+		{Key: "category", Value: product.Category},
+		{Key: "image", Value: product.Image},
+		{Key: "description", Value: product.Description},
+		{Key: "price", Value: product.Price},
+		{Key: "prices", Value: product.Prices},
+		{Key: "show", Value: product.Show},
+	}
+
+	// El slug solo cambia si se envía, para no romper los enlaces del catálogo
+	if product.Slug != "" {
+		slug, err := utils.UniqueSlug(c.Context(), database.Mg.Db.Collection("Products"), product.Slug, productID)
+		if err != nil {
+			e := models.Error{Message: err.Error(), StatusCode: 500}
+			return c.JSON(e)
+		}
+		fields = append(fields, bson.E{Key: "slug", Value: slug})
+	}
+
 	query := bson.D{{Key: "_id", Value: productID}}
 	update := bson.D{
-		{Key: "$set",
-			Value: bson.D{
-				{Key: "name", Value: product.Name},
-				{Key: "category", Value: product.Category},
-				{Key: "image", Value: product.Image},
-				{Key: "description", Value: product.Description},
-				{Key: "price", Value: product.Price},
-			},
-		},
-	}
-	err = database.Mg.Db.Collection("Products").FindOneAndUpdate(c.Context(), query, update).Err()
+		{Key: "$set", Value: fields},
+	}
+	// Se lee el producto como estaba para guardar en el historial los precios anteriores
+	var before models.Product
+	err = database.Mg.Db.Collection("Products").FindOneAndUpdate(c.Context(), query, update,
+		options.FindOneAndUpdate().SetReturnDocument(options.Before),
+	).Decode(&before)
 
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
@@ -145,16 +357,90 @@ func EditProduct(c *fiber.Ctx) error {
 		return c.JSON(e)
 	}
 
-	product.ID = idParam
-	return c.Status(200).JSON(product)
+	var updated models.Product
+	if err := database.Mg.Db.Collection("Products").FindOne(c.Context(), query).Decode(&updated); err != nil {
+		e := models.Error{Message: err.Error(), StatusCode: 500}
+		return c.JSON(e)
+	}
+
+	actorType, actorID := currentActor(c)
+	change := models.PriceChange{Source: models.PriceSourceManual, ActorType: actorType, ActorID: actorID}
+	if err := recordPriceChanges(c.Context(), productID, &before, &updated, change); err != nil {
+		log.Println("price history:", err)
+	}
+
+	// Mantener el índice de búsqueda al día
+	reindexProduct(c.Context(), updated.ID)
+
+	return c.Status(200).JSON(updated)
 }
 
-func DeleteProduct(c *fiber.Ctx) error {
-	token := c.Get("Authorization")
-	if !isTokenValid(strings.Split(token, " ")[1]) {
-		e := models.Error{Message: "Unauthorized", StatusCode: 401}
-		return c.Status(401).JSON(e)
+// SetProductVisibility muestra u oculta un producto en el catálogo y programa las
+// fechas de publicación y retirada. Una fecha a null quita la programación.
+func SetProductVisibility(c *fiber.Ctx) error {
+	productID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		e := models.Error{Message: err.Error(), StatusCode: 400}
+		return c.Status(400).JSON(e)
+	}
+
+	var body struct {
+		Show        *bool      `json:"show"`
+		PublishAt   *time.Time `json:"publish_at"`
+		UnpublishAt *time.Time `json:"unpublish_at"`
+	}
+	if err := c.BodyParser(&body); err != nil || body.Show == nil {
+		e := models.Error{Message: "Invalid request body, show is required", StatusCode: 400}
+		return c.Status(400).JSON(e)
 	}
+	if body.PublishAt != nil && body.UnpublishAt != nil && !body.UnpublishAt.After(*body.PublishAt) {
+		e := models.Error{Message: "unpublish_at must be after publish_at", StatusCode: 400}
+		return c.Status(400).JSON(e)
+	}
+
+	set := bson.M{"show": *body.Show}
+	unset := bson.M{}
+	if body.PublishAt != nil {
+		set["publish_at"] = *body.PublishAt
+	} else {
+		unset["publish_at"] = ""
+	}
+	if body.UnpublishAt != nil {
+		set["unpublish_at"] = *body.UnpublishAt
+	} else {
+		unset["unpublish_at"] = ""
+	}
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	var updated models.Product
+	err = database.Mg.Db.Collection("Products").FindOneAndUpdate(c.Context(), bson.M{"_id": productID}, update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&updated)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			e := models.Error{Message: "Not Found", StatusCode: 404}
+			return c.Status(404).JSON(e)
+		}
+		e := models.Error{Message: err.Error(), StatusCode: 500}
+		return c.Status(500).JSON(e)
+	}
+
+	// Mantener el índice de búsqueda al día
+	reindexProduct(c.Context(), updated.ID)
+
+	return c.JSON(fiber.Map{
+		"id":           updated.ID,
+		"show":         updated.Show,
+		"publish_at":   updated.PublishAt,
+		"unpublish_at": updated.UnpublishAt,
+		"visible":      updated.IsVisible(time.Now()),
+	})
+}
+
+func DeleteProduct(c *fiber.Ctx) error {
 	noteID, err := primitive.ObjectIDFromHex(
 		c.Params("id"),
 	)
@@ -181,6 +467,31 @@ func DeleteProduct(c *fiber.Ctx) error {
 		return c.JSON(e)
 	}
 
+	// Las variantes se borran con el producto
+	if _, err := database.Mg.Db.Collection("variants").DeleteMany(c.Context(), bson.M{"product_id": noteID}); err != nil {
+		e := models.Error{Message: err.Error(), StatusCode: 500}
+		return c.JSON(e)
+	}
+
+	// También sus niveles de stock; el registro de movimientos se queda
+	if _, err := database.Mg.Db.Collection("stock_levels").DeleteMany(c.Context(), bson.M{"product_id": noteID}); err != nil {
+		e := models.Error{Message: err.Error(), StatusCode: 500}
+		return c.JSON(e)
+	}
+
+	// Y se cancelan sus precios programados que no han terminado
+	_, err = database.Mg.Db.Collection("price_schedules").UpdateMany(c.Context(),
+		bson.M{"product_id": noteID, "status": bson.M{"$in": bson.A{models.ScheduleStatusPending, models.ScheduleStatusActive}}},
+		bson.M{"$set": bson.M{"status": models.ScheduleStatusCancelled, "ended_at": time.Now()}},
+	)
+	if err != nil {
+		e := models.Error{Message: err.Error(), StatusCode: 500}
+		return c.JSON(e)
+	}
+
+	// Mantener el índice de búsqueda al día
+	search.Products.Delete(c.Params("id"))
+
 	return c.JSON(query[0].Value)
 	//return c.SendStatus(204)
 }
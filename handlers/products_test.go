@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"main/database"
+	"main/models"
+	"main/search"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestDeleteProductCleanup(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	app := fiber.New()
+	app.Delete("/api/products/:id", DeleteProduct)
+
+	mt.Run("delete", func(mt *mtest.T) {
+		database.Mg.Db = mt.DB
+		deleted := mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1})
+		mt.AddMockResponses(deleted, deleted, deleted, deleted)
+
+		id := primitive.NewObjectID()
+		res, err := app.Test(httptest.NewRequest("DELETE", "/api/products/"+id.Hex(), nil))
+		if err != nil {
+			mt.Fatal(err)
+		}
+		if res.StatusCode != fiber.StatusOK {
+			mt.Fatalf("status = %d", res.StatusCode)
+		}
+
+		// Se borran el producto, sus variantes y sus niveles de stock, pero no los movimientos
+		collections := map[string]bool{}
+		for _, event := range mt.GetAllStartedEvents() {
+			if event.CommandName == "delete" {
+				collections[event.Command.Lookup("delete").StringValue()] = true
+			}
+		}
+		for _, name := range []string{"Products", "variants", "stock_levels"} {
+			if !collections[name] {
+				mt.Errorf("%s not deleted, deleted %v", name, collections)
+			}
+		}
+		if collections["stock_movements"] {
+			mt.Error("stock movements deleted with the product")
+		}
+
+		// Los precios programados que siguen vivos se cancelan
+		var cancel bson.Raw
+		for _, event := range mt.GetAllStartedEvents() {
+			if event.CommandName == "update" && event.Command.Lookup("update").StringValue() == "price_schedules" {
+				updates, _ := event.Command.Lookup("updates").Array().Values()
+				cancel = updates[0].Document()
+			}
+		}
+		if cancel == nil {
+			mt.Fatal("price schedules not cancelled")
+		}
+		statuses, _ := cancel.Lookup("q", "status", "$in").Array().Values()
+		if cancel.Lookup("q", "product_id").ObjectID() != id || len(statuses) != 2 ||
+			cancel.Lookup("u", "$set", "status").StringValue() != models.ScheduleStatusCancelled || !cancel.Lookup("multi").Boolean() {
+			mt.Errorf("cancel = %v", cancel)
+		}
+	})
+}
+
+func TestSearchProductsCategory(t *testing.T) {
+	models.BaseCurrency = "EUR"
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	app := fiber.New()
+	app.Get("/api/products/search", SearchProducts)
+
+	ropa, camisetas, hogar := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+	previous := search.Products
+	defer func() { search.Products = previous }()
+	search.Products = search.NewIndex("")
+	product := func(id string, category primitive.ObjectID) search.Document {
+		return search.Document{Product: models.Product{ID: id, Name: "Producto " + id, CategoryID: &category, Price: models.Money{Amount: 100, Currency: "EUR"}}}
+	}
+	search.Products.Replace([]search.Document{product("1", ropa), product("2", camisetas), product("3", hogar)})
+
+	categories := func(mt *mtest.T) []bson.D {
+		ns := mt.DB.Name() + ".categories"
+		return []bson.D{
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, bson.D{{Key: "_id", Value: ropa}, {Key: "slug", Value: "ropa"}}),
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch,
+				bson.D{{Key: "_id", Value: ropa}, {Key: "slug", Value: "ropa"}},
+				bson.D{{Key: "_id", Value: camisetas}, {Key: "slug", Value: "camisetas"}, {Key: "parent_id", Value: ropa}},
+				bson.D{{Key: "_id", Value: hogar}, {Key: "slug", Value: "hogar"}},
+			),
+		}
+	}
+
+	tests := []struct {
+		name      string
+		category  string
+		responses func(mt *mtest.T) []bson.D
+		want      int
+	}{
+		// Como en /products y /catalog: por slug o ID y con las subcategorías
+		{"slug with subcategories", "ropa", categories, 2},
+		{"id", ropa.Hex(), categories, 2},
+		{"unknown", "juguetes", func(mt *mtest.T) []bson.D {
+			return []bson.D{mtest.CreateCursorResponse(0, mt.DB.Name()+".categories", mtest.FirstBatch)}
+		}, 0},
+		{"no filter", "", func(mt *mtest.T) []bson.D { return nil }, 3},
+	}
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			database.Mg.Db = mt.DB
+			mt.AddMockResponses(tt.responses(mt)...)
+			body := getJSON(mt.T, app, "/api/products/search?category="+tt.category)
+			if body["total"] != float64(tt.want) {
+				mt.Errorf("total = %v, want %d", body["total"], tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"fmt"
+	"main/database"
+	"main/models"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Tamaño de página por defecto y máximo de los listados
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+// listOptions define los campos por los que se puede ordenar un listado. sortable
+// relaciona el nombre del parámetro sort con el campo de la colección.
+type listOptions struct {
+	sortable    map[string]string
+	defaultSort string
+}
+
+// parseListQuery lee page, limit, cursor y sort de la query string. Los filtros de
+// cada recurso se añaden después en Filter.
+func parseListQuery(c *fiber.Ctx, opts listOptions) (*database.ListQuery, []models.FieldError) {
+	errs := make([]models.FieldError, 0)
+	query := &database.ListQuery{Filter: bson.M{}, Page: 1, Limit: defaultPageLimit, Cursor: c.Query("cursor")}
+
+	if value := c.Query("page"); value != "" {
+		page, err := strconv.Atoi(value)
+		if err != nil || page < 1 {
+			errs = append(errs, models.FieldError{Field: "page", Code: "invalid", Message: "Page must be a positive integer"})
+		} else {
+			query.Page = page
+		}
+		if query.Cursor != "" {
+			errs = append(errs, models.FieldError{Field: "page", Code: "conflict", Message: "Use either page or cursor"})
+		}
+	}
+
+	if value := c.Query("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			errs = append(errs, models.FieldError{Field: "limit", Code: "invalid", Message: fmt.Sprintf("Limit must be between 1 and %d", maxPageLimit)})
+		} else {
+			query.Limit = limit
+		}
+	}
+
+	sort := c.Query("sort", opts.defaultSort)
+	for _, name := range strings.Split(sort, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		desc := strings.HasPrefix(name, "-")
+		field, ok := opts.sortable[strings.TrimPrefix(name, "-")]
+		if !ok {
+			errs = append(errs, models.FieldError{Field: "sort", Code: "invalid", Message: fmt.Sprintf("Cannot sort by %q", strings.TrimPrefix(name, "-"))})
+			continue
+		}
+		query.Sort = append(query.Sort, database.SortField{Field: field, Desc: desc})
+	}
+
+	return query, errs
+}
+
+// parseAmountFilter lee un importe decimal opcional de la query string en la moneda
+// base y lo devuelve en unidades menores
+func parseAmountFilter(c *fiber.Ctx, name string, errs *[]models.FieldError) (int64, bool) {
+	value := c.Query(name)
+	if value == "" {
+		return 0, false
+	}
+	amount, err := models.ParseAmount(value, models.BaseCurrency)
+	if err != nil {
+		*errs = append(*errs, models.FieldError{Field: name, Code: "invalid", Message: fmt.Sprintf("%s must be an amount in %s", name, models.BaseCurrency)})
+		return 0, false
+	}
+	return amount, true
+}
+
+// parseBoolFilter lee un parámetro booleano opcional de la query string
+func parseBoolFilter(c *fiber.Ctx, name string, errs *[]models.FieldError) (bool, bool) {
+	value := c.Query(name)
+	if value == "" {
+		return false, false
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		*errs = append(*errs, models.FieldError{Field: name, Code: "invalid", Message: fmt.Sprintf("%s must be true or false", name)})
+		return false, false
+	}
+	return b, true
+}
+
+// invalidQueryError responde 400 con el detalle de los parámetros que no son válidos
+func invalidQueryError(c *fiber.Ctx, errs []models.FieldError) error {
+	return c.Status(fiber.StatusBadRequest).JSON(models.ValidationError{
+		Message:    "Invalid query parameters",
+		StatusCode: 400,
+		Errors:     errs,
+	})
+}
+
+// findErrorResponse responde al error de ListQuery.Find
+func findErrorResponse(c *fiber.Ctx, err error) error {
+	if err == database.ErrInvalidCursor {
+		return invalidQueryError(c, []models.FieldError{{Field: "cursor", Code: "invalid", Message: "Invalid cursor"}})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(models.Error{Message: "Internal Server Error", StatusCode: 500})
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"main/database"
+	"main/models"
+	"sort"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// GetRoles devuelve los roles con sus permisos
+func GetRoles(c *fiber.Ctx) error {
+	roles := make([]models.RoleResponse, 0, len(models.RolePermissions))
+	for name, permissions := range models.RolePermissions {
+		roles = append(roles, models.RoleResponse{Name: name, Permissions: permissions})
+	}
+	sort.Slice(roles, func(i, j int) bool { return roles[i].Name < roles[j].Name })
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"items": roles,
+		"total": len(roles),
+	})
+}
+
+// UpdateUserRole cambia el rol de un usuario
+func UpdateUserRole(c *fiber.Ctx) error {
+	id := c.Params("id")
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"statusCode": 400,
+			"message":    "Invalid ID",
+		})
+	}
+
+	var body struct {
+		Role string `json:"role"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"statusCode": 400,
+			"message":    "Invalid request body",
+		})
+	}
+	if !models.IsValidRole(body.Role) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"statusCode": 400,
+			"message":    "Invalid role",
+		})
+	}
+
+	filter := bson.M{"_id": objectId}
+	res, err := database.Mg.Db.Collection("users").UpdateOne(c.Context(), filter, bson.M{"$set": bson.M{"role": body.Role}})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"statusCode": 500,
+			"message":    "Internal Server Error",
+		})
+	}
+	if res.MatchedCount == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"statusCode": 404,
+			"message":    "User not found",
+		})
+	}
+
+	// El rol va dentro del token, así que se cierran las sesiones abiertas del usuario
+	if err := revokeUserSessions(c.Context(), objectId); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"statusCode": 500,
+			"message":    "Internal Server Error",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"statusCode": 200,
+		"message":    "Role updated successfully",
+		"id":         id,
+		"role":       body.Role,
+	})
+}
@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"context"
+	"main/database"
+	"main/middleware"
+	"main/models"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// createSession guarda una nueva sesión y devuelve su familia de tokens
+func createSession(c *fiber.Ctx, userID primitive.ObjectID) (string, error) {
+	id := primitive.NewObjectID()
+	now := time.Now()
+	session := models.Session{
+		ID:         id,
+		FamilyID:   id.Hex(),
+		UserID:     userID,
+		Device:     deviceFromUserAgent(c.Get(fiber.HeaderUserAgent)),
+		UserAgent:  c.Get(fiber.HeaderUserAgent),
+		IP:         c.IP(),
+		CreatedAt:  now,
+		LastUsedAt: now,
+	}
+	if _, err := database.Mg.Db.Collection("sessions").InsertOne(c.Context(), session); err != nil {
+		return "", err
+	}
+	return session.FamilyID, nil
+}
+
+// deviceFromUserAgent devuelve un nombre de dispositivo legible a partir del User-Agent
+func deviceFromUserAgent(userAgent string) string {
+	devices := []struct{ match, name string }{
+		{"iPhone", "iPhone"},
+		{"iPad", "iPad"},
+		{"Android", "Android"},
+		{"Windows", "Windows"},
+		{"Macintosh", "Mac"},
+		{"Linux", "Linux"},
+	}
+	for _, d := range devices {
+		if strings.Contains(userAgent, d.match) {
+			return d.name
+		}
+	}
+	return "Unknown"
+}
+
+func findSessions(ctx context.Context, userID primitive.ObjectID) ([]models.Session, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "last_used_at", Value: -1}})
+	cursor, err := database.Mg.Db.Collection("sessions").Find(ctx, bson.M{"user_id": userID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	sessions := make([]models.Session, 0)
+	if err := cursor.All(ctx, &sessions); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// revokeSession cierra una sesión del usuario. Devuelve mongo.ErrNoDocuments si no existe.
+func revokeSession(ctx context.Context, userID primitive.ObjectID, sessionID primitive.ObjectID) error {
+	var session models.Session
+	err := database.Mg.Db.Collection("sessions").FindOne(ctx, bson.M{"_id": sessionID, "user_id": userID}).Decode(&session)
+	if err != nil {
+		return err
+	}
+	return revokeTokenFamily(ctx, session.FamilyID)
+}
+
+// GetSessions lista las sesiones activas del usuario autenticado
+func GetSessions(c *fiber.Ctx) error {
+	userID, err := primitive.ObjectIDFromHex(middleware.CurrentUser(c).Subject)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"statusCode": 401,
+			"message":    "Invalid token",
+		})
+	}
+
+	sessions, err := findSessions(c.Context(), userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"statusCode": 500,
+			"message":    "Internal Server Error",
+		})
+	}
+
+	// Marcar la sesión desde la que se hace la petición
+	current, _ := c.Locals("session").(string)
+	for i := range sessions {
+		sessions[i].Current = sessions[i].FamilyID == current
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"items": sessions,
+		"total": len(sessions),
+	})
+}
+
+// DeleteSession cierra una sesión del usuario autenticado
+func DeleteSession(c *fiber.Ctx) error {
+	userID, err := primitive.ObjectIDFromHex(middleware.CurrentUser(c).Subject)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"statusCode": 401,
+			"message":    "Invalid token",
+		})
+	}
+	return deleteSession(c, userID)
+}
+
+// GetUserSessions lista las sesiones activas de cualquier usuario
+func GetUserSessions(c *fiber.Ctx) error {
+	userID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"statusCode": 400,
+			"message":    "Invalid ID",
+		})
+	}
+
+	sessions, err := findSessions(c.Context(), userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"statusCode": 500,
+			"message":    "Internal Server Error",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"items": sessions,
+		"total": len(sessions),
+	})
+}
+
+// DeleteUserSession cierra una sesión de cualquier usuario
+func DeleteUserSession(c *fiber.Ctx) error {
+	userID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"statusCode": 400,
+			"message":    "Invalid ID",
+		})
+	}
+	return deleteSession(c, userID)
+}
+
+func deleteSession(c *fiber.Ctx, userID primitive.ObjectID) error {
+	sessionID, err := primitive.ObjectIDFromHex(c.Params("sessionId"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"statusCode": 400,
+			"message":    "Invalid session ID",
+		})
+	}
+
+	if err := revokeSession(c.Context(), userID, sessionID); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"statusCode": 404,
+				"message":    "Session not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"statusCode": 500,
+			"message":    "Internal Server Error",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"statusCode": 200,
+		"message":    "Session revoked",
+		"id":         sessionID,
+	})
+}
@@ -0,0 +1,304 @@
+package handlers
+
+import (
+	"log"
+	"main/config"
+	"main/database"
+	"main/middleware"
+	"main/models"
+	"main/utils"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Número de códigos de recuperación que se generan al activar 2FA
+const recoveryCodesCount = 10
+
+// currentDBUser busca en la base de datos al usuario autenticado
+func currentDBUser(c *fiber.Ctx) (*models.Users, error) {
+	userID, err := primitive.ObjectIDFromHex(middleware.CurrentUser(c).Subject)
+	if err != nil {
+		return nil, err
+	}
+	var user models.Users
+	if err := database.Mg.Db.Collection("users").FindOne(c.Context(), bson.M{"_id": userID}).Decode(&user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// EnrollTwoFactor genera un secreto TOTP pendiente de confirmar y devuelve la URI otpauth
+func EnrollTwoFactor(c *fiber.Ctx) error {
+	user, err := currentDBUser(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"statusCode": 401,
+			"message":    "Invalid token",
+		})
+	}
+	if user.TOTPEnabled {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"statusCode": 400,
+			"message":    "Two-factor authentication is already enabled",
+		})
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"statusCode": 500,
+			"message":    "Internal Server Error",
+		})
+	}
+
+	_, err = database.Mg.Db.Collection("users").UpdateOne(c.Context(), bson.M{"_id": user.ID}, bson.M{
+		"$set": bson.M{"totp_pending_secret": secret},
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"statusCode": 500,
+			"message":    "Internal Server Error",
+		})
+	}
+
+	issuer := config.Config("APP_NAME")
+	if issuer == "" {
+		issuer = "golang-api"
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"statusCode":  200,
+		"message":     "Scan the URI with your authenticator app and confirm with a code",
+		"secret":      secret,
+		"otpauth_uri": utils.TOTPURI(issuer, user.Email, secret),
+	})
+}
+
+// ConfirmTwoFactor activa 2FA con un código del secreto pendiente y devuelve los códigos de recuperación
+func ConfirmTwoFactor(c *fiber.Ctx) error {
+	var body struct {
+		Code string `json:"code"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"statusCode": 400,
+			"message":    "Invalid request body",
+		})
+	}
+
+	user, err := currentDBUser(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"statusCode": 401,
+			"message":    "Invalid token",
+		})
+	}
+	if user.TOTPPendingSecret == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"statusCode": 400,
+			"message":    "Two-factor enrollment not started",
+		})
+	}
+
+	step, ok := utils.ValidateTOTP(user.TOTPPendingSecret, body.Code, time.Now())
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"statusCode": 400,
+			"message":    "Invalid code",
+		})
+	}
+
+	codes, err := utils.GenerateRecoveryCodes(recoveryCodesCount)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"statusCode": 500,
+			"message":    "Internal Server Error",
+		})
+	}
+	hashedCodes := make([]string, 0, len(codes))
+	for _, code := range codes {
+		hashedCodes = append(hashedCodes, utils.HashToken(code))
+	}
+
+	_, err = database.Mg.Db.Collection("users").UpdateOne(c.Context(), bson.M{"_id": user.ID}, bson.M{
+		"$set": bson.M{
+			"totp_enabled":   true,
+			"totp_secret":    user.TOTPPendingSecret,
+			"totp_last_step": step,
+			"recovery_codes": hashedCodes,
+		},
+		"$unset": bson.M{"totp_pending_secret": ""},
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"statusCode": 500,
+			"message":    "Internal Server Error",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"statusCode":     200,
+		"message":        "Two-factor authentication enabled",
+		"recovery_codes": codes,
+	})
+}
+
+// DisableTwoFactor desactiva 2FA con un código válido
+func DisableTwoFactor(c *fiber.Ctx) error {
+	var body struct {
+		Code string `json:"code"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"statusCode": 400,
+			"message":    "Invalid request body",
+		})
+	}
+
+	user, err := currentDBUser(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"statusCode": 401,
+			"message":    "Invalid token",
+		})
+	}
+	if !user.TOTPEnabled {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"statusCode": 400,
+			"message":    "Two-factor authentication is not enabled",
+		})
+	}
+
+	ok, err := checkSecondFactor(c, user, body.Code)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"statusCode": 500,
+			"message":    "Internal Server Error",
+		})
+	}
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"statusCode": 400,
+			"message":    "Invalid code",
+		})
+	}
+
+	_, err = database.Mg.Db.Collection("users").UpdateOne(c.Context(), bson.M{"_id": user.ID}, bson.M{
+		"$unset": bson.M{
+			"totp_enabled":        "",
+			"totp_secret":         "",
+			"totp_pending_secret": "",
+			"totp_last_step":      "",
+			"recovery_codes":      "",
+		},
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"statusCode": 500,
+			"message":    "Internal Server Error",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"statusCode": 200,
+		"message":    "Two-factor authentication disabled",
+	})
+}
+
+// VerifyTwoFactor cambia el challenge token de Login y un código TOTP o de recuperación por un JWT
+func VerifyTwoFactor(c *fiber.Ctx) error {
+	var body struct {
+		ChallengeToken string `json:"challenge_token"`
+		Code           string `json:"code"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"statusCode": 400,
+			"message":    "Invalid request body",
+		})
+	}
+
+	subject, err := utils.ParseChallengeToken(body.ChallengeToken)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"statusCode": 401,
+			"message":    "Invalid or expired challenge token",
+		})
+	}
+	userID, err := primitive.ObjectIDFromHex(subject)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"statusCode": 401,
+			"message":    "Invalid or expired challenge token",
+		})
+	}
+
+	var user models.Users
+	if err := database.Mg.Db.Collection("users").FindOne(c.Context(), bson.M{"_id": userID}).Decode(&user); err != nil || !user.TOTPEnabled {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"statusCode": 401,
+			"message":    "Invalid or expired challenge token",
+		})
+	}
+
+	// Los códigos fallidos cuentan como intentos de login de la cuenta
+	wait, err := checkLoginAllowed(c.Context(), models.RealmUsers, user.Email, c.IP())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"statusCode": 500,
+			"message":    "Internal Server Error",
+		})
+	}
+	if wait > 0 {
+		return loginLockedResponse(c, wait)
+	}
+
+	ok, err := checkSecondFactor(c, &user, body.Code)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"statusCode": 500,
+			"message":    "Internal Server Error",
+		})
+	}
+	if !ok {
+		if err := recordLoginFailure(c.Context(), models.RealmUsers, user.Email, c.IP()); err != nil {
+			log.Println("login attempts:", err)
+		}
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"statusCode": 401,
+			"message":    "Invalid code",
+		})
+	}
+
+	return completeLogin(c, user)
+}
+
+// checkSecondFactor valida un código TOTP o consume un código de recuperación.
+// Los códigos TOTP solo se aceptan una vez.
+func checkSecondFactor(c *fiber.Ctx, user *models.Users, code string) (bool, error) {
+	collection := database.Mg.Db.Collection("users")
+
+	if step, ok := utils.ValidateTOTP(user.TOTPSecret, code, time.Now()); ok {
+		res, err := collection.UpdateOne(c.Context(),
+			bson.M{"_id": user.ID, "totp_last_step": bson.M{"$lt": step}},
+			bson.M{"$set": bson.M{"totp_last_step": step}},
+		)
+		if err != nil {
+			return false, err
+		}
+		return res.ModifiedCount > 0, nil
+	}
+
+	hashed := utils.HashToken(utils.NormalizeRecoveryCode(code))
+	res, err := collection.UpdateOne(c.Context(),
+		bson.M{"_id": user.ID, "recovery_codes": hashed},
+		bson.M{"$pull": bson.M{"recovery_codes": hashed}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount > 0, nil
+}
@@ -5,12 +5,13 @@ import (
 	"log"
 	"main/database"
 	"main/models"
+	"main/utils"
 
 	"github.com/gofiber/fiber/v2"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
-	"golang.org/x/crypto/bcrypt"
 )
 
 func checkEmailExists(email string) bool {
@@ -59,6 +60,14 @@ func CreateUser(c *fiber.Ctx) error {
 		})
 	}
 
+	// Check email format
+	if !isValidEmail(user.Email) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"statusCode": 400,
+			"message":    "Invalid email",
+		})
+	}
+
 	// Check if email exists
 	if checkEmailExists(user.Email) {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
@@ -67,8 +76,24 @@ func CreateUser(c *fiber.Ctx) error {
 		})
 	}
 
+	// Check role, viewer by default
+	if user.Role == "" {
+		user.Role = models.RoleViewer
+	}
+	if !models.IsValidRole(user.Role) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"statusCode": 400,
+			"message":    "Invalid role",
+		})
+	}
+
+	// Check password policy
+	if errs := utils.Policy.Validate(user.Password, user.Email, user.Name); len(errs) > 0 {
+		return passwordPolicyError(c, errs)
+	}
+
 	// Hash the password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), 8)
+	hashedPassword, err := utils.Passwords.Hash(user.Password)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"statusCode": 500,
@@ -78,10 +103,11 @@ func CreateUser(c *fiber.Ctx) error {
 
 	// Insert user
 	res, err := collection.InsertOne(c.Context(), bson.M{
-		"name":     user.Name,
-		"email":    user.Email,
-		"password": hashedPassword,
-		"role":     user.Role,
+		"name":           user.Name,
+		"email":          user.Email,
+		"password":       hashedPassword,
+		"role":           user.Role,
+		"email_verified": false,
 	})
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -124,52 +150,66 @@ func CreateUser(c *fiber.Ctx) error {
 
 	profile.ID = insertedUserID
 
+	// Send verification link
+	sendVerificationEmailAsync(models.RealmUsers, insertedID, user.Email)
+
 	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
-		"id":      res.InsertedID,
-		"name":    user.Name,
-		"email":   user.Email,
-		"role":    user.Role,
-		"profile": profile,
+		"id":             res.InsertedID,
+		"name":           user.Name,
+		"email":          user.Email,
+		"role":           user.Role,
+		"email_verified": false,
+		"profile":        profile,
 	})
 
 }
 
+// Campos por los que se pueden ordenar los usuarios
+var userListOptions = listOptions{
+	sortable: map[string]string{
+		"name":  "name",
+		"email": "email",
+		"role":  "role",
+	},
+}
+
 // @Summary Get Users
-// @Description Obtiene una lista de usuarios con sus perfiles.
+// @Description Obtiene una lista paginada de usuarios con sus perfiles.
 // @Tags Users
 // @Accept json
 // @Produce json
 // @Param search query string false "Parámetro opcional para buscar usuarios por nombre."
+// @Param role query string false "Filtrar por rol."
+// @Param page query int false "Página, empieza en 1."
+// @Param limit query int false "Usuarios por página."
+// @Param cursor query string false "Cursor de la página siguiente."
+// @Param sort query string false "Campos de ordenación separados por comas, con - para descendente."
 // @Success 200 {object} GetUsersResponse
 // @Router /users [get]
 func GetUsers(c *fiber.Ctx) error {
-
-	query := bson.D{{}}
+	query, errs := parseListQuery(c, userListOptions)
+	if len(errs) > 0 {
+		return invalidQueryError(c, errs)
+	}
 
 	if len(c.Query("search")) > 0 {
-		query = bson.D{{Key: "name", Value: primitive.Regex{Pattern: c.Query("search"), Options: "i"}}}
+		query.Filter["name"] = primitive.Regex{Pattern: utils.ContainsPattern(c.Query("search")), Options: "i"}
+	}
+	if role := c.Query("role"); role != "" {
+		query.Filter["role"] = role
 	}
 
 	// Get all fields except password
 	projection := bson.M{"password": 0}
 
-	cursor, err := database.Mg.Db.Collection("users").Find(c.Context(), query, options.Find().SetProjection(projection))
+	var users []models.Users
+	page, err := query.Find(c.Context(), database.Mg.Db.Collection("users"), projection, &users)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"statusCode": 500,
-			"message":    "Internal server error asd1",
-			"error":      err,
-		})
+		return findErrorResponse(c, err)
 	}
-	defer cursor.Close(context.Background())
-
-	var usersWithProfile []map[string]interface{}
 
-	for cursor.Next(context.Background()) {
-		var user models.Users
-		if err := cursor.Decode(&user); err != nil {
-			return err
-		}
+	usersWithProfile := make([]map[string]interface{}, 0, len(users))
+	for _, user := range users {
 		var profile models.Profile
 		err = database.Mg.Db.Collection("profile").FindOne(c.Context(), bson.M{"user_id": user.ID}).Decode(&profile)
 		if err != nil {
@@ -178,7 +218,6 @@ func GetUsers(c *fiber.Ctx) error {
 				"message":    "User not found",
 			})
 		}
-		//// this way
 
 		userWithProfile := make(map[string]interface{})
 		userWithProfile["id"] = user.ID
@@ -189,8 +228,11 @@ func GetUsers(c *fiber.Ctx) error {
 		usersWithProfile = append(usersWithProfile, userWithProfile)
 	}
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"items": usersWithProfile,
-		"total": len(usersWithProfile),
+		"items":       usersWithProfile,
+		"total":       page.Total,
+		"page":        page.Page,
+		"limit":       page.Limit,
+		"next_cursor": page.NextCursor,
 	})
 }
 
@@ -246,25 +288,55 @@ func UpdateUser(c *fiber.Ctx) error {
 	}
 	filter := bson.M{"_id": objectId}
 
-	count, err := database.Mg.Db.Collection("users").CountDocuments(c.Context(), filter)
+	// La contraseña solo se cambia en /api/users/:id/password, que pide la actual
+	if user.Password != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"statusCode": 400,
+			"message":    "Password cannot be changed here, use /api/users/:id/password",
+		})
+	}
+
+	var current struct {
+		Email string `bson:"email"`
+	}
+	err = database.Mg.Db.Collection("users").FindOne(c.Context(), filter,
+		options.FindOne().SetProjection(bson.M{"email": 1}),
+	).Decode(&current)
 	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"statusCode": 404,
+				"message":    "User not found",
+			})
+		}
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"statusCode": 500,
 			"message":    "Internal Server Error",
 		})
 	}
-	if count == 0 {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"statusCode": 404,
-			"message":    "User not found",
-		})
+
+	// Un email nuevo tiene que ser válido y no usarlo otra cuenta, y hay que volver a verificarlo
+	emailChanged := user.Email != "" && user.Email != current.Email
+	if emailChanged {
+		problem, err := checkNewEmail(c.Context(), models.RealmUsers, objectId, user.Email)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"statusCode": 500,
+				"message":    "Internal Server Error",
+			})
+		}
+		if problem != "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"statusCode": 400,
+				"message":    problem,
+			})
+		}
 	}
 
 	// Crear un mapa con los campos actualizables
 	update := bson.M{
-		"name":     user.Name,
-		"email":    user.Email,
-		"password": user.Password,
+		"name":  user.Name,
+		"email": user.Email,
 	}
 
 	// Crear un bson.M con los datos no nulos
@@ -275,15 +347,32 @@ func UpdateUser(c *fiber.Ctx) error {
 		}
 	}
 
+	if emailChanged {
+		verified := false
+		updateNotNull["email_verified"] = verified
+		user.EmailVerified = &verified
+	}
+
 	_, err = database.Mg.Db.Collection("users").UpdateOne(c.Context(), filter, bson.M{"$set": updateNotNull})
 
 	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"statusCode": 400,
+				"message":    "Email already exists",
+			})
+		}
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"statusCode": 500,
 			"message":    "Internal Server Error",
 		})
 	}
 
+	// Send verification link to the new email
+	if emailChanged {
+		sendVerificationEmailAsync(models.RealmUsers, objectId, user.Email)
+	}
+
 	return c.Status(fiber.StatusOK).JSON(&user)
 }
 
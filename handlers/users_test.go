@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"main/database"
+	"main/mailer"
+	"main/utils"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// setTestKeys firma los tokens de la API con una clave HS256 fija
+func setTestKeys() {
+	utils.Keys = utils.KeyManager{Current: &utils.SigningKey{
+		ID: "test", Method: jwt.SigningMethodHS256, Private: []byte("test-secret"), Public: []byte("test-secret"),
+	}}
+}
+
+// chanMailer pasa los correos enviados a un canal
+type chanMailer chan mailer.Message
+
+func (m chanMailer) Send(msg mailer.Message) error {
+	m <- msg
+	return nil
+}
+
+// setCommand devuelve el $set del primer update enviado a la BD
+func setCommand(mt *mtest.T) bson.Raw {
+	for _, event := range mt.GetAllStartedEvents() {
+		if event.CommandName != "update" {
+			continue
+		}
+		updates, _ := event.Command.Lookup("updates").Array().Values()
+		return updates[0].Document().Lookup("u", "$set").Document()
+	}
+	return nil
+}
+
+func TestUpdateEmail(t *testing.T) {
+	setTestKeys()
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	tests := []struct {
+		name  string
+		route string
+		do    fiber.Handler
+		coll  string
+	}{
+		{"user", "/api/users/:id", UpdateUser, "users"},
+		{"customer", "/api/customers/:id", UpdateCustomer, "customers"},
+	}
+	for _, tt := range tests {
+		app := fiber.New()
+		app.Put(tt.route, tt.do)
+		id := primitive.NewObjectID()
+		put := func(mt *mtest.T, body string) int {
+			url := strings.Replace(tt.route, ":id", id.Hex(), 1)
+			req := httptest.NewRequest("PUT", url, strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			res, err := app.Test(req)
+			if err != nil {
+				mt.Fatal(err)
+			}
+			return res.StatusCode
+		}
+		current := func(mt *mtest.T) bson.D {
+			return mtest.CreateCursorResponse(0, mt.DB.Name()+"."+tt.coll, mtest.FirstBatch, bson.D{
+				{Key: "_id", Value: id},
+				{Key: "email", Value: "old@example.com"},
+			})
+		}
+
+		mt.Run(tt.name+" invalid email", func(mt *mtest.T) {
+			database.Mg.Db = mt.DB
+			mt.AddMockResponses(current(mt))
+			if status := put(mt, `{"email":"not an email"}`); status != fiber.StatusBadRequest {
+				mt.Errorf("status = %d, want 400", status)
+			}
+		})
+
+		mt.Run(tt.name+" email taken", func(mt *mtest.T) {
+			database.Mg.Db = mt.DB
+			mt.AddMockResponses(current(mt), mtest.CreateCursorResponse(0, mt.DB.Name()+"."+tt.coll, mtest.FirstBatch, bson.D{{Key: "n", Value: 1}}))
+			if status := put(mt, `{"email":"taken@example.com"}`); status != fiber.StatusBadRequest {
+				mt.Errorf("status = %d, want 400", status)
+			}
+			// La unicidad se comprueba en la colección del realm
+			var checked string
+			for _, event := range mt.GetAllStartedEvents() {
+				if event.CommandName == "aggregate" {
+					checked = event.Command.Lookup("aggregate").StringValue()
+				}
+			}
+			if checked != tt.coll {
+				mt.Errorf("email checked in %q, want %q", checked, tt.coll)
+			}
+		})
+
+		mt.Run(tt.name+" same email", func(mt *mtest.T) {
+			database.Mg.Db = mt.DB
+			mt.AddMockResponses(current(mt), mtest.CreateSuccessResponse())
+			if status := put(mt, `{"email":"old@example.com","name":"Ana"}`); status != fiber.StatusOK {
+				mt.Fatalf("status = %d, want 200", status)
+			}
+			set := setCommand(mt)
+			if _, err := set.LookupErr("email_verified"); set == nil || err == nil {
+				mt.Errorf("$set = %v, want email_verified untouched", set)
+			}
+		})
+
+		mt.Run(tt.name+" new email", func(mt *mtest.T) {
+			database.Mg.Db = mt.DB
+			sent := make(chanMailer, 1)
+			mailer.Default = sent
+			defer func() { mailer.Default = &mailer.LogMailer{} }()
+
+			mt.AddMockResponses(
+				current(mt),
+				mtest.CreateCursorResponse(0, mt.DB.Name()+"."+tt.coll, mtest.FirstBatch),
+				mtest.CreateSuccessResponse(),
+				mtest.CreateSuccessResponse(),
+			)
+			if status := put(mt, `{"email":"new@example.com"}`); status != fiber.StatusOK {
+				mt.Fatalf("status = %d, want 200", status)
+			}
+			set := setCommand(mt)
+			if set == nil || set.Lookup("email").StringValue() != "new@example.com" || set.Lookup("email_verified").Boolean() {
+				mt.Errorf("$set = %v, want the new email and email_verified=false", set)
+			}
+
+			select {
+			case msg := <-sent:
+				if msg.To != "new@example.com" {
+					mt.Errorf("verification sent to %q", msg.To)
+				}
+			case <-time.After(2 * time.Second):
+				mt.Error("no verification email sent")
+			}
+		})
+	}
+}
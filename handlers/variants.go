@@ -0,0 +1,537 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"main/database"
+	"main/models"
+	"main/utils"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Máximo de variantes que puede tener un producto
+const maxVariants = 100
+
+// variantBody es el cuerpo para crear o cambiar una variante
+type variantBody struct {
+	SKU     string            `json:"sku"`
+	Options map[string]string `json:"options"`
+	Price   *models.Money     `json:"price"`
+	Image   string            `json:"image"`
+}
+
+// normalizeOptions limpia las opciones de un producto y comprueba que no haya nombres
+// ni valores vacíos o repetidos
+func normalizeOptions(productOptions []models.ProductOption) ([]models.ProductOption, []models.FieldError) {
+	errs := make([]models.FieldError, 0)
+	names := make(map[string]bool)
+	normalized := make([]models.ProductOption, 0, len(productOptions))
+	for i, option := range productOptions {
+		field := fmt.Sprintf("options[%d]", i)
+		name := strings.TrimSpace(option.Name)
+		if name == "" {
+			errs = append(errs, models.FieldError{Field: field + ".name", Code: "required", Message: "Option name is required"})
+			continue
+		}
+		if names[strings.ToLower(name)] {
+			errs = append(errs, models.FieldError{Field: field + ".name", Code: "duplicate", Message: "Option " + name + " is repeated"})
+			continue
+		}
+		names[strings.ToLower(name)] = true
+
+		values := make([]string, 0, len(option.Values))
+		seen := make(map[string]bool)
+		for _, value := range option.Values {
+			value = strings.TrimSpace(value)
+			if value == "" || seen[strings.ToLower(value)] {
+				errs = append(errs, models.FieldError{Field: field + ".values", Code: "invalid", Message: "Option values must be unique and not empty"})
+				break
+			}
+			seen[strings.ToLower(value)] = true
+			values = append(values, value)
+		}
+		if len(values) == 0 {
+			errs = append(errs, models.FieldError{Field: field + ".values", Code: "required", Message: "Option " + name + " needs at least one value"})
+			continue
+		}
+		normalized = append(normalized, models.ProductOption{Name: name, Values: values})
+	}
+	return normalized, errs
+}
+
+// variantOptions comprueba que la variante tenga un valor de cada opción del producto
+// y devuelve los valores con los nombres tal como están en el producto
+func variantOptions(productOptions []models.ProductOption, values map[string]string) (map[string]string, error) {
+	if len(productOptions) == 0 {
+		return nil, fmt.Errorf("product has no options")
+	}
+	if len(values) != len(productOptions) {
+		return nil, fmt.Errorf("variant needs one value for each option")
+	}
+
+	lower := make(map[string]string, len(values))
+	for name, value := range values {
+		lower[strings.ToLower(strings.TrimSpace(name))] = strings.TrimSpace(value)
+	}
+
+	result := make(map[string]string, len(productOptions))
+	for _, option := range productOptions {
+		value, ok := lower[strings.ToLower(option.Name)]
+		if !ok {
+			return nil, fmt.Errorf("missing value for option %s", option.Name)
+		}
+		found := false
+		for _, allowed := range option.Values {
+			if strings.EqualFold(allowed, value) {
+				result[option.Name] = allowed
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("invalid value %s for option %s", value, option.Name)
+		}
+	}
+	return result, nil
+}
+
+// loadVariants devuelve las variantes del producto en el orden en que se crearon
+func loadVariants(ctx context.Context, productID primitive.ObjectID) ([]models.Variant, error) {
+	cursor, err := database.Mg.Db.Collection("variants").Find(ctx, bson.M{"product_id": productID},
+		options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	variants := make([]models.Variant, 0)
+	if err := cursor.All(ctx, &variants); err != nil {
+		return nil, err
+	}
+	return variants, nil
+}
+
+// uniqueSKU devuelve el SKU generado de la variante, con un sufijo numérico si ya lo
+// usa otra. "camiseta-basica" y {Color: Rojo, Talla: M} -> "CAMISETA-BASICA-ROJO-M"
+func uniqueSKU(ctx context.Context, product *models.Product, values map[string]string) (string, error) {
+	parts := []string{product.Slug}
+	if product.Slug == "" {
+		parts[0] = product.Name
+	}
+	for _, option := range product.Options {
+		parts = append(parts, values[option.Name])
+	}
+	base := models.NormalizeSKU(utils.Slugify(strings.Join(parts, " ")))
+
+	for i := 1; ; i++ {
+		sku := base
+		if i > 1 {
+			sku = fmt.Sprintf("%s-%d", base, i)
+		}
+		count, err := database.Mg.Db.Collection("variants").CountDocuments(ctx, bson.M{"sku": sku})
+		if err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return sku, nil
+		}
+	}
+}
+
+// variantConflict comprueba que el SKU y la combinación de opciones estén libres.
+// Devuelve el motivo si no lo están. El índice único evita los duplicados de todas formas.
+func variantConflict(ctx context.Context, variant *models.Variant, excludeID *primitive.ObjectID) (string, error) {
+	collection := database.Mg.Db.Collection("variants")
+
+	filter := bson.M{"sku": variant.SKU}
+	if excludeID != nil {
+		filter["_id"] = bson.M{"$ne": *excludeID}
+	}
+	count, err := collection.CountDocuments(ctx, filter)
+	if err != nil || count > 0 {
+		return "SKU already exists", err
+	}
+
+	filter = bson.M{"product_id": variant.ProductID, "key": variant.Key}
+	if excludeID != nil {
+		filter["_id"] = bson.M{"$ne": *excludeID}
+	}
+	count, err = collection.CountDocuments(ctx, filter)
+	if err != nil || count > 0 {
+		return "A variant with these options already exists", err
+	}
+	return "", nil
+}
+
+// variantWriteError responde a un error al guardar una variante
+func variantWriteError(c *fiber.Ctx, err error) error {
+	if mongo.IsDuplicateKeyError(err) {
+		return c.Status(fiber.StatusConflict).JSON(models.Error{Message: "SKU or option combination already exists", StatusCode: 409})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(models.Error{Message: "Internal Server Error", StatusCode: 500})
+}
+
+// parseVariant valida el cuerpo de una variante del producto
+func parseVariant(c *fiber.Ctx, product *models.Product) (*models.Variant, []models.FieldError) {
+	var body variantBody
+	if err := c.BodyParser(&body); err != nil {
+		return nil, []models.FieldError{{Field: "body", Code: "invalid", Message: "Invalid request body"}}
+	}
+
+	errs := make([]models.FieldError, 0)
+	values, err := variantOptions(product.Options, body.Options)
+	if err != nil {
+		errs = append(errs, models.FieldError{Field: "options", Code: "invalid", Message: err.Error()})
+	}
+	if body.Price != nil {
+		errs = append(errs, checkBasePrice(body.Price, "price")...)
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	productID, _ := primitive.ObjectIDFromHex(product.ID)
+	return &models.Variant{
+		ProductID: productID,
+		SKU:       models.NormalizeSKU(body.SKU),
+		Options:   values,
+		Key:       models.VariantKey(values),
+		Price:     body.Price,
+		Image:     body.Image,
+	}, nil
+}
+
+// invalidVariantError responde 400 con los errores de la variante
+func invalidVariantError(c *fiber.Ctx, errs []models.FieldError) error {
+	return c.Status(fiber.StatusBadRequest).JSON(models.ValidationError{Message: "Invalid variant", StatusCode: 400, Errors: errs})
+}
+
+// SetProductOptions cambia las opciones del producto. No se pueden quitar opciones ni
+// valores que usen sus variantes.
+func SetProductOptions(c *fiber.Ctx) error {
+	productID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.Error{Message: "Invalid ID", StatusCode: 400})
+	}
+
+	var body struct {
+		Options []models.ProductOption `json:"options"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.Error{Message: "Invalid request body", StatusCode: 400})
+	}
+	productOptions, errs := normalizeOptions(body.Options)
+	if len(errs) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ValidationError{Message: "Invalid options", StatusCode: 400, Errors: errs})
+	}
+
+	if _, err := findProduct(c.Context(), productID); err != nil {
+		return productNotFound(c, err)
+	}
+
+	variants, err := loadVariants(c.Context(), productID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.Error{Message: "Internal Server Error", StatusCode: 500})
+	}
+	for _, variant := range variants {
+		values, err := variantOptions(productOptions, variant.Options)
+		if err != nil || models.VariantKey(values) != variant.Key {
+			return c.Status(fiber.StatusConflict).JSON(models.Error{Message: "Options are used by variant " + variant.SKU, StatusCode: 409})
+		}
+	}
+
+	update := bson.M{"$set": bson.M{"options": productOptions}}
+	if len(productOptions) == 0 {
+		update = bson.M{"$unset": bson.M{"options": ""}}
+	}
+	_, err = database.Mg.Db.Collection("Products").UpdateOne(c.Context(), bson.M{"_id": productID}, update)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.Error{Message: "Internal Server Error", StatusCode: 500})
+	}
+
+	// Mantener el índice de búsqueda al día
+	reindexProduct(c.Context(), productID.Hex())
+
+	return c.JSON(fiber.Map{"product_id": productID.Hex(), "options": productOptions})
+}
+
+// GetVariants devuelve las variantes del producto
+func GetVariants(c *fiber.Ctx) error {
+	productID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.Error{Message: "Invalid ID", StatusCode: 400})
+	}
+	product, err := findProduct(c.Context(), productID)
+	if err != nil {
+		return productNotFound(c, err)
+	}
+
+	variants, err := loadVariants(c.Context(), productID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.Error{Message: "Internal Server Error", StatusCode: 500})
+	}
+
+	productOptions := product.Options
+	if productOptions == nil {
+		productOptions = []models.ProductOption{}
+	}
+	return c.JSON(fiber.Map{"options": productOptions, "items": variants})
+}
+
+// GetVariant devuelve una variante del producto
+func GetVariant(c *fiber.Ctx) error {
+	productID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.Error{Message: "Invalid ID", StatusCode: 400})
+	}
+	variantID, err := primitive.ObjectIDFromHex(c.Params("variantId"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.Error{Message: "Invalid variant ID", StatusCode: 400})
+	}
+
+	var variant models.Variant
+	err = database.Mg.Db.Collection("variants").FindOne(c.Context(), bson.M{"_id": variantID, "product_id": productID}).Decode(&variant)
+	if err != nil {
+		return productNotFound(c, err)
+	}
+	return c.JSON(variant)
+}
+
+// CreateVariant crea una variante del producto. Si no se envía el SKU se genera a partir
+// del slug del producto y los valores de las opciones. La primera variante no se puede
+// crear mientras el producto tenga stock sin variante.
+func CreateVariant(c *fiber.Ctx) error {
+	productID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.Error{Message: "Invalid ID", StatusCode: 400})
+	}
+	product, err := findProduct(c.Context(), productID)
+	if err != nil {
+		return productNotFound(c, err)
+	}
+
+	variant, errs := parseVariant(c, product)
+	if len(errs) > 0 {
+		return invalidVariantError(c, errs)
+	}
+
+	collection := database.Mg.Db.Collection("variants")
+	count, err := collection.CountDocuments(c.Context(), bson.M{"product_id": productID})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.Error{Message: "Internal Server Error", StatusCode: 500})
+	}
+	if count >= maxVariants {
+		return c.Status(fiber.StatusConflict).JSON(models.Error{Message: fmt.Sprintf("A product cannot have more than %d variants", maxVariants), StatusCode: 409})
+	}
+	if count == 0 {
+		hasStock, err := hasProductStock(c.Context(), productID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(models.Error{Message: "Internal Server Error", StatusCode: 500})
+		}
+		if hasStock {
+			return c.Status(fiber.StatusConflict).JSON(models.Error{Message: "Product has stock without variant, adjust it to zero first", StatusCode: 409})
+		}
+	}
+
+	if variant.SKU == "" {
+		if variant.SKU, err = uniqueSKU(c.Context(), product, variant.Options); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(models.Error{Message: "Internal Server Error", StatusCode: 500})
+		}
+	}
+	conflict, err := variantConflict(c.Context(), variant, nil)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.Error{Message: "Internal Server Error", StatusCode: 500})
+	}
+	if conflict != "" {
+		return c.Status(fiber.StatusConflict).JSON(models.Error{Message: conflict, StatusCode: 409})
+	}
+
+	res, err := collection.InsertOne(c.Context(), variant)
+	if err != nil {
+		return variantWriteError(c, err)
+	}
+	variant.ID = res.InsertedID.(primitive.ObjectID)
+
+	// Mantener el índice de búsqueda al día
+	reindexProduct(c.Context(), productID.Hex())
+
+	return c.Status(fiber.StatusCreated).JSON(variant)
+}
+
+// GenerateVariants crea las variantes que falten para todas las combinaciones de las
+// opciones del producto, con el SKU generado y sin precio propio. Como en
+// CreateVariant, el producto no puede tener stock sin variante.
+func GenerateVariants(c *fiber.Ctx) error {
+	productID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.Error{Message: "Invalid ID", StatusCode: 400})
+	}
+	product, err := findProduct(c.Context(), productID)
+	if err != nil {
+		return productNotFound(c, err)
+	}
+	if len(product.Options) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(models.Error{Message: "Product has no options", StatusCode: 400})
+	}
+
+	// Todas las combinaciones, en el orden de las opciones
+	combinations := []map[string]string{{}}
+	for _, option := range product.Options {
+		next := make([]map[string]string, 0, len(combinations)*len(option.Values))
+		for _, combination := range combinations {
+			for _, value := range option.Values {
+				values := make(map[string]string, len(combination)+1)
+				for name, v := range combination {
+					values[name] = v
+				}
+				values[option.Name] = value
+				next = append(next, values)
+			}
+		}
+		combinations = next
+		if len(combinations) > maxVariants {
+			return c.Status(fiber.StatusBadRequest).JSON(models.Error{Message: fmt.Sprintf("A product cannot have more than %d variants", maxVariants), StatusCode: 400})
+		}
+	}
+
+	existing, err := loadVariants(c.Context(), productID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.Error{Message: "Internal Server Error", StatusCode: 500})
+	}
+	if len(existing) == 0 {
+		hasStock, err := hasProductStock(c.Context(), productID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(models.Error{Message: "Internal Server Error", StatusCode: 500})
+		}
+		if hasStock {
+			return c.Status(fiber.StatusConflict).JSON(models.Error{Message: "Product has stock without variant, adjust it to zero first", StatusCode: 409})
+		}
+	}
+	keys := make(map[string]bool, len(existing))
+	for _, variant := range existing {
+		keys[variant.Key] = true
+	}
+
+	created := make([]models.Variant, 0)
+	for _, values := range combinations {
+		key := models.VariantKey(values)
+		if keys[key] {
+			continue
+		}
+		sku, err := uniqueSKU(c.Context(), product, values)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(models.Error{Message: "Internal Server Error", StatusCode: 500})
+		}
+		variant := models.Variant{ProductID: productID, SKU: sku, Options: values, Key: key}
+		res, err := database.Mg.Db.Collection("variants").InsertOne(c.Context(), variant)
+		if err != nil {
+			return variantWriteError(c, err)
+		}
+		variant.ID = res.InsertedID.(primitive.ObjectID)
+		created = append(created, variant)
+	}
+
+	// Mantener el índice de búsqueda al día
+	reindexProduct(c.Context(), productID.Hex())
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"items": created})
+}
+
+// UpdateVariant cambia el SKU, las opciones, el precio y la imagen de la variante. Sin
+// price la variante pasa a tener el precio del producto. El stock no se cambia aquí.
+func UpdateVariant(c *fiber.Ctx) error {
+	productID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.Error{Message: "Invalid ID", StatusCode: 400})
+	}
+	variantID, err := primitive.ObjectIDFromHex(c.Params("variantId"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.Error{Message: "Invalid variant ID", StatusCode: 400})
+	}
+	product, err := findProduct(c.Context(), productID)
+	if err != nil {
+		return productNotFound(c, err)
+	}
+
+	variant, errs := parseVariant(c, product)
+	if len(errs) > 0 {
+		return invalidVariantError(c, errs)
+	}
+	if variant.SKU == "" {
+		return invalidVariantError(c, []models.FieldError{{Field: "sku", Code: "required", Message: "SKU is required"}})
+	}
+	conflict, err := variantConflict(c.Context(), variant, &variantID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.Error{Message: "Internal Server Error", StatusCode: 500})
+	}
+	if conflict != "" {
+		return c.Status(fiber.StatusConflict).JSON(models.Error{Message: conflict, StatusCode: 409})
+	}
+
+	set := bson.M{"sku": variant.SKU, "options": variant.Options, "key": variant.Key, "image": variant.Image}
+	update := bson.M{"$set": set}
+	if variant.Price != nil {
+		set["price"] = *variant.Price
+	} else {
+		update["$unset"] = bson.M{"price": ""}
+	}
+
+	var updated models.Variant
+	err = database.Mg.Db.Collection("variants").FindOneAndUpdate(c.Context(), bson.M{"_id": variantID, "product_id": productID}, update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&updated)
+	if err == mongo.ErrNoDocuments {
+		return c.Status(fiber.StatusNotFound).JSON(models.Error{Message: "Not Found", StatusCode: 404})
+	}
+	if err != nil {
+		return variantWriteError(c, err)
+	}
+
+	// Mantener el índice de búsqueda al día
+	reindexProduct(c.Context(), productID.Hex())
+
+	return c.JSON(updated)
+}
+
+// DeleteVariant borra una variante sin stock
+func DeleteVariant(c *fiber.Ctx) error {
+	productID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.Error{Message: "Invalid ID", StatusCode: 400})
+	}
+	variantID, err := primitive.ObjectIDFromHex(c.Params("variantId"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.Error{Message: "Invalid variant ID", StatusCode: 400})
+	}
+
+	collection := database.Mg.Db.Collection("variants")
+	result, err := collection.DeleteOne(c.Context(), bson.M{"_id": variantID, "product_id": productID, "stock": 0})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.Error{Message: "Internal Server Error", StatusCode: 500})
+	}
+	if result.DeletedCount == 0 {
+		count, err := collection.CountDocuments(c.Context(), bson.M{"_id": variantID, "product_id": productID})
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(models.Error{Message: "Internal Server Error", StatusCode: 500})
+		}
+		if count > 0 {
+			return c.Status(fiber.StatusConflict).JSON(models.Error{Message: "Variant has stock, adjust it to zero first", StatusCode: 409})
+		}
+		return c.Status(fiber.StatusNotFound).JSON(models.Error{Message: "Not Found", StatusCode: 404})
+	}
+
+	// Los niveles de stock de la variante están a cero, el registro de movimientos se queda
+	if _, err := database.Mg.Db.Collection("stock_levels").DeleteMany(c.Context(), bson.M{"variant_id": variantID}); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.Error{Message: "Internal Server Error", StatusCode: 500})
+	}
+
+	// Mantener el índice de búsqueda al día
+	reindexProduct(c.Context(), productID.Hex())
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
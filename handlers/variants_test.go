@@ -0,0 +1,332 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"main/database"
+	"main/models"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// countResponse simula el resultado de un CountDocuments
+func countResponse(mt *mtest.T, collection string, n int) bson.D {
+	return mtest.CreateCursorResponse(0, mt.DB.Name()+"."+collection, mtest.FirstBatch, bson.D{{Key: "n", Value: n}})
+}
+
+// insertedDocuments devuelve los documentos insertados en collection
+func insertedDocuments(mt *mtest.T, collection string) []bson.Raw {
+	docs := make([]bson.Raw, 0)
+	for _, event := range mt.GetAllStartedEvents() {
+		if event.CommandName != "insert" || event.Command.Lookup("insert").StringValue() != collection {
+			continue
+		}
+		values, _ := event.Command.Lookup("documents").Array().Values()
+		for _, value := range values {
+			docs = append(docs, value.Document())
+		}
+	}
+	return docs
+}
+
+func TestNormalizeOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []models.ProductOption
+		want    string
+		errs    []string
+	}{
+		{"trimmed", []models.ProductOption{{Name: " Talla ", Values: []string{" S", "M "}}}, "Talla=S,M", nil},
+		{"empty name", []models.ProductOption{{Name: " ", Values: []string{"S"}}}, "", []string{"options[0].name:required"}},
+		{"repeated name", []models.ProductOption{{Name: "Talla", Values: []string{"S"}}, {Name: "talla", Values: []string{"M"}}}, "Talla=S", []string{"options[1].name:duplicate"}},
+		{"repeated value", []models.ProductOption{{Name: "Talla", Values: []string{"S", "s"}}}, "Talla=S", []string{"options[0].values:invalid"}},
+		{"empty value", []models.ProductOption{{Name: "Talla", Values: []string{"S", ""}}}, "Talla=S", []string{"options[0].values:invalid"}},
+		{"no values", []models.ProductOption{{Name: "Talla"}}, "", []string{"options[0].values:required"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			normalized, errs := normalizeOptions(tt.options)
+			parts := make([]string, 0, len(normalized))
+			for _, option := range normalized {
+				parts = append(parts, option.Name+"="+strings.Join(option.Values, ","))
+			}
+			codes := make([]string, 0, len(errs))
+			for _, e := range errs {
+				codes = append(codes, e.Field+":"+e.Code)
+			}
+			if strings.Join(parts, " ") != tt.want || strings.Join(codes, ",") != strings.Join(tt.errs, ",") {
+				t.Errorf("normalizeOptions = %v, %v, want %s, %v", parts, codes, tt.want, tt.errs)
+			}
+		})
+	}
+}
+
+func TestVariantOptions(t *testing.T) {
+	productOptions := []models.ProductOption{
+		{Name: "Color", Values: []string{"Rojo", "Azul"}},
+		{Name: "Talla", Values: []string{"S", "M"}},
+	}
+
+	tests := []struct {
+		name   string
+		values map[string]string
+		want   string
+	}{
+		{"exact", map[string]string{"Color": "Rojo", "Talla": "M"}, "Color=Rojo|Talla=M"},
+		{"any case", map[string]string{" color": "azul ", "TALLA": "s"}, "Color=Azul|Talla=S"},
+		{"missing option", map[string]string{"Color": "Rojo"}, ""},
+		{"unknown option", map[string]string{"Color": "Rojo", "Manga": "Corta"}, ""},
+		{"unknown value", map[string]string{"Color": "Verde", "Talla": "M"}, ""},
+		{"extra option", map[string]string{"Color": "Rojo", "Talla": "M", "Manga": "Corta"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, err := variantOptions(productOptions, tt.values)
+			if tt.want == "" {
+				if err == nil {
+					t.Errorf("variantOptions = %v, want an error", values)
+				}
+				return
+			}
+			if err != nil || models.VariantKey(values) != tt.want {
+				t.Errorf("variantOptions = %v, %v, want %s", values, err, tt.want)
+			}
+		})
+	}
+
+	if _, err := variantOptions(nil, map[string]string{"Color": "Rojo"}); err == nil {
+		t.Error("variantOptions accepted a product without options")
+	}
+}
+
+func TestCreateVariant(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	app := fiber.New()
+	app.Post("/api/products/:id/variants", CreateVariant)
+	productID := primitive.NewObjectID()
+	product := func(mt *mtest.T) bson.D {
+		return mtest.CreateCursorResponse(0, mt.DB.Name()+".Products", mtest.FirstBatch, bson.D{
+			{Key: "_id", Value: productID},
+			{Key: "name", Value: "Camiseta"},
+			{Key: "slug", Value: "camiseta"},
+			{Key: "options", Value: bson.A{
+				bson.D{{Key: "name", Value: "Color"}, {Key: "values", Value: bson.A{"Rojo", "Azul"}}},
+				bson.D{{Key: "name", Value: "Talla"}, {Key: "values", Value: bson.A{"S", "M"}}},
+			}},
+		})
+	}
+	create := func(mt *mtest.T, body string) (int, string) {
+		req := httptest.NewRequest("POST", "/api/products/"+productID.Hex()+"/variants", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		res, err := app.Test(req)
+		if err != nil {
+			mt.Fatal(err)
+		}
+		data, _ := ioutil.ReadAll(res.Body)
+		return res.StatusCode, string(data)
+	}
+
+	tests := []struct {
+		name      string
+		body      string
+		responses func(mt *mtest.T) []bson.D
+		status    int
+		message   string
+		sku       string
+	}{
+		{
+			"invalid options",
+			`{"options":{"Color":"Verde","Talla":"M"}}`,
+			func(mt *mtest.T) []bson.D { return []bson.D{product(mt)} },
+			fiber.StatusBadRequest, "Invalid variant", "",
+		},
+		{
+			"product stock without variant",
+			`{"sku":"cam-1","options":{"Color":"Rojo","Talla":"M"}}`,
+			func(mt *mtest.T) []bson.D {
+				return []bson.D{product(mt), countResponse(mt, "variants", 0), countResponse(mt, "stock_levels", 1)}
+			},
+			fiber.StatusConflict, "Product has stock without variant, adjust it to zero first", "",
+		},
+		{
+			"sku taken",
+			`{"sku":"cam-1","options":{"Color":"Rojo","Talla":"M"}}`,
+			func(mt *mtest.T) []bson.D {
+				return []bson.D{product(mt), countResponse(mt, "variants", 0), countResponse(mt, "stock_levels", 0), countResponse(mt, "variants", 1)}
+			},
+			fiber.StatusConflict, "SKU already exists", "",
+		},
+		{
+			"options taken",
+			`{"sku":"cam-1","options":{"Color":"Rojo","Talla":"M"}}`,
+			func(mt *mtest.T) []bson.D {
+				return []bson.D{product(mt), countResponse(mt, "variants", 1), countResponse(mt, "variants", 0), countResponse(mt, "variants", 1)}
+			},
+			fiber.StatusConflict, "A variant with these options already exists", "",
+		},
+		{
+			"sku taken on insert",
+			`{"sku":"cam-1","options":{"Color":"Rojo","Talla":"M"}}`,
+			func(mt *mtest.T) []bson.D {
+				return []bson.D{
+					product(mt), countResponse(mt, "variants", 0), countResponse(mt, "stock_levels", 0),
+					countResponse(mt, "variants", 0), countResponse(mt, "variants", 0),
+					mtest.CreateWriteErrorsResponse(mtest.WriteError{Code: 11000, Message: "duplicate key"}),
+				}
+			},
+			fiber.StatusConflict, "SKU or option combination already exists", "",
+		},
+		{
+			"explicit sku",
+			`{"sku":" cam-1 ","options":{"Color":"Rojo","Talla":"M"}}`,
+			func(mt *mtest.T) []bson.D {
+				return []bson.D{
+					product(mt), countResponse(mt, "variants", 0), countResponse(mt, "stock_levels", 0),
+					countResponse(mt, "variants", 0), countResponse(mt, "variants", 0), mtest.CreateSuccessResponse(),
+				}
+			},
+			fiber.StatusCreated, "", "CAM-1",
+		},
+		{
+			"generated sku",
+			`{"options":{"color":"rojo","talla":"m"}}`,
+			func(mt *mtest.T) []bson.D {
+				return []bson.D{
+					product(mt), countResponse(mt, "variants", 2),
+					countResponse(mt, "variants", 1), countResponse(mt, "variants", 0),
+					countResponse(mt, "variants", 0), countResponse(mt, "variants", 0), mtest.CreateSuccessResponse(),
+				}
+			},
+			fiber.StatusCreated, "", "CAMISETA-ROJO-M-2",
+		},
+	}
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			database.Mg.Db = mt.DB
+			mt.AddMockResponses(tt.responses(mt)...)
+
+			status, body := create(mt, tt.body)
+			if status != tt.status {
+				mt.Fatalf("status = %d, body = %s, want %d", status, body, tt.status)
+			}
+			inserted := insertedDocuments(mt, "variants")
+			if tt.status != fiber.StatusCreated {
+				var e models.Error
+				if err := json.Unmarshal([]byte(body), &e); err != nil || e.Message != tt.message {
+					mt.Errorf("body = %s, want %q", body, tt.message)
+				}
+				if tt.message != "SKU or option combination already exists" && len(inserted) > 0 {
+					mt.Errorf("variant inserted: %v", inserted)
+				}
+				return
+			}
+			if len(inserted) != 1 || inserted[0].Lookup("sku").StringValue() != tt.sku ||
+				inserted[0].Lookup("key").StringValue() != "Color=Rojo|Talla=M" {
+				mt.Errorf("inserted = %v, want sku %s", inserted, tt.sku)
+			}
+		})
+	}
+}
+
+func TestGenerateVariants(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	app := fiber.New()
+	app.Post("/api/products/:id/variants/generate", GenerateVariants)
+	productID := primitive.NewObjectID()
+	product := func(mt *mtest.T, productOptions bson.A) bson.D {
+		return mtest.CreateCursorResponse(0, mt.DB.Name()+".Products", mtest.FirstBatch, bson.D{
+			{Key: "_id", Value: productID},
+			{Key: "slug", Value: "camiseta"},
+			{Key: "options", Value: productOptions},
+		})
+	}
+	colorAndSize := bson.A{
+		bson.D{{Key: "name", Value: "Color"}, {Key: "values", Value: bson.A{"Rojo", "Azul"}}},
+		bson.D{{Key: "name", Value: "Talla"}, {Key: "values", Value: bson.A{"S", "M"}}},
+	}
+	generate := func(mt *mtest.T) int {
+		res, err := app.Test(httptest.NewRequest("POST", "/api/products/"+productID.Hex()+"/variants/generate", nil))
+		if err != nil {
+			mt.Fatal(err)
+		}
+		return res.StatusCode
+	}
+
+	mt.Run("missing combinations", func(mt *mtest.T) {
+		database.Mg.Db = mt.DB
+		ns := mt.DB.Name() + ".variants"
+		responses := []bson.D{
+			product(mt, colorAndSize),
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, bson.D{
+				{Key: "_id", Value: primitive.NewObjectID()},
+				{Key: "product_id", Value: productID},
+				{Key: "sku", Value: "CAMISETA-ROJO-S"},
+				{Key: "key", Value: "Color=Rojo|Talla=S"},
+			}),
+		}
+		for i := 0; i < 3; i++ {
+			responses = append(responses, countResponse(mt, "variants", 0), mtest.CreateSuccessResponse())
+		}
+		mt.AddMockResponses(responses...)
+
+		if status := generate(mt); status != fiber.StatusCreated {
+			mt.Fatalf("status = %d, want 201", status)
+		}
+		// Se crean las que faltan, en el orden de las opciones
+		skus := make([]string, 0)
+		for _, doc := range insertedDocuments(mt, "variants") {
+			skus = append(skus, doc.Lookup("sku").StringValue())
+		}
+		if got := strings.Join(skus, ","); got != "CAMISETA-ROJO-M,CAMISETA-AZUL-S,CAMISETA-AZUL-M" {
+			mt.Errorf("created %s", got)
+		}
+	})
+
+	mt.Run("product stock without variant", func(mt *mtest.T) {
+		database.Mg.Db = mt.DB
+		mt.AddMockResponses(
+			product(mt, colorAndSize),
+			mtest.CreateCursorResponse(0, mt.DB.Name()+".variants", mtest.FirstBatch),
+			countResponse(mt, "stock_levels", 1),
+		)
+		if status := generate(mt); status != fiber.StatusConflict {
+			mt.Errorf("status = %d, want 409", status)
+		}
+		if inserted := insertedDocuments(mt, "variants"); len(inserted) > 0 {
+			mt.Errorf("created %d variants", len(inserted))
+		}
+	})
+
+	mt.Run("too many", func(mt *mtest.T) {
+		database.Mg.Db = mt.DB
+		values := bson.A{}
+		for i := 0; i < 11; i++ {
+			values = append(values, string(rune('a'+i)))
+		}
+		mt.AddMockResponses(product(mt, bson.A{
+			bson.D{{Key: "name", Value: "A"}, {Key: "values", Value: values}},
+			bson.D{{Key: "name", Value: "B"}, {Key: "values", Value: values[:10]}},
+		}))
+		if status := generate(mt); status != fiber.StatusBadRequest {
+			mt.Errorf("status = %d, want 400", status)
+		}
+	})
+
+	mt.Run("no options", func(mt *mtest.T) {
+		database.Mg.Db = mt.DB
+		mt.AddMockResponses(product(mt, bson.A{}))
+		if status := generate(mt); status != fiber.StatusBadRequest {
+			mt.Errorf("status = %d, want 400", status)
+		}
+	})
+}
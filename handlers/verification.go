@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"main/config"
+	"main/database"
+	"main/mailer"
+	"main/models"
+	"main/utils"
+	"net/mail"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Tiempo mínimo entre dos correos de verificación a la misma cuenta
+const verificationResendInterval = time.Minute
+
+// isValidEmail comprueba que el email tenga un formato válido
+func isValidEmail(email string) bool {
+	address, err := mail.ParseAddress(email)
+	return err == nil && address.Address == email
+}
+
+// realmCollection devuelve la colección donde se guardan las cuentas del realm
+func realmCollection(realm string) *mongo.Collection {
+	if realm == models.RealmCustomers {
+		return database.Mg.Db.Collection("customers")
+	}
+	return database.Mg.Db.Collection("users")
+}
+
+// emailTaken indica si otra cuenta del realm ya usa el email. except es la cuenta que
+// se está editando, o primitive.NilObjectID al crear una.
+func emailTaken(ctx context.Context, realm string, email string, except primitive.ObjectID) (bool, error) {
+	filter := bson.M{"email": email}
+	if !except.IsZero() {
+		filter["_id"] = bson.M{"$ne": except}
+	}
+	count, err := realmCollection(realm).CountDocuments(ctx, filter, options.Count().SetLimit(1))
+	return count > 0, err
+}
+
+// checkNewEmail comprueba el email nuevo de la cuenta id del realm. Devuelve el motivo
+// si no se puede usar: no es válido o ya lo usa otra cuenta.
+func checkNewEmail(ctx context.Context, realm string, id primitive.ObjectID, email string) (string, error) {
+	if !isValidEmail(email) {
+		return "Invalid email", nil
+	}
+	taken, err := emailTaken(ctx, realm, email, id)
+	if err != nil {
+		return "", err
+	}
+	if taken {
+		return "Email already exists", nil
+	}
+	return "", nil
+}
+
+// sendVerificationEmail envía el enlace firmado para verificar el email de la cuenta
+func sendVerificationEmail(ctx context.Context, realm string, id primitive.ObjectID, email string) error {
+	token, err := utils.GenerateVerificationToken(realm, id.Hex(), email)
+	if err != nil {
+		return err
+	}
+
+	_, err = realmCollection(realm).UpdateOne(ctx, bson.M{"_id": id}, bson.M{
+		"$set": bson.M{"verification_sent_at": time.Now()},
+	})
+	if err != nil {
+		return err
+	}
+
+	return mailer.Default.Send(mailer.Message{
+		To:      email,
+		Subject: "Verify your email",
+		Body: fmt.Sprintf(
+			"Use the following link to verify your email. It expires in %d hours.\n\n%s/api/auth/verify-email?token=%s\n",
+			int(utils.VerificationTokenDuration.Hours()), config.Config("APP_URL"), token,
+		),
+	})
+}
+
+// sendVerificationEmailAsync envía el correo en segundo plano y solo registra los errores
+func sendVerificationEmailAsync(realm string, id primitive.ObjectID, email string) {
+	go func() {
+		if err := sendVerificationEmail(context.Background(), realm, id, email); err != nil {
+			log.Println("verification email:", err)
+		}
+	}()
+}
+
+// VerifyEmail marca como verificado el email de la cuenta del enlace
+func VerifyEmail(c *fiber.Ctx) error {
+	claims, err := utils.ParseVerificationToken(c.Query("token"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"statusCode": 400,
+			"message":    "Invalid or expired token",
+		})
+	}
+
+	id, err := primitive.ObjectIDFromHex(claims.Subject)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"statusCode": 400,
+			"message":    "Invalid or expired token",
+		})
+	}
+
+	// El email tiene que ser el mismo que cuando se envió el enlace
+	res, err := realmCollection(claims.Realm).UpdateOne(c.Context(),
+		bson.M{"_id": id, "email": claims.Email},
+		bson.M{"$set": bson.M{"email_verified": true}},
+	)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"statusCode": 500,
+			"message":    "Internal Server Error",
+		})
+	}
+	if res.MatchedCount == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"statusCode": 400,
+			"message":    "Invalid or expired token",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"statusCode": 200,
+		"message":    "Email verified successfully",
+	})
+}
+
+// ResendVerification vuelve a enviar el enlace de verificación.
+// Responde lo mismo exista o no la cuenta para no revelar qué emails hay registrados.
+func ResendVerification(c *fiber.Ctx) error {
+	var body struct {
+		Email string `json:"email"`
+		Realm string `json:"realm"`
+	}
+	if err := c.BodyParser(&body); err != nil || body.Email == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"statusCode": 400,
+			"message":    "Invalid request body",
+		})
+	}
+	if body.Realm == "" {
+		body.Realm = models.RealmUsers
+	}
+	if body.Realm != models.RealmUsers && body.Realm != models.RealmCustomers {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"statusCode": 400,
+			"message":    "Invalid realm",
+		})
+	}
+
+	response := fiber.Map{
+		"statusCode": 200,
+		"message":    "If the account exists and is not verified you will receive a new link",
+	}
+
+	var account struct {
+		ID                 primitive.ObjectID `bson:"_id"`
+		EmailVerified      *bool              `bson:"email_verified"`
+		VerificationSentAt *time.Time         `bson:"verification_sent_at"`
+	}
+	err := realmCollection(body.Realm).FindOne(c.Context(), bson.M{"email": body.Email}).Decode(&account)
+	if err != nil {
+		if err != mongo.ErrNoDocuments {
+			log.Println("resend verification:", err)
+		}
+		return c.Status(fiber.StatusOK).JSON(response)
+	}
+
+	if account.EmailVerified == nil || *account.EmailVerified {
+		return c.Status(fiber.StatusOK).JSON(response)
+	}
+	if account.VerificationSentAt != nil && time.Since(*account.VerificationSentAt) < verificationResendInterval {
+		return c.Status(fiber.StatusOK).JSON(response)
+	}
+
+	sendVerificationEmailAsync(body.Realm, account.ID, body.Email)
+
+	return c.Status(fiber.StatusOK).JSON(response)
+}
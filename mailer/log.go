@@ -0,0 +1,44 @@
+package mailer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// LogMailer no envía nada: escribe los correos en el log o, si Dir está
+// configurado, como ficheros .eml. Pensado para desarrollo y tests.
+type LogMailer struct {
+	Dir string
+
+	mu   sync.Mutex
+	sent []Message
+}
+
+func (m *LogMailer) Send(msg Message) error {
+	m.mu.Lock()
+	m.sent = append(m.sent, msg)
+	m.mu.Unlock()
+
+	if m.Dir == "" {
+		log.Printf("mail to %s: %s\n%s", msg.To, msg.Subject, msg.Body)
+		return nil
+	}
+
+	if err := os.MkdirAll(m.Dir, 0755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), msg.To)
+	return ioutil.WriteFile(filepath.Join(m.Dir, name), format("", msg), 0644)
+}
+
+// Messages devuelve los correos enviados hasta ahora
+func (m *LogMailer) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Message(nil), m.sent...)
+}
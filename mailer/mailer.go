@@ -0,0 +1,40 @@
+package mailer
+
+import (
+	"log"
+	"main/config"
+)
+
+// Message es un correo de texto plano
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer envía correos
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// Default es el Mailer que usa la API
+var Default Mailer = &LogMailer{}
+
+// FromConfig crea el Mailer según MAIL_DRIVER: "smtp" o "log" (por defecto)
+func FromConfig() Mailer {
+	switch config.Config("MAIL_DRIVER") {
+	case "smtp":
+		return &SMTPMailer{
+			Host:     config.Config("SMTP_HOST"),
+			Port:     config.Config("SMTP_PORT"),
+			Username: config.Config("SMTP_USERNAME"),
+			Password: config.Config("SMTP_PASSWORD"),
+			From:     config.Config("MAIL_FROM"),
+		}
+	case "", "log":
+		return &LogMailer{Dir: config.Config("MAIL_DIR")}
+	default:
+		log.Printf("Unknown MAIL_DRIVER %q, using log mailer", config.Config("MAIL_DRIVER"))
+		return &LogMailer{Dir: config.Config("MAIL_DIR")}
+	}
+}
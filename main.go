@@ -1,9 +1,19 @@
 package main
 
 import (
+	"context"
 	"log"
+	"main/config"
 	"main/database"
+	"main/events"
+	"main/handlers"
+	"main/mailer"
+	"main/migrations"
+	"main/models"
 	"main/routes"
+	"main/search"
+	"main/utils"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
@@ -15,6 +25,75 @@ func main() {
 		log.Fatal(err)
 	}
 
+	// Moneda base de los precios, la usan también las migraciones
+	if err := models.SetBaseCurrency(config.Config("BASE_CURRENCY")); err != nil {
+		log.Fatal(err)
+	}
+
+	// Migraciones de datos pendientes, antes de los índices: dejan los datos listos para
+	// los índices únicos
+	migrated, err := migrations.Run(context.Background())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Índices de la BD
+	if err := database.EnsureIndexes(context.Background(), utils.RefreshTokenDuration); err != nil {
+		log.Fatal(err)
+	}
+
+	// Índice de búsqueda de productos, se reconstruye si las migraciones han cambiado datos
+	if err := search.OpenProducts(context.Background()); err != nil {
+		log.Fatal(err)
+	}
+	if migrated > 0 {
+		if err := search.RebuildProducts(context.Background()); err != nil {
+			log.Fatal(err)
+		}
+	}
+	refresh := 5 * time.Minute
+	if value := config.Config("SEARCH_REFRESH_INTERVAL"); value != "" {
+		if refresh, err = time.ParseDuration(value); err != nil || refresh <= 0 {
+			log.Fatal("invalid SEARCH_REFRESH_INTERVAL: ", value)
+		}
+	}
+	search.StartRefresh(refresh)
+
+	// Conectar a Redis (opcional)
+	if addr := config.Config("REDIS_ADDR"); addr != "" {
+		utils.Cache = utils.NewRedis(addr, config.Config("REDIS_PASSWORD"))
+	}
+
+	// Algoritmo de hash de contraseñas
+	if err := utils.LoadPasswordHasher(); err != nil {
+		log.Fatal(err)
+	}
+
+	// Política de contraseñas
+	if err := utils.LoadPasswordPolicy(); err != nil {
+		log.Fatal(err)
+	}
+
+	// Mailer para los correos de la API
+	mailer.Default = mailer.FromConfig()
+
+	// Suscriptores de eventos
+	events.Subscribe(events.LowStock, handlers.NotifyLowStock)
+
+	// Precios programados
+	interval := time.Minute
+	if value := config.Config("PRICE_SCHEDULER_INTERVAL"); value != "" {
+		if interval, err = time.ParseDuration(value); err != nil || interval <= 0 {
+			log.Fatal("invalid PRICE_SCHEDULER_INTERVAL: ", value)
+		}
+	}
+	handlers.StartPriceScheduler(interval)
+
+	// Cargar las claves de firma de los JWT
+	if err := utils.LoadKeys(); err != nil {
+		log.Fatal(err)
+	}
+
 	// Fiber app
 	app := fiber.New()
 	app.Use(cors.New())
@@ -0,0 +1,159 @@
+package middleware
+
+import (
+	"main/database"
+	"main/models"
+	"main/utils"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Config define la configuración del middleware de autenticación
+type Config struct {
+	// Public contiene las rutas que no requieren token, por ejemplo
+	// "/api/auth/login". Se puede limitar a un método ("POST /api/customers")
+	// y un "*" final acepta cualquier ruta con ese prefijo.
+	Public []string
+	// Audience de los tokens aceptados. Por defecto los del staff (models.AudienceStaff).
+	Audience string
+	// APIKeys son las rutas en las que también se aceptan claves de API en la cabecera
+	// X-API-Key, con el mismo formato que Public. En el resto solo valen los tokens.
+	APIKeys []string
+}
+
+// Protected valida el token Bearer y guarda los claims en c.Locals("user")
+func Protected(config Config) fiber.Handler {
+	if config.Audience == "" {
+		config.Audience = models.AudienceStaff
+	}
+
+	return func(c *fiber.Ctx) error {
+		if matchRoute(c, config.Public) {
+			return c.Next()
+		}
+
+		if apiKey := c.Get("X-API-Key"); apiKey != "" && matchRoute(c, config.APIKeys) {
+			return authenticateAPIKey(c, apiKey)
+		}
+
+		authHeader := c.Get("Authorization")
+		if len(authHeader) < 7 || authHeader[:7] != "Bearer " {
+			e := models.Error{Message: "Invalid authorization header", StatusCode: 401}
+			return c.Status(401).JSON(e)
+		}
+		tokenString := authHeader[7:]
+
+		token, err := utils.ParseToken(tokenString, config.Audience)
+		if err != nil || !token.Valid {
+			e := models.Error{Message: "Unauthorized", StatusCode: 401}
+			return c.Status(401).JSON(e)
+		}
+
+		claims := token.Claims.(*models.Claims)
+
+		// Rechazar los tokens revocados por Logout
+		revoked, err := utils.IsTokenRevoked(c.Context(), claims.ID)
+		if err != nil {
+			e := models.Error{Message: "Internal Server Error", StatusCode: 500}
+			return c.Status(500).JSON(e)
+		}
+		if revoked {
+			e := models.Error{Message: "Token revoked", StatusCode: 401}
+			return c.Status(401).JSON(e)
+		}
+
+		// El token tiene que seguir guardado en la colección jwt (Login lo inserta, Logout lo borra)
+		var jwtToken models.JWTToken
+		err = database.Mg.Db.Collection("jwt").FindOne(c.Context(), bson.M{"token": tokenString}).Decode(&jwtToken)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				e := models.Error{Message: "Unauthorized", StatusCode: 401}
+				return c.Status(401).JSON(e)
+			}
+			e := models.Error{Message: "Internal Server Error", StatusCode: 500}
+			return c.Status(500).JSON(e)
+		}
+
+		// Actualizar el último uso de la sesión, como mucho una vez por minuto
+		if jwtToken.FamilyID != "" {
+			now := time.Now()
+			database.Mg.Db.Collection("sessions").UpdateOne(c.Context(),
+				bson.M{"family_id": jwtToken.FamilyID, "last_used_at": bson.M{"$lt": now.Add(-time.Minute)}},
+				bson.M{"$set": bson.M{"last_used_at": now, "ip": c.IP()}},
+			)
+		}
+
+		c.Locals("user", claims)
+		c.Locals("token", tokenString)
+		c.Locals("session", jwtToken.FamilyID)
+		return c.Next()
+	}
+}
+
+// authenticateAPIKey valida la clave de API y guarda sus scopes en c.Locals("user")
+func authenticateAPIKey(c *fiber.Ctx, apiKey string) error {
+	collection := database.Mg.Db.Collection("api_keys")
+
+	var key models.APIKey
+	err := collection.FindOne(c.Context(), bson.M{"key_hash": utils.HashToken(apiKey)}).Decode(&key)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			e := models.Error{Message: "Unauthorized", StatusCode: 401}
+			return c.Status(401).JSON(e)
+		}
+		e := models.Error{Message: "Internal Server Error", StatusCode: 500}
+		return c.Status(500).JSON(e)
+	}
+
+	now := time.Now()
+	if !key.IsActive(now) {
+		e := models.Error{Message: "Unauthorized", StatusCode: 401}
+		return c.Status(401).JSON(e)
+	}
+
+	// Actualizar el último uso, como mucho una vez por minuto
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) > time.Minute {
+		collection.UpdateOne(c.Context(), bson.M{"_id": key.ID}, bson.M{"$set": bson.M{"last_used_at": now}})
+	}
+
+	c.Locals("user", &models.Claims{
+		APIKeyID: key.ID.Hex(),
+		Scopes:   key.Scopes,
+	})
+	return c.Next()
+}
+
+// CurrentUser devuelve los claims del usuario autenticado
+func CurrentUser(c *fiber.Ctx) *models.Claims {
+	claims, _ := c.Locals("user").(*models.Claims)
+	return claims
+}
+
+// matchRoute indica si la petición es de alguna de las rutas: "/api/auth/login",
+// "POST /api/customers" o "/api/files/imgs/*"
+func matchRoute(c *fiber.Ctx, routes []string) bool {
+	path := strings.TrimSuffix(c.Path(), "/")
+	for _, entry := range routes {
+		method := ""
+		if i := strings.Index(entry, " "); i > 0 {
+			method, entry = entry[:i], entry[i+1:]
+		}
+		if method != "" && method != c.Method() {
+			continue
+		}
+		if strings.HasSuffix(entry, "*") {
+			if strings.HasPrefix(path, strings.TrimSuffix(entry, "*")) {
+				return true
+			}
+			continue
+		}
+		if path == strings.TrimSuffix(entry, "/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+package middleware
+
+import (
+	"io/ioutil"
+	"main/database"
+	"main/models"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestMatchRoute(t *testing.T) {
+	routes := []string{"/api/auth/login", "POST /api/customers", "/api/products/*"}
+	tests := []struct {
+		method, path string
+		want         bool
+	}{
+		{"POST", "/api/auth/login", true},
+		{"POST", "/api/auth/login/", true},
+		{"POST", "/api/auth/logout", false},
+		{"POST", "/api/customers", true},
+		{"GET", "/api/customers", false},
+		{"GET", "/api/products/123/stock", true},
+		{"GET", "/api/productsx", false},
+	}
+
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		if matchRoute(c, routes) {
+			return c.SendStatus(200)
+		}
+		return c.SendStatus(404)
+	})
+	for _, tt := range tests {
+		resp, err := app.Test(httptest.NewRequest(tt.method, tt.path, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := resp.StatusCode == 200; got != tt.want {
+			t.Errorf("%s %s: match = %v, want %v", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestProtectedAPIKeys(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("api key routes", func(mt *mtest.T) {
+		database.Mg.Db = mt.DB
+		keyID := primitive.NewObjectID()
+
+		app := fiber.New()
+		app.Use(Protected(Config{APIKeys: []string{"/api/products/*"}}))
+		app.Get("/*", func(c *fiber.Ctx) error {
+			return c.SendString(CurrentUser(c).APIKeyID)
+		})
+
+		// Fuera de las rutas de APIKeys la clave no cuenta y hace falta un token
+		req := httptest.NewRequest("GET", "/api/auth/sessions", nil)
+		req.Header.Set("X-API-Key", "secret")
+		resp, err := app.Test(req)
+		if err != nil {
+			mt.Fatal(err)
+		}
+		if resp.StatusCode != 401 {
+			mt.Fatalf("status = %d, want 401", resp.StatusCode)
+		}
+
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, mt.DB.Name()+".api_keys", mtest.FirstBatch, bson.D{
+				{Key: "_id", Value: keyID},
+				{Key: "key_hash", Value: "hash"},
+				{Key: "scopes", Value: bson.A{models.PermProductsRead}},
+			}),
+			mtest.CreateSuccessResponse(),
+		)
+		req = httptest.NewRequest("GET", "/api/products/123", nil)
+		req.Header.Set("X-API-Key", "secret")
+		resp, err = app.Test(req)
+		if err != nil {
+			mt.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		if resp.StatusCode != 200 || string(body) != keyID.Hex() {
+			mt.Fatalf("status = %d, body = %q, want 200 and the key ID", resp.StatusCode, body)
+		}
+	})
+}
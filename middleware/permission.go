@@ -0,0 +1,39 @@
+package middleware
+
+import (
+	"main/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Permission exige que el rol del usuario autenticado tenga el permiso indicado
+func Permission(permission string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		claims := CurrentUser(c)
+		if claims != nil && claims.Scope == models.ScopeUnverified {
+			e := models.Error{Message: "Email not verified", StatusCode: 403}
+			return c.Status(403).JSON(e)
+		}
+		if claims != nil && claims.APIKeyID != "" {
+			if !hasScope(claims.Scopes, permission) {
+				e := models.Error{Message: "Forbidden", StatusCode: 403}
+				return c.Status(403).JSON(e)
+			}
+			return c.Next()
+		}
+		if claims == nil || !models.HasPermission(claims.Role, permission) {
+			e := models.Error{Message: "Forbidden", StatusCode: 403}
+			return c.Status(403).JSON(e)
+		}
+		return c.Next()
+	}
+}
+
+func hasScope(scopes []string, permission string) bool {
+	for _, scope := range scopes {
+		if scope == permission {
+			return true
+		}
+	}
+	return false
+}
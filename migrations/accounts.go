@@ -0,0 +1,56 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"main/database"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// uniqueEmails comprueba que no haya emails repetidos en users ni en customers antes de
+// crear sus índices únicos. Hasta ahora no se impedía, y no se puede decidir aquí con
+// qué cuenta se queda cada email: si hay repetidos la migración falla con la lista y
+// no se marca como aplicada, para que se resuelvan a mano y se vuelva a arrancar.
+func uniqueEmails(ctx context.Context) error {
+	problems := make([]string, 0)
+	for _, name := range []string{"users", "customers"} {
+		pipeline := mongo.Pipeline{
+			{{Key: "$match", Value: bson.M{"email": bson.M{"$type": "string"}}}},
+			{{Key: "$group", Value: bson.M{"_id": "$email", "ids": bson.M{"$push": "$_id"}, "count": bson.M{"$sum": 1}}}},
+			{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+			{{Key: "$sort", Value: bson.M{"_id": 1}}},
+		}
+		cursor, err := database.Mg.Db.Collection(name).Aggregate(ctx, pipeline)
+		if err != nil {
+			return err
+		}
+		var groups []struct {
+			Email string        `bson:"_id"`
+			IDs   []interface{} `bson:"ids"`
+		}
+		if err := cursor.All(ctx, &groups); err != nil {
+			return err
+		}
+
+		for _, g := range groups {
+			ids := make([]string, 0, len(g.IDs))
+			for _, id := range g.IDs {
+				if oid, ok := id.(primitive.ObjectID); ok {
+					ids = append(ids, oid.Hex())
+				} else {
+					ids = append(ids, fmt.Sprint(id))
+				}
+			}
+			problems = append(problems, fmt.Sprintf("%s %s (%s)", name, g.Email, strings.Join(ids, ", ")))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("duplicate emails, change or merge these accounts before starting the API: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
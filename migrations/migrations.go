@@ -0,0 +1,53 @@
+// Package migrations aplica al arrancar los cambios de datos que necesitan las nuevas
+// versiones de la API. Cada migración se aplica una sola vez y queda registrada en la
+// colección migrations.
+package migrations
+
+import (
+	"context"
+	"log"
+	"main/database"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Migration es un cambio de datos con un nombre único
+type Migration struct {
+	Name string
+	Up   func(ctx context.Context) error
+}
+
+// all son las migraciones en el orden en que se aplican. Las nuevas van al final.
+var all = []Migration{
+	{Name: "001_product_slugs", Up: productSlugs},
+	{Name: "002_product_categories", Up: productCategories},
+	{Name: "003_product_money_prices", Up: productMoneyPrices},
+	{Name: "004_unique_emails", Up: uniqueEmails},
+}
+
+// Run aplica las migraciones que no se han aplicado todavía y devuelve cuántas ha aplicado
+func Run(ctx context.Context) (int, error) {
+	collection := database.Mg.Db.Collection("migrations")
+	applied := 0
+	for _, m := range all {
+		err := collection.FindOne(ctx, bson.M{"_id": m.Name}).Err()
+		if err == nil {
+			continue
+		}
+		if err != mongo.ErrNoDocuments {
+			return applied, err
+		}
+
+		log.Println("migration:", m.Name)
+		if err := m.Up(ctx); err != nil {
+			return applied, err
+		}
+		if _, err := collection.InsertOne(ctx, bson.M{"_id": m.Name, "applied_at": time.Now()}); err != nil {
+			return applied, err
+		}
+		applied++
+	}
+	return applied, nil
+}
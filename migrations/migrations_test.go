@@ -0,0 +1,146 @@
+package migrations
+
+import (
+	"context"
+	"main/database"
+	"main/models"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// Sin servidor, las respuestas de la BD se simulan en el orden en que las piden las migraciones
+func TestRunBaselineProducts(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("float price", func(mt *mtest.T) {
+		database.Mg.Db = mt.DB
+		ns := mt.DB.Name() + ".Products"
+		empty := func() bson.D { return mtest.CreateCursorResponse(0, ns, mtest.FirstBatch) }
+		productID := primitive.NewObjectID()
+
+		// Producto con la forma de la versión inicial: sin slug ni categoría gestionada y con el precio en float
+		baseline := bson.D{
+			{Key: "_id", Value: productID},
+			{Key: "name", Value: "Camión Rojo"},
+			{Key: "category", Value: "Toys"},
+			{Key: "image", Value: ""},
+			{Key: "description", Value: "Un camión"},
+			{Key: "price", Value: 19.99},
+			{Key: "show", Value: true},
+		}
+
+		mt.AddMockResponses(
+			// 001_product_slugs
+			empty(),
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, baseline),
+			empty(),
+			mtest.CreateSuccessResponse(),
+			mtest.CreateSuccessResponse(),
+			// 002_product_categories
+			empty(),
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, bson.D{{Key: "_id", Value: "Toys"}, {Key: "count", Value: 1}}),
+			empty(),
+			mtest.CreateSuccessResponse(),
+			mtest.CreateSuccessResponse(),
+			mtest.CreateSuccessResponse(),
+			// 003_product_money_prices
+			empty(),
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, baseline),
+			mtest.CreateSuccessResponse(),
+			empty(),
+			mtest.CreateSuccessResponse(),
+			// 004_unique_emails
+			empty(),
+			empty(),
+			empty(),
+			mtest.CreateSuccessResponse(),
+		)
+
+		applied, err := Run(context.Background())
+		if err != nil {
+			mt.Fatalf("Run: %v", err)
+		}
+		if applied != len(all) {
+			mt.Fatalf("applied %d migrations, want %d", applied, len(all))
+		}
+
+		// El slug sale del nombre y el precio pasa a céntimos de la moneda base
+		var slugSet, priceSet bson.Raw
+		for _, event := range mt.GetAllStartedEvents() {
+			if event.CommandName != "update" {
+				continue
+			}
+			set := event.Command.Lookup("updates", "0", "u", "$set").Document()
+			if v, err := set.LookupErr("slug"); err == nil {
+				slugSet = set
+				if v.StringValue() != "camion-rojo" {
+					mt.Errorf("slug = %q, want camion-rojo", v.StringValue())
+				}
+			}
+			if v, err := set.LookupErr("price"); err == nil {
+				priceSet = set
+				var price models.Money
+				if err := v.Unmarshal(&price); err != nil {
+					mt.Fatal(err)
+				}
+				if want := (models.Money{Amount: 1999, Currency: models.BaseCurrency}); price != want {
+					mt.Errorf("price = %v, want %v", price, want)
+				}
+			}
+		}
+		if slugSet == nil || priceSet == nil {
+			mt.Fatalf("missing slug or price update")
+		}
+	})
+}
+
+func TestRunDuplicateEmails(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("duplicates", func(mt *mtest.T) {
+		database.Mg.Db = mt.DB
+		ns := mt.DB.Name() + ".migrations"
+		applied := func(name string) bson.D {
+			return mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, bson.D{{Key: "_id", Value: name}})
+		}
+		first, second := primitive.NewObjectID(), primitive.NewObjectID()
+		mt.AddMockResponses(
+			applied("001_product_slugs"),
+			applied("002_product_categories"),
+			applied("003_product_money_prices"),
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch),
+			mtest.CreateCursorResponse(0, mt.DB.Name()+".users", mtest.FirstBatch),
+			mtest.CreateCursorResponse(0, mt.DB.Name()+".customers", mtest.FirstBatch, bson.D{
+				{Key: "_id", Value: "ana@example.com"},
+				{Key: "ids", Value: bson.A{first, second}},
+				{Key: "count", Value: 2},
+			}),
+		)
+
+		count, err := Run(context.Background())
+		if err == nil {
+			mt.Fatal("Run succeeded with duplicate emails")
+		}
+		// El error dice qué cuentas hay que resolver
+		for _, want := range []string{"customers", "ana@example.com", first.Hex(), second.Hex()} {
+			if !strings.Contains(err.Error(), want) {
+				mt.Errorf("error %q does not mention %s", err, want)
+			}
+		}
+		if count != 0 {
+			mt.Errorf("applied %d migrations, want 0", count)
+		}
+		// y la migración no queda registrada, se vuelve a comprobar en el siguiente arranque
+		for _, event := range mt.GetAllStartedEvents() {
+			if event.CommandName == "insert" {
+				mt.Errorf("migration recorded: %v", event.Command)
+			}
+		}
+	})
+}
@@ -0,0 +1,133 @@
+package migrations
+
+import (
+	"context"
+	"main/database"
+	"main/models"
+	"main/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// productSlugs genera el slug de los productos creados antes de que existiera
+func productSlugs(ctx context.Context) error {
+	collection := database.Mg.Db.Collection("Products")
+	// Solo se leen los campos que usa: el resto de campos puede tener todavía la forma
+	// de versiones anteriores, como el precio en float antes de la 003
+	cursor, err := collection.Find(ctx, bson.M{"slug": bson.M{"$in": bson.A{nil, ""}}},
+		options.Find().SetProjection(bson.M{"_id": 1, "name": 1}),
+	)
+	if err != nil {
+		return err
+	}
+	var products []struct {
+		ID   primitive.ObjectID `bson:"_id"`
+		Name string             `bson:"name"`
+	}
+	if err := cursor.All(ctx, &products); err != nil {
+		return err
+	}
+
+	for _, product := range products {
+		slug, err := utils.UniqueSlug(ctx, collection, product.Name, product.ID)
+		if err != nil {
+			return err
+		}
+		if _, err := collection.UpdateOne(ctx, bson.M{"_id": product.ID}, bson.M{"$set": bson.M{"slug": slug}}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// productCategories convierte los nombres de categoría de los productos en documentos
+// de la colección categories. Los nombres con el mismo slug ("Shoes" y "shoes") van a
+// la misma categoría, con el nombre que más productos usan.
+func productCategories(ctx context.Context) error {
+	products := database.Mg.Db.Collection("Products")
+	categories := database.Mg.Db.Collection("categories")
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"category_id": nil, "category": bson.M{"$nin": bson.A{nil, ""}}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$category", "count": bson.M{"$sum": 1}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}}},
+	}
+	cursor, err := products.Aggregate(ctx, pipeline)
+	if err != nil {
+		return err
+	}
+	var groups []struct {
+		Name string `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return err
+	}
+
+	// Nombres de cada slug, el primero es el más usado
+	names := make(map[string][]string)
+	slugs := make([]string, 0)
+	for _, g := range groups {
+		slug := utils.Slugify(g.Name)
+		if slug == "" {
+			continue
+		}
+		if _, ok := names[slug]; !ok {
+			slugs = append(slugs, slug)
+		}
+		names[slug] = append(names[slug], g.Name)
+	}
+
+	for _, slug := range slugs {
+		var category models.Category
+		err := categories.FindOne(ctx, bson.M{"slug": slug}).Decode(&category)
+		if err == mongo.ErrNoDocuments {
+			category = models.Category{Name: names[slug][0], Slug: slug}
+			res, err := categories.InsertOne(ctx, category)
+			if err != nil {
+				return err
+			}
+			category.ID = res.InsertedID.(primitive.ObjectID)
+		} else if err != nil {
+			return err
+		}
+
+		_, err = products.UpdateMany(ctx,
+			bson.M{"category_id": nil, "category": bson.M{"$in": names[slug]}},
+			bson.M{"$set": bson.M{"category_id": category.ID, "category": category.Name}},
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// productMoneyPrices convierte los precios float de productos y variantes en importes
+// exactos en unidades menores de la moneda base
+func productMoneyPrices(ctx context.Context) error {
+	for _, name := range []string{"Products", "variants"} {
+		collection := database.Mg.Db.Collection(name)
+		cursor, err := collection.Find(ctx, bson.M{"price": bson.M{"$type": "number"}})
+		if err != nil {
+			return err
+		}
+		var docs []struct {
+			ID    primitive.ObjectID `bson:"_id"`
+			Price float64            `bson:"price"`
+		}
+		if err := cursor.All(ctx, &docs); err != nil {
+			return err
+		}
+
+		for _, doc := range docs {
+			price := models.MoneyFromFloat(doc.Price, models.BaseCurrency)
+			if _, err := collection.UpdateOne(ctx, bson.M{"_id": doc.ID}, bson.M{"$set": bson.M{"price": price}}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// Category es una categoría de productos. Las categorías forman un árbol con ParentID.
+type Category struct {
+	ID        primitive.ObjectID  `json:"id" bson:"_id,omitempty"`
+	Name      string              `json:"name" bson:"name"`
+	Slug      string              `json:"slug" bson:"slug"`
+	ParentID  *primitive.ObjectID `json:"parent_id" bson:"parent_id"`
+	SortOrder int                 `json:"sort_order" bson:"sort_order"`
+	Image     string              `json:"image,omitempty" bson:"image,omitempty"`
+}
+
+// CategoryNode es una categoría del árbol con sus subcategorías. ProductCount cuenta
+// los productos de la categoría y TotalCount también los de sus subcategorías.
+type CategoryNode struct {
+	Category     `bson:",inline"`
+	ProductCount int             `json:"product_count"`
+	TotalCount   int             `json:"total_count"`
+	Children     []*CategoryNode `json:"children"`
+}
@@ -1,5 +1,7 @@
 package models
 
+import "time"
+
 type Customer struct {
 	ID          string `json:"id,omitempty" bson:"_id,omitempty"`
 	Name        string `json:"name,omitempty" bson:"name,omitempty"`
@@ -7,4 +9,12 @@ type Customer struct {
 	Email       string `json:"email,omitempty" bson:"email,omitempty"`
 	Phone       string `json:"phone,omitempty" bson:"phone,omitempty"`
 	AffiliateID string `json:"affiliate_id,omitempty" bson:"affiliate_id,omitempty"`
+	// nil en las cuentas creadas antes de la verificación de email
+	EmailVerified      *bool      `json:"email_verified,omitempty" bson:"email_verified,omitempty"`
+	VerificationSentAt *time.Time `json:"-" bson:"verification_sent_at,omitempty"`
+}
+
+// IsEmailVerified indica si el cliente verificó su email
+func (c *Customer) IsEmailVerified() bool {
+	return c.EmailVerified == nil || *c.EmailVerified
 }
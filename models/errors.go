@@ -5,3 +5,17 @@ type Error struct {
 	Message    string `json:"message"`
 	StatusCode int    `json:"statusCode"`
 }
+
+// FieldError es el detalle de un campo que no pasó la validación
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ValidationError es un error con el detalle de cada campo
+type ValidationError struct {
+	Message    string       `json:"message"`
+	StatusCode int          `json:"statusCode"`
+	Errors     []FieldError `json:"errors"`
+}
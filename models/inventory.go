@@ -0,0 +1,55 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Tipos de movimiento de stock
+const (
+	MovementReceipt    = "receipt"
+	MovementSale       = "sale"
+	MovementAdjustment = "adjustment"
+	MovementReturn     = "return"
+)
+
+// Almacén de los movimientos que no indican ninguno
+const DefaultWarehouse = "default"
+
+// StockLevel es el stock de un producto, o de una de sus variantes, en un almacén
+type StockLevel struct {
+	ID        primitive.ObjectID  `json:"-" bson:"_id,omitempty"`
+	ProductID primitive.ObjectID  `json:"product_id" bson:"product_id"`
+	VariantID *primitive.ObjectID `json:"variant_id,omitempty" bson:"variant_id"`
+	Warehouse string              `json:"warehouse" bson:"warehouse"`
+	Quantity  int                 `json:"quantity" bson:"quantity"`
+	UpdatedAt time.Time           `json:"updated_at" bson:"updated_at"`
+}
+
+// StockMovement es una entrada del registro de movimientos de stock. El registro solo
+// crece: los errores se corrigen con un movimiento de ajuste, nunca editando otro.
+type StockMovement struct {
+	ID        primitive.ObjectID  `json:"id" bson:"_id,omitempty"`
+	ProductID primitive.ObjectID  `json:"product_id" bson:"product_id"`
+	VariantID *primitive.ObjectID `json:"variant_id,omitempty" bson:"variant_id,omitempty"`
+	Warehouse string              `json:"warehouse" bson:"warehouse"`
+	Type      string              `json:"type" bson:"type"`
+	// Quantity es el cambio de stock, negativo en las salidas
+	Quantity int `json:"quantity" bson:"quantity"`
+	// Balance es el stock del almacén después del movimiento
+	Balance   int       `json:"balance" bson:"balance"`
+	Reason    string    `json:"reason" bson:"reason"`
+	ActorID   string    `json:"actor_id" bson:"actor_id"`
+	ActorType string    `json:"actor_type" bson:"actor_type"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
+
+// IsValidMovementType comprueba que el tipo de movimiento exista
+func IsValidMovementType(movementType string) bool {
+	switch movementType {
+	case MovementReceipt, MovementSale, MovementAdjustment, MovementReturn:
+		return true
+	}
+	return false
+}
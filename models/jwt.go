@@ -1,8 +1,69 @@
 package models
 
-import "go.mongodb.org/mongo-driver/bson/primitive"
+import (
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
 
 type JWTToken struct {
 	ID    primitive.ObjectID `bson:"_id,omitempty"`
 	Token string             `bson:"token"`
+	JTI   string             `bson:"jti,omitempty"`
+	// UserID es el ID del usuario o del cliente según Realm
+	UserID    primitive.ObjectID `bson:"user_id,omitempty"`
+	Realm     string             `bson:"realm,omitempty"`
+	FamilyID  string             `bson:"family_id,omitempty"`
+	ExpiresAt time.Time          `bson:"expires_at,omitempty"`
+}
+
+// RefreshToken de un solo uso. Solo se guarda el hash del token y todos los
+// tokens que salen del mismo Login comparten FamilyID.
+type RefreshToken struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	TokenHash string             `bson:"token_hash"`
+	FamilyID  string             `bson:"family_id"`
+	UserID    primitive.ObjectID `bson:"user_id"`
+	// Vacío en los refresh tokens de usuarios anteriores al realm de clientes
+	Realm     string     `bson:"realm,omitempty"`
+	CreatedAt time.Time  `bson:"created_at"`
+	ExpiresAt time.Time  `bson:"expires_at"`
+	UsedAt    *time.Time `bson:"used_at"`
+	Revoked   bool       `bson:"revoked"`
+}
+
+// Scope de los tokens de usuarios que todavía no verificaron su email
+const ScopeUnverified = "unverified"
+
+// Claims guardados en el token JWT
+type Claims struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+	Scope string `json:"scope,omitempty"`
+	// Solo en las peticiones autenticadas con X-API-Key, nunca van en un JWT
+	APIKeyID string   `json:"-"`
+	Scopes   []string `json:"-"`
+	jwt.RegisteredClaims
+}
+
+// Realms de las cuentas
+const (
+	RealmUsers     = "users"
+	RealmCustomers = "customers"
+)
+
+// Emisor y audiencia de los tokens de acceso de cada realm
+const (
+	IssuerStaff       = "golang-api/staff"
+	IssuerCustomers   = "golang-api/customers"
+	AudienceStaff     = "staff"
+	AudienceCustomers = "customers"
+)
+
+// VerificationClaims guardados en el enlace de verificación de email
+type VerificationClaims struct {
+	Email string `json:"email"`
+	Realm string `json:"realm"`
+	jwt.RegisteredClaims
 }
@@ -0,0 +1,129 @@
+package models
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Money es una cantidad de dinero exacta: Amount son unidades menores de la moneda
+// (céntimos en EUR, yenes en JPY) y Currency el código ISO 4217
+type Money struct {
+	Amount   int64  `json:"amount" bson:"amount"`
+	Currency string `json:"currency" bson:"currency"`
+}
+
+// Decimales de las monedas admitidas
+var currencyExponents = map[string]int{
+	"ARS": 2, "AUD": 2, "BRL": 2, "CAD": 2, "CHF": 2, "CLP": 0, "CNY": 2, "COP": 2,
+	"CZK": 2, "DKK": 2, "EUR": 2, "GBP": 2, "HKD": 2, "INR": 2, "JPY": 0, "KRW": 0,
+	"KWD": 3, "MXN": 2, "NOK": 2, "NZD": 2, "PEN": 2, "PLN": 2, "SEK": 2, "USD": 2,
+	"UYU": 2,
+}
+
+// Importe decimal con signo opcional: "19.99", "-5", "0.5"
+var decimalPattern = regexp.MustCompile(`^[+-]?[0-9]+(\.[0-9]+)?$`)
+
+// BaseCurrency es la moneda del precio principal de los productos. Los filtros y el
+// orden por precio usan este precio.
+var BaseCurrency = "EUR"
+
+// SetBaseCurrency cambia la moneda base, vacío deja la de por defecto
+func SetBaseCurrency(code string) error {
+	if code == "" {
+		return nil
+	}
+	code = strings.ToUpper(code)
+	if !IsValidCurrency(code) {
+		return fmt.Errorf("unsupported base currency %s", code)
+	}
+	BaseCurrency = code
+	return nil
+}
+
+// IsValidCurrency comprueba que la moneda esté admitida
+func IsValidCurrency(code string) bool {
+	_, ok := currencyExponents[code]
+	return ok
+}
+
+// CurrencyExponent devuelve los decimales de la moneda
+func CurrencyExponent(code string) int {
+	return currencyExponents[code]
+}
+
+// ParseAmount convierte un importe decimal ("19.99") en unidades menores de la moneda
+// sin pasar por float. Falla si tiene más decimales de los que admite la moneda.
+func ParseAmount(value string, currency string) (int64, error) {
+	// big.Rat también acepta fracciones ("1/3") y exponentes ("1e2"), que no son importes
+	if !decimalPattern.MatchString(value) {
+		return 0, fmt.Errorf("invalid amount %q", value)
+	}
+	r, ok := new(big.Rat).SetString(value)
+	if !ok {
+		return 0, fmt.Errorf("invalid amount %q", value)
+	}
+	r.Mul(r, new(big.Rat).SetInt(pow10(CurrencyExponent(currency))))
+	if !r.IsInt() {
+		return 0, fmt.Errorf("%s has more decimals than %s allows", value, currency)
+	}
+	if !r.Num().IsInt64() {
+		return 0, fmt.Errorf("amount %s is too large", value)
+	}
+	return r.Num().Int64(), nil
+}
+
+// MoneyFromFloat convierte un precio guardado como float, redondeando a las unidades
+// menores de la moneda
+func MoneyFromFloat(value float64, currency string) Money {
+	scale := math.Pow10(CurrencyExponent(currency))
+	return Money{Amount: int64(math.Round(value * scale)), Currency: currency}
+}
+
+// String devuelve el importe con sus decimales y la moneda: "19.99 EUR"
+func (m Money) String() string {
+	exp := CurrencyExponent(m.Currency)
+	return new(big.Rat).SetFrac(big.NewInt(m.Amount), pow10(exp)).FloatString(exp) + " " + m.Currency
+}
+
+// Convert pasa el importe a otra moneda. rate es cuántas unidades de currency vale una
+// unidad de m.Currency, como número decimal. Redondea a la unidad menor más cercana.
+func (m Money) Convert(currency string, rate string) (Money, error) {
+	r, ok := new(big.Rat).SetString(rate)
+	if !ok || r.Sign() <= 0 {
+		return Money{}, fmt.Errorf("invalid exchange rate %q", rate)
+	}
+
+	// amount * rate * 10^exp(currency) / 10^exp(m.Currency)
+	r.Mul(r, new(big.Rat).SetInt64(m.Amount))
+	r.Mul(r, new(big.Rat).SetFrac(pow10(CurrencyExponent(currency)), pow10(CurrencyExponent(m.Currency))))
+
+	// Redondeo a la unidad más cercana, las mitades se alejan del cero
+	num, den := new(big.Int).Abs(r.Num()), r.Denom()
+	q, rem := new(big.Int).QuoRem(num, den, new(big.Int))
+	if rem.Mul(rem, big.NewInt(2)).Cmp(den) >= 0 {
+		q.Add(q, big.NewInt(1))
+	}
+	if r.Sign() < 0 {
+		q.Neg(q)
+	}
+	if !q.IsInt64() {
+		return Money{}, fmt.Errorf("converted amount is too large")
+	}
+	return Money{Amount: q.Int64(), Currency: currency}, nil
+}
+
+func pow10(exp int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(exp)), nil)
+}
+
+// ExchangeRate es el cambio de la moneda base a Currency: una unidad de la moneda base
+// vale Rate unidades de Currency. Rate es un decimal en texto para no perder precisión.
+type ExchangeRate struct {
+	Currency  string    `json:"currency" bson:"_id"`
+	Rate      string    `json:"rate" bson:"rate"`
+	UpdatedAt time.Time `json:"updated_at" bson:"updated_at"`
+}
@@ -0,0 +1,139 @@
+package models
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		value    string
+		currency string
+		want     int64
+		ok       bool
+	}{
+		{"19.99", "EUR", 1999, true},
+		{"19.9", "EUR", 1990, true},
+		{"19", "EUR", 1900, true},
+		{"0.1", "EUR", 10, true},
+		{"-5.25", "EUR", -525, true},
+		{"+5", "EUR", 500, true},
+		{"1500", "JPY", 1500, true},
+		{"1.234", "KWD", 1234, true},
+		{"92233720368547758.07", "EUR", math.MaxInt64, true},
+		{"19.999", "EUR", 0, false},
+		{"1.5", "JPY", 0, false},
+		{"92233720368547758.08", "EUR", 0, false},
+		{"", "EUR", 0, false},
+		{"abc", "EUR", 0, false},
+		{"1/4", "EUR", 0, false},
+		{"1e2", "EUR", 0, false},
+		{".5", "EUR", 0, false},
+		{"5.", "EUR", 0, false},
+		{" 5", "EUR", 0, false},
+		{"1,50", "EUR", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseAmount(tt.value, tt.currency)
+		if tt.ok && (err != nil || got != tt.want) {
+			t.Errorf("ParseAmount(%q, %s) = %d, %v, want %d", tt.value, tt.currency, got, err, tt.want)
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("ParseAmount(%q, %s) = %d, want an error", tt.value, tt.currency, got)
+		}
+	}
+}
+
+func TestMoneyFromFloat(t *testing.T) {
+	tests := []struct {
+		value    float64
+		currency string
+		want     int64
+	}{
+		{19.99, "EUR", 1999},
+		// 0.1 + 0.2 no es exactamente 0.3 en float
+		{0.1 + 0.2, "EUR", 30},
+		{1.005, "EUR", 100},
+		{2.675, "USD", 268},
+		{1500.4, "JPY", 1500},
+		{-3.335, "EUR", -334},
+		{0, "EUR", 0},
+	}
+	for _, tt := range tests {
+		got := MoneyFromFloat(tt.value, tt.currency)
+		if got != (Money{Amount: tt.want, Currency: tt.currency}) {
+			t.Errorf("MoneyFromFloat(%v, %s) = %+v, want %d", tt.value, tt.currency, got, tt.want)
+		}
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	tests := []struct {
+		money Money
+		want  string
+	}{
+		{Money{1999, "EUR"}, "19.99 EUR"},
+		{Money{5, "EUR"}, "0.05 EUR"},
+		{Money{-1999, "EUR"}, "-19.99 EUR"},
+		{Money{1500, "JPY"}, "1500 JPY"},
+		{Money{1234, "KWD"}, "1.234 KWD"},
+	}
+	for _, tt := range tests {
+		if got := tt.money.String(); got != tt.want {
+			t.Errorf("%+v.String() = %q, want %q", tt.money, got, tt.want)
+		}
+	}
+}
+
+func TestMoneyConvert(t *testing.T) {
+	tests := []struct {
+		name     string
+		money    Money
+		currency string
+		rate     string
+		want     int64
+		ok       bool
+	}{
+		{"same exponent", Money{1000, "EUR"}, "USD", "1.0842", 1084, true},
+		{"round half up", Money{50, "EUR"}, "USD", "1.01", 51, true},
+		{"round down", Money{1, "EUR"}, "USD", "1.4", 1, true},
+		{"half away from zero", Money{-50, "EUR"}, "USD", "1.01", -51, true},
+		{"to no decimals", Money{1999, "EUR"}, "JPY", "161.5", 3228, true},
+		{"from no decimals", Money{1000, "JPY"}, "EUR", "0.0062", 620, true},
+		{"to three decimals", Money{1000, "EUR"}, "KWD", "0.3312", 3312, true},
+		{"long rate", Money{100000, "EUR"}, "USD", "1.084212345678", 108421, true},
+		{"zero", Money{0, "EUR"}, "USD", "1.08", 0, true},
+		{"zero rate", Money{1000, "EUR"}, "USD", "0", 0, false},
+		{"negative rate", Money{1000, "EUR"}, "USD", "-1", 0, false},
+		{"invalid rate", Money{1000, "EUR"}, "USD", "abc", 0, false},
+		{"too large", Money{math.MaxInt64, "EUR"}, "USD", "2", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.money.Convert(tt.currency, tt.rate)
+			if !tt.ok {
+				if err == nil {
+					t.Errorf("Convert = %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil || got != (Money{Amount: tt.want, Currency: tt.currency}) {
+				t.Errorf("Convert = %+v, %v, want %d %s", got, err, tt.want, tt.currency)
+			}
+		})
+	}
+}
+
+func TestSetBaseCurrency(t *testing.T) {
+	defer func() { BaseCurrency = "EUR" }()
+
+	if err := SetBaseCurrency(""); err != nil || BaseCurrency != "EUR" {
+		t.Errorf("empty: BaseCurrency = %s, %v", BaseCurrency, err)
+	}
+	if err := SetBaseCurrency("usd"); err != nil || BaseCurrency != "USD" {
+		t.Errorf("usd: BaseCurrency = %s, %v", BaseCurrency, err)
+	}
+	if err := SetBaseCurrency("XXX"); err == nil || BaseCurrency != "USD" {
+		t.Errorf("XXX: BaseCurrency = %s, %v", BaseCurrency, err)
+	}
+}
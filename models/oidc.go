@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// OIDCState guarda los datos de un login OIDC en curso hasta que vuelve el callback
+type OIDCState struct {
+	State        string    `bson:"state"`
+	Nonce        string    `bson:"nonce"`
+	CodeVerifier string    `bson:"code_verifier"`
+	ExpiresAt    time.Time `bson:"expires_at"`
+}
@@ -0,0 +1,56 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Origen de los cambios de precio
+const (
+	PriceSourceManual        = "manual"
+	PriceSourceSchedule      = "schedule"
+	PriceSourceScheduleEnd   = "schedule_end"
+	PriceSourceProductCreate = "create"
+)
+
+// Estados de un precio programado
+const (
+	ScheduleStatusPending   = "pending"
+	ScheduleStatusActive    = "active"
+	ScheduleStatusEnded     = "ended"
+	ScheduleStatusExpired   = "expired"
+	ScheduleStatusCancelled = "cancelled"
+)
+
+// PriceChange es un cambio de precio de un producto en una moneda. OldPrice es nil si
+// no tenía precio en esa moneda y NewPrice es nil si se ha quitado.
+type PriceChange struct {
+	ID         primitive.ObjectID  `json:"id" bson:"_id,omitempty"`
+	ProductID  primitive.ObjectID  `json:"product_id" bson:"product_id"`
+	Currency   string              `json:"currency" bson:"currency"`
+	OldPrice   *Money              `json:"old_price" bson:"old_price"`
+	NewPrice   *Money              `json:"new_price" bson:"new_price"`
+	Source     string              `json:"source" bson:"source"`
+	ScheduleID *primitive.ObjectID `json:"schedule_id,omitempty" bson:"schedule_id,omitempty"`
+	ActorID    string              `json:"actor_id,omitempty" bson:"actor_id,omitempty"`
+	ActorType  string              `json:"actor_type" bson:"actor_type"`
+	CreatedAt  time.Time           `json:"created_at" bson:"created_at"`
+}
+
+// ScheduledPrice es un precio en la moneda base que se aplica al producto en StartsAt y,
+// si tiene EndsAt, se retira entonces volviendo a PreviousPrice, el que tenía antes.
+type ScheduledPrice struct {
+	ID            primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	ProductID     primitive.ObjectID `json:"product_id" bson:"product_id"`
+	Price         Money              `json:"price" bson:"price"`
+	StartsAt      time.Time          `json:"starts_at" bson:"starts_at"`
+	EndsAt        *time.Time         `json:"ends_at,omitempty" bson:"ends_at,omitempty"`
+	Status        string             `json:"status" bson:"status"`
+	PreviousPrice *Money             `json:"previous_price,omitempty" bson:"previous_price,omitempty"`
+	ActorID       string             `json:"actor_id,omitempty" bson:"actor_id,omitempty"`
+	ActorType     string             `json:"actor_type" bson:"actor_type"`
+	CreatedAt     time.Time          `json:"created_at" bson:"created_at"`
+	AppliedAt     *time.Time         `json:"applied_at,omitempty" bson:"applied_at,omitempty"`
+	EndedAt       *time.Time         `json:"ended_at,omitempty" bson:"ended_at,omitempty"`
+}
@@ -1,17 +1,79 @@
 package models
 
-// Product struct
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Product struct. CategoryID es la categoría del producto y Category su nombre, que
+// se copia de la categoría para las búsquedas y el catálogo. Con show=true el producto
+// solo se ve en el catálogo entre PublishAt y UnpublishAt si los tiene. Stock es la
+// suma del stock de todos los almacenes y solo cambia con movimientos de stock. Options
+// son las opciones de las variantes del producto, que se guardan en la colección variants.
+// Price está en la moneda base y Prices son los precios fijados en otras monedas.
 type Product struct {
-	ID          string  `json:"id,omitempty" bson:"_id,omitempty"`
-	Name        string  `json:"name"`
-	Category    string  `json:"category"`
-	Image       string  `json:"image"`
-	Description string  `json:"description"`
-	Price       float64 `json:"price"`
-	Show        bool    `json:"show"`
+	ID                string              `json:"id,omitempty" bson:"_id,omitempty"`
+	Name              string              `json:"name"`
+	Slug              string              `json:"slug,omitempty" bson:"slug,omitempty"`
+	CategoryID        *primitive.ObjectID `json:"category_id,omitempty" bson:"category_id,omitempty"`
+	Category          string              `json:"category"`
+	Image             string              `json:"image"`
+	Description       string              `json:"description"`
+	Price             Money               `json:"price" bson:"price"`
+	Prices            []Money             `json:"prices,omitempty" bson:"prices,omitempty"`
+	Show              bool                `json:"show"`
+	Stock             int                 `json:"stock" bson:"stock"`
+	LowStockThreshold *int                `json:"low_stock_threshold,omitempty" bson:"low_stock_threshold,omitempty"`
+	Options           []ProductOption     `json:"options,omitempty" bson:"options,omitempty"`
+	PublishAt         *time.Time          `json:"publish_at,omitempty" bson:"publish_at,omitempty"`
+	UnpublishAt       *time.Time          `json:"unpublish_at,omitempty" bson:"unpublish_at,omitempty"`
+}
+
+// IsVisible indica si el producto se ve en el catálogo público en el momento now
+func (p *Product) IsVisible(now time.Time) bool {
+	if !p.Show {
+		return false
+	}
+	if p.PublishAt != nil && p.PublishAt.After(now) {
+		return false
+	}
+	return p.UnpublishAt == nil || p.UnpublishAt.After(now)
+}
+
+// PriceIn devuelve el precio fijado del producto en la moneda, si lo tiene
+func (p *Product) PriceIn(currency string) (Money, bool) {
+	if p.Price.Currency == currency {
+		return p.Price, true
+	}
+	for _, price := range p.Prices {
+		if price.Currency == currency {
+			return price, true
+		}
+	}
+	return Money{}, false
 }
 
 type ProductResponse struct {
-	Total int       `json:"total"`
-	Items []Product `json:"items"`
+	Total      int64     `json:"total"`
+	Page       int       `json:"page,omitempty"`
+	Limit      int       `json:"limit"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+	Items      []Product `json:"items"`
+}
+
+// ProductHit es un producto de los resultados de una búsqueda con su relevancia y
+// un fragmento del texto con las coincidencias marcadas
+type ProductHit struct {
+	Product `bson:",inline"`
+	Score   float64 `json:"score,omitempty" bson:"score,omitempty"`
+	Snippet string  `json:"snippet,omitempty" bson:"-"`
+}
+
+type ProductSearchResponse struct {
+	Total      int64        `json:"total"`
+	Page       int          `json:"page,omitempty"`
+	Limit      int          `json:"limit"`
+	NextCursor string       `json:"next_cursor,omitempty"`
+	Items      []ProductHit `json:"items"`
 }
@@ -0,0 +1,85 @@
+package models
+
+// Roles disponibles para los usuarios
+const (
+	RoleAdmin  = "admin"
+	RoleStaff  = "staff"
+	RoleViewer = "viewer"
+)
+
+// Permisos sobre los recursos de la API
+const (
+	PermProductsRead    = "products:read"
+	PermProductsWrite   = "products:write"
+	PermCategoriesRead  = "categories:read"
+	PermCategoriesWrite = "categories:write"
+	PermInventoryRead   = "inventory:read"
+	PermInventoryWrite  = "inventory:write"
+	PermUsersRead       = "users:read"
+	PermUsersWrite      = "users:write"
+	PermCustomersRead   = "customers:read"
+	PermCustomersWrite  = "customers:write"
+	PermFilesRead       = "files:read"
+	PermFilesWrite      = "files:write"
+	PermRolesRead       = "roles:read"
+	PermRolesWrite      = "roles:write"
+	PermSessionsManage  = "sessions:manage"
+	PermAPIKeysManage   = "apikeys:manage"
+)
+
+// RolePermissions asigna a cada rol las acciones que tiene permitidas
+var RolePermissions = map[string][]string{
+	RoleAdmin: {
+		PermProductsRead, PermProductsWrite,
+		PermCategoriesRead, PermCategoriesWrite,
+		PermInventoryRead, PermInventoryWrite,
+		PermUsersRead, PermUsersWrite,
+		PermCustomersRead, PermCustomersWrite,
+		PermFilesRead, PermFilesWrite,
+		PermRolesRead, PermRolesWrite,
+		PermSessionsManage,
+		PermAPIKeysManage,
+	},
+	RoleStaff: {
+		PermProductsRead, PermProductsWrite,
+		PermCategoriesRead, PermCategoriesWrite,
+		PermInventoryRead, PermInventoryWrite,
+		PermUsersRead,
+		PermCustomersRead, PermCustomersWrite,
+		PermFilesRead, PermFilesWrite,
+	},
+	RoleViewer: {
+		PermProductsRead,
+		PermCategoriesRead,
+		PermInventoryRead,
+		PermUsersRead,
+		PermCustomersRead,
+		PermFilesRead,
+	},
+}
+
+type RoleResponse struct {
+	Name        string   `json:"name"`
+	Permissions []string `json:"permissions"`
+}
+
+// IsValidRole comprueba que el rol exista
+func IsValidRole(role string) bool {
+	_, ok := RolePermissions[role]
+	return ok
+}
+
+// IsValidPermission comprueba que el permiso exista
+func IsValidPermission(permission string) bool {
+	return HasPermission(RoleAdmin, permission)
+}
+
+// HasPermission comprueba si el rol tiene el permiso
+func HasPermission(role string, permission string) bool {
+	for _, p := range RolePermissions[role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
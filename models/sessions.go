@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Session es un inicio de sesión. Todos los tokens emitidos a partir del
+// mismo Login comparten FamilyID, que es el ID de la sesión en hex.
+type Session struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	FamilyID   string             `json:"-" bson:"family_id"`
+	UserID     primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Device     string             `json:"device" bson:"device"`
+	UserAgent  string             `json:"user_agent" bson:"user_agent"`
+	IP         string             `json:"ip" bson:"ip"`
+	CreatedAt  time.Time          `json:"created_at" bson:"created_at"`
+	LastUsedAt time.Time          `json:"last_used_at" bson:"last_used_at"`
+	Current    bool               `json:"current" bson:"-"`
+}
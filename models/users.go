@@ -1,6 +1,10 @@
 package models
 
-import "go.mongodb.org/mongo-driver/bson/primitive"
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
 
 type Users struct {
 	ID       primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
@@ -8,4 +12,21 @@ type Users struct {
 	Password string             `json:"password,omitempty" bson:"password,omitempty"`
 	Email    string             `json:"email,omitempty" bson:"email,omitempty"`
 	Role     string             `json:"role,omitempty" bson:"role,omitempty"`
+	// nil en las cuentas creadas antes de la verificación de email
+	EmailVerified      *bool      `json:"email_verified,omitempty" bson:"email_verified,omitempty"`
+	VerificationSentAt *time.Time `json:"-" bson:"verification_sent_at,omitempty"`
+	// Autenticación en dos pasos (TOTP)
+	TOTPEnabled       bool     `json:"totp_enabled,omitempty" bson:"totp_enabled,omitempty"`
+	TOTPSecret        string   `json:"-" bson:"totp_secret,omitempty"`
+	TOTPPendingSecret string   `json:"-" bson:"totp_pending_secret,omitempty"`
+	TOTPLastStep      int64    `json:"-" bson:"totp_last_step,omitempty"`
+	RecoveryCodes     []string `json:"-" bson:"recovery_codes,omitempty"`
+	// Cuenta vinculada en el proveedor de identidad (OIDC)
+	OIDCIssuer  string `json:"-" bson:"oidc_issuer,omitempty"`
+	OIDCSubject string `json:"-" bson:"oidc_subject,omitempty"`
+}
+
+// IsEmailVerified indica si el usuario verificó su email
+func (u *Users) IsEmailVerified() bool {
+	return u.EmailVerified == nil || *u.EmailVerified
 }
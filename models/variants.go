@@ -0,0 +1,49 @@
+package models
+
+import (
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ProductOption es una opción con la que se vende un producto, como la talla o el color
+type ProductOption struct {
+	Name   string   `json:"name" bson:"name"`
+	Values []string `json:"values" bson:"values"`
+}
+
+// Variant es una combinación de valores de las opciones de un producto. Price, en la
+// moneda base, sustituye al precio del producto si lo tiene. Stock es la suma del stock de la variante en todos
+// los almacenes y, como el del producto, solo cambia con movimientos de stock.
+type Variant struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	ProductID primitive.ObjectID `json:"product_id" bson:"product_id"`
+	SKU       string             `json:"sku" bson:"sku"`
+	Options   map[string]string  `json:"options" bson:"options"`
+	Key       string             `json:"-" bson:"key"`
+	Price     *Money             `json:"price,omitempty" bson:"price,omitempty"`
+	Image     string             `json:"image,omitempty" bson:"image,omitempty"`
+	Stock     int                `json:"stock" bson:"stock"`
+}
+
+// VariantKey identifica la combinación de valores de una variante, sin depender del
+// orden de las opciones. {"size": "M", "color": "Red"} -> "color=Red|size=M"
+func VariantKey(options map[string]string) string {
+	names := make([]string, 0, len(options))
+	for name := range options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = name + "=" + options[name]
+	}
+	return strings.Join(parts, "|")
+}
+
+// NormalizeSKU quita los espacios de los extremos y pasa el SKU a mayúsculas
+func NormalizeSKU(sku string) string {
+	return strings.ToUpper(strings.TrimSpace(sku))
+}
@@ -0,0 +1,130 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"main/oidc/oidctest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func discover(t *testing.T, server *oidctest.Server) *Provider {
+	t.Helper()
+	provider, err := Discover(context.Background(), server.URL, server.ClientID, "secret", "http://localhost/callback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	server := oidctest.NewServer("client")
+	defer server.Close()
+	provider := discover(t, server)
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		token func() string
+		ok    bool
+	}{
+		{"valid", func() string { return server.Sign(server.Claims("sub-1", "nonce")) }, true},
+		{"wrong nonce", func() string { return server.Sign(server.Claims("sub-1", "other")) }, false},
+		{"wrong issuer", func() string {
+			claims := server.Claims("sub-1", "nonce")
+			claims["iss"] = "https://evil.example"
+			return server.Sign(claims)
+		}, false},
+		{"wrong audience", func() string {
+			claims := server.Claims("sub-1", "nonce")
+			claims["aud"] = "other-client"
+			return server.Sign(claims)
+		}, false},
+		{"expired", func() string {
+			claims := server.Claims("sub-1", "nonce")
+			claims["exp"] = time.Now().Add(-time.Minute).Unix()
+			return server.Sign(claims)
+		}, false},
+		{"without exp", func() string {
+			claims := server.Claims("sub-1", "nonce")
+			delete(claims, "exp")
+			return server.Sign(claims)
+		}, false},
+		{"without sub", func() string { return server.Sign(server.Claims("", "nonce")) }, false},
+		{"unknown kid", func() string {
+			token := jwt.NewWithClaims(jwt.SigningMethodRS256, server.Claims("sub-1", "nonce"))
+			token.Header["kid"] = "other-key"
+			signed, _ := token.SignedString(server.Key)
+			return signed
+		}, false},
+		{"wrong key", func() string {
+			token := jwt.NewWithClaims(jwt.SigningMethodRS256, server.Claims("sub-1", "nonce"))
+			token.Header["kid"] = server.KeyID
+			signed, _ := token.SignedString(otherKey)
+			return signed
+		}, false},
+		{"HS256", func() string {
+			token := jwt.NewWithClaims(jwt.SigningMethodHS256, server.Claims("sub-1", "nonce"))
+			token.Header["kid"] = server.KeyID
+			signed, _ := token.SignedString([]byte("client"))
+			return signed
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := provider.VerifyIDToken(context.Background(), tt.token(), "nonce")
+			if tt.ok {
+				if err != nil {
+					t.Fatalf("VerifyIDToken: %v", err)
+				}
+				if claims.Subject != "sub-1" {
+					t.Errorf("subject = %q", claims.Subject)
+				}
+				return
+			}
+			if err == nil {
+				t.Error("VerifyIDToken accepted an invalid token")
+			}
+		})
+	}
+}
+
+func TestDiscoverIssuerMismatch(t *testing.T) {
+	server := oidctest.NewServer("client")
+	defer server.Close()
+
+	if _, err := Discover(context.Background(), server.URL+"/", "client", "", ""); err == nil {
+		t.Error("Discover accepted an issuer that does not match the document")
+	}
+}
+
+func TestExchange(t *testing.T) {
+	server := oidctest.NewServer("client")
+	defer server.Close()
+	provider := discover(t, server)
+
+	idToken := server.Sign(server.Claims("sub-1", "nonce"))
+	server.SetIDToken(idToken)
+
+	token, err := provider.Exchange(context.Background(), "code", "verifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.IDToken != idToken {
+		t.Errorf("id_token = %q", token.IDToken)
+	}
+	if got := server.CodeVerifier(); got != "verifier" {
+		t.Errorf("code_verifier sent = %q", got)
+	}
+
+	if _, err := provider.Exchange(context.Background(), "", "verifier"); err == nil {
+		t.Error("Exchange accepted an error from the token endpoint")
+	}
+}
@@ -0,0 +1,118 @@
+// Package oidctest es un proveedor OpenID Connect local para probar el login OIDC
+// sin un proveedor de identidad real
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Server sirve el discovery, el JWKS y el token endpoint. El token endpoint responde
+// con el ID token que se haya fijado con SetIDToken.
+type Server struct {
+	*httptest.Server
+	ClientID string
+	Key      *rsa.PrivateKey
+	KeyID    string
+
+	mu           sync.Mutex
+	idToken      string
+	codeVerifier string
+}
+
+// NewServer arranca el proveedor con una clave RSA nueva
+func NewServer(clientID string) *Server {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	s := &Server{ClientID: clientID, Key: key, KeyID: "test-key"}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]string{
+			"issuer":                 s.URL,
+			"authorization_endpoint": s.URL + "/authorize",
+			"token_endpoint":         s.URL + "/token",
+			"jwks_uri":               s.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": s.KeyID,
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if r.FormValue("grant_type") != "authorization_code" || r.FormValue("code") == "" || s.idToken == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			writeJSON(w, map[string]string{"error": "invalid_grant"})
+			return
+		}
+		s.codeVerifier = r.FormValue("code_verifier")
+		writeJSON(w, map[string]interface{}{
+			"access_token": "access-token",
+			"token_type":   "Bearer",
+			"id_token":     s.idToken,
+			"expires_in":   3600,
+		})
+	})
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// Claims devuelve claims válidos de un ID token de este proveedor
+func (s *Server) Claims(subject string, nonce string) jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":   s.URL,
+		"aud":   s.ClientID,
+		"sub":   subject,
+		"nonce": nonce,
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+// Sign firma los claims con la clave del proveedor
+func (s *Server) Sign(claims jwt.Claims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = s.KeyID
+	signed, err := token.SignedString(s.Key)
+	if err != nil {
+		panic(err)
+	}
+	return signed
+}
+
+// SetIDToken fija el ID token que devuelve el token endpoint
+func (s *Server) SetIDToken(idToken string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.idToken = idToken
+}
+
+// CodeVerifier devuelve el code_verifier de la última petición al token endpoint
+func (s *Server) CodeVerifier() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.codeVerifier
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
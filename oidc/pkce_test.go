@@ -0,0 +1,46 @@
+package oidc
+
+import (
+	"regexp"
+	"testing"
+)
+
+// Caracteres permitidos en el code_verifier (RFC 7636 4.1)
+var verifierChars = regexp.MustCompile(`^[A-Za-z0-9\-._~]{43,128}$`)
+
+func TestNewPKCE(t *testing.T) {
+	verifier, challenge, err := NewPKCE()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !verifierChars.MatchString(verifier) {
+		t.Errorf("verifier %q is not a valid code_verifier", verifier)
+	}
+	if want := pkceChallenge(verifier); challenge != want {
+		t.Errorf("challenge = %q, want %q", challenge, want)
+	}
+
+	other, _, err := NewPKCE()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == verifier {
+		t.Error("NewPKCE returned the same verifier twice")
+	}
+}
+
+func TestPKCEChallenge(t *testing.T) {
+	tests := []struct {
+		verifier string
+		want     string
+	}{
+		// Ejemplo del apéndice B de la RFC 7636
+		{"dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk", "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"},
+		{"", "47DEQpj8HBSa-_TImW-5JCeuQeRkm5NMpJWZG3hSuFU"},
+	}
+	for _, tt := range tests {
+		if got := pkceChallenge(tt.verifier); got != tt.want {
+			t.Errorf("pkceChallenge(%q) = %q, want %q", tt.verifier, got, tt.want)
+		}
+	}
+}
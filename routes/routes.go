@@ -2,45 +2,164 @@ package routes
 
 import (
 	"main/handlers"
+	"main/middleware"
+	"main/models"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/limiter"
 	"github.com/gofiber/fiber/v2/middleware/logger"
 )
 
 func Routes(app *fiber.App) {
+	// Claves públicas para verificar los JWT desde otros servicios
+	app.Get("/.well-known/jwks.json", handlers.JWKS)
+
 	// Middleware
 	api := app.Group("/api", logger.New())
+	api.Use(middleware.Protected(middleware.Config{
+		Public: []string{
+			"/api/auth/login",
+			"/api/auth/refresh",
+			"/api/auth/forgot-password",
+			"/api/auth/reset-password",
+			"/api/auth/verify-email",
+			"/api/auth/resend-verification",
+			"/api/auth/2fa/verify",
+			"/api/auth/oidc/login",
+			"/api/auth/oidc/callback",
+			// Rutas de clientes, protegidas con sus propios tokens más abajo
+			"/api/customers/auth/*",
+			"/api/customers/me",
+			"/api/customers/me/*",
+			"/api/files/imgs/*",
+			"GET /api/catalog/*",
+		},
+		// Las integraciones solo trabajan con el catálogo y el inventario
+		APIKeys: []string{
+			"/api/products",
+			"/api/products/*",
+			"/api/categories",
+			"/api/categories/*",
+			"/api/exchange-rates",
+			"/api/exchange-rates/*",
+		},
+	}))
 
 	// Auth
 	auth := api.Group("/auth")
 	auth.Post("/login", handlers.Login)
 	auth.Post("/logout", handlers.Logout)
+	auth.Post("/logout-all", handlers.LogoutAll)
+	auth.Post("/refresh", handlers.Refresh)
+	auth.Post("/forgot-password", handlers.ForgotPassword)
+	auth.Post("/reset-password", handlers.ResetPassword)
+	auth.Get("/verify-email", handlers.VerifyEmail)
+	auth.Post("/resend-verification", limiter.New(limiter.Config{
+		Max:        5,
+		Expiration: time.Hour,
+	}), handlers.ResendVerification)
+	auth.Get("/oidc/login", handlers.OIDCLogin)
+	auth.Get("/oidc/callback", handlers.OIDCCallback)
+	auth.Post("/2fa/enroll", handlers.EnrollTwoFactor)
+	auth.Post("/2fa/confirm", handlers.ConfirmTwoFactor)
+	auth.Post("/2fa/disable", handlers.DisableTwoFactor)
+	auth.Post("/2fa/verify", handlers.VerifyTwoFactor)
+	auth.Get("/sessions", handlers.GetSessions)
+	auth.Delete("/sessions/:sessionId", handlers.DeleteSession)
 
 	// Productos
 	product := api.Group("/products")
-	product.Get("/", handlers.GetAllProducts)
-	product.Post("/", handlers.NewProduct)
-	product.Put("/:id", handlers.EditProduct)
-	product.Delete("/:id", handlers.DeleteProduct)
+	product.Get("/", middleware.Permission(models.PermProductsRead), handlers.GetAllProducts)
+	product.Get("/search", middleware.Permission(models.PermProductsRead), handlers.SearchProducts)
+	product.Post("/", middleware.Permission(models.PermProductsWrite), handlers.NewProduct)
+	product.Put("/:id", middleware.Permission(models.PermProductsWrite), handlers.EditProduct)
+	product.Put("/:id/visibility", middleware.Permission(models.PermProductsWrite), handlers.SetProductVisibility)
+	product.Put("/:id/options", middleware.Permission(models.PermProductsWrite), handlers.SetProductOptions)
+	product.Get("/:id/variants", middleware.Permission(models.PermProductsRead), handlers.GetVariants)
+	product.Get("/:id/variants/:variantId", middleware.Permission(models.PermProductsRead), handlers.GetVariant)
+	product.Post("/:id/variants", middleware.Permission(models.PermProductsWrite), handlers.CreateVariant)
+	product.Post("/:id/variants/generate", middleware.Permission(models.PermProductsWrite), handlers.GenerateVariants)
+	product.Put("/:id/variants/:variantId", middleware.Permission(models.PermProductsWrite), handlers.UpdateVariant)
+	product.Delete("/:id/variants/:variantId", middleware.Permission(models.PermProductsWrite), handlers.DeleteVariant)
+	product.Get("/:id/stock", middleware.Permission(models.PermInventoryRead), handlers.GetProductStock)
+	product.Post("/:id/stock/adjustments", middleware.Permission(models.PermInventoryWrite), handlers.AdjustStock)
+	product.Get("/:id/stock/movements", middleware.Permission(models.PermInventoryRead), handlers.GetStockMovements)
+	product.Put("/:id/stock/threshold", middleware.Permission(models.PermInventoryWrite), handlers.SetLowStockThreshold)
+	product.Get("/:id/price-history", middleware.Permission(models.PermProductsRead), handlers.GetPriceHistory)
+	product.Get("/:id/price-schedules", middleware.Permission(models.PermProductsRead), handlers.GetPriceSchedules)
+	product.Post("/:id/price-schedules", middleware.Permission(models.PermProductsWrite), handlers.CreatePriceSchedule)
+	product.Delete("/:id/price-schedules/:scheduleId", middleware.Permission(models.PermProductsWrite), handlers.CancelPriceSchedule)
+	product.Delete("/:id", middleware.Permission(models.PermProductsWrite), handlers.DeleteProduct)
+
+	// Categorías
+	categories := api.Group("/categories")
+	categories.Get("/", middleware.Permission(models.PermCategoriesRead), handlers.GetCategories)
+	categories.Get("/tree", middleware.Permission(models.PermCategoriesRead), handlers.GetCategoryTree)
+	categories.Get("/:ref", middleware.Permission(models.PermCategoriesRead), handlers.GetCategory)
+	categories.Post("/", middleware.Permission(models.PermCategoriesWrite), handlers.CreateCategory)
+	categories.Put("/:id", middleware.Permission(models.PermCategoriesWrite), handlers.UpdateCategory)
+	categories.Delete("/:id", middleware.Permission(models.PermCategoriesWrite), handlers.DeleteCategory)
+
+	// Catálogo público
+	catalog := api.Group("/catalog")
+	catalog.Get("/products", handlers.GetCatalog)
+	catalog.Get("/products/:ref", handlers.GetCatalogProduct)
+	catalog.Get("/categories", handlers.GetCatalogCategories)
+
+	// Cambios de moneda
+	exchangeRates := api.Group("/exchange-rates")
+	exchangeRates.Get("/", middleware.Permission(models.PermProductsRead), handlers.GetExchangeRates)
+	exchangeRates.Put("/:currency", middleware.Permission(models.PermProductsWrite), handlers.SetExchangeRate)
+	exchangeRates.Delete("/:currency", middleware.Permission(models.PermProductsWrite), handlers.DeleteExchangeRate)
 
 	// Files
 	files := api.Group("/files")
 	files.Static("/imgs", "./imgs")
-	files.Post("/", handlers.UploadMultiFiles)
+	files.Post("/", middleware.Permission(models.PermFilesWrite), handlers.UploadMultiFiles)
 
 	// Users
 	user := api.Group("/users")
-	user.Get("/", handlers.GetUsers)
-	user.Get("/:id", handlers.GetUser)
-	user.Post("/", handlers.CreateUser)
-	user.Patch("/:id", handlers.UpdateUser)
-	user.Patch("/profile/:id", handlers.UpdateProfile)
+	user.Get("/", middleware.Permission(models.PermUsersRead), handlers.GetUsers)
+	user.Get("/:id", middleware.Permission(models.PermUsersRead), handlers.GetUser)
+	user.Post("/", middleware.Permission(models.PermUsersWrite), handlers.CreateUser)
+	user.Patch("/:id", middleware.Permission(models.PermUsersWrite), handlers.UpdateUser)
+	user.Put("/:id/password", handlers.ChangePassword)
+	user.Patch("/:id/role", middleware.Permission(models.PermRolesWrite), handlers.UpdateUserRole)
+	user.Post("/:id/unlock", middleware.Permission(models.PermUsersWrite), handlers.UnlockUser)
+	user.Get("/:id/sessions", middleware.Permission(models.PermSessionsManage), handlers.GetUserSessions)
+	user.Delete("/:id/sessions/:sessionId", middleware.Permission(models.PermSessionsManage), handlers.DeleteUserSession)
+	user.Patch("/profile/:id", middleware.Permission(models.PermUsersWrite), handlers.UpdateProfile)
+
+	// Roles
+	roles := api.Group("/roles")
+	roles.Get("/", middleware.Permission(models.PermRolesRead), handlers.GetRoles)
+
+	// API keys
+	apiKeys := api.Group("/api-keys")
+	apiKeys.Get("/", middleware.Permission(models.PermAPIKeysManage), handlers.GetAPIKeys)
+	apiKeys.Post("/", middleware.Permission(models.PermAPIKeysManage), handlers.CreateAPIKey)
+	apiKeys.Delete("/:id", middleware.Permission(models.PermAPIKeysManage), handlers.RevokeAPIKey)
+
+	// Customers auth
+	customerProtected := middleware.Protected(middleware.Config{Audience: models.AudienceCustomers})
+	customerAuth := api.Group("/customers/auth")
+	customerAuth.Post("/login", handlers.CustomerLogin)
+	customerAuth.Post("/refresh", handlers.CustomerRefresh)
+	customerAuth.Post("/logout", customerProtected, handlers.Logout)
+
+	// Customer /me
+	me := api.Group("/customers/me", customerProtected)
+	me.Get("/", handlers.GetMe)
+	me.Patch("/", handlers.UpdateMe)
+	me.Put("/password", handlers.ChangeMyPassword)
 
 	// Customers
 	customer := api.Group("/customers")
-	customer.Get("/", handlers.GetCustomers)
-	customer.Get("/:id", handlers.GetCustomer)
-	customer.Post("/", handlers.CreateCustomer)
-	customer.Patch("/:id", handlers.UpdateCustomer)
-	customer.Delete("/:id", handlers.DeleteCustomer)
+	customer.Get("/", middleware.Permission(models.PermCustomersRead), handlers.GetCustomers)
+	customer.Get("/:id", middleware.Permission(models.PermCustomersRead), handlers.GetCustomer)
+	customer.Post("/", middleware.Permission(models.PermCustomersWrite), handlers.CreateCustomer)
+	customer.Patch("/:id", middleware.Permission(models.PermCustomersWrite), handlers.UpdateCustomer)
+	customer.Post("/:id/unlock", middleware.Permission(models.PermCustomersWrite), handlers.UnlockCustomer)
+	customer.Delete("/:id", middleware.Permission(models.PermCustomersWrite), handlers.DeleteCustomer)
 }
@@ -0,0 +1,418 @@
+package search
+
+import (
+	"encoding/gob"
+	"log"
+	"main/models"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Peso de cada campo del producto en la relevancia
+var fieldWeights = map[string]float64{
+	"name":        3,
+	"sku":         3,
+	"category":    2,
+	"description": 1,
+}
+
+// PriceBuckets son los límites de los rangos de precio de las facetas, en unidades
+// de la moneda base. El último rango no tiene máximo.
+var PriceBuckets = []int64{25, 50, 100, 250, 500}
+
+// Tiempo que se espera tras un cambio para guardar el índice en disco
+const saveDelay = 2 * time.Second
+
+// Document es lo que se indexa de un producto: el producto y los SKU de sus variantes
+type Document struct {
+	Product models.Product
+	SKUs    []string
+}
+
+// Index es un índice invertido en memoria de productos que se guarda en disco
+type Index struct {
+	mu       sync.RWMutex
+	docs     map[string]Document
+	postings map[string]map[string]float64 // término -> producto -> peso
+
+	path      string
+	saveMu    sync.Mutex
+	saveTimer *time.Timer
+}
+
+// NewIndex crea un índice vacío. Con path el índice se guarda en ese fichero cada vez que cambia.
+func NewIndex(path string) *Index {
+	return &Index{
+		docs:     make(map[string]Document),
+		postings: make(map[string]map[string]float64),
+		path:     path,
+	}
+}
+
+// Len devuelve el número de productos indexados
+func (idx *Index) Len() int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return len(idx.docs)
+}
+
+// Put añade o actualiza un producto con los SKU de sus variantes
+func (idx *Index) Put(product models.Product, skus ...string) {
+	idx.mu.Lock()
+	idx.remove(product.ID)
+	idx.add(Document{Product: product, SKUs: skus})
+	idx.mu.Unlock()
+	idx.scheduleSave()
+}
+
+// Delete quita un producto del índice
+func (idx *Index) Delete(id string) {
+	idx.mu.Lock()
+	idx.remove(id)
+	idx.mu.Unlock()
+	idx.scheduleSave()
+}
+
+// Replace cambia todo el contenido del índice por los documentos
+func (idx *Index) Replace(docs []Document) {
+	idx.mu.Lock()
+	idx.docs = make(map[string]Document, len(docs))
+	idx.postings = make(map[string]map[string]float64)
+	for _, doc := range docs {
+		idx.add(doc)
+	}
+	idx.mu.Unlock()
+	idx.scheduleSave()
+}
+
+// fieldTexts devuelve el texto de cada campo indexado del documento
+func fieldTexts(doc Document) map[string]string {
+	return map[string]string{
+		"name":        doc.Product.Name,
+		"sku":         strings.Join(doc.SKUs, " "),
+		"category":    doc.Product.Category,
+		"description": doc.Product.Description,
+	}
+}
+
+func (idx *Index) add(doc Document) {
+	id := doc.Product.ID
+	idx.docs[id] = doc
+	for field, text := range fieldTexts(doc) {
+		for _, term := range Tokenize(text) {
+			if idx.postings[term] == nil {
+				idx.postings[term] = make(map[string]float64)
+			}
+			idx.postings[term][id] += fieldWeights[field]
+		}
+	}
+}
+
+func (idx *Index) remove(id string) {
+	doc, ok := idx.docs[id]
+	if !ok {
+		return
+	}
+	delete(idx.docs, id)
+	for _, text := range fieldTexts(doc) {
+		for _, term := range Tokenize(text) {
+			delete(idx.postings[term], id)
+			if len(idx.postings[term]) == 0 {
+				delete(idx.postings, term)
+			}
+		}
+	}
+}
+
+// Request es una búsqueda en el índice. Los precios son unidades menores de la moneda
+// base. Categories son los IDs de categoría admitidos: nil no filtra y una lista vacía
+// no deja ningún producto.
+type Request struct {
+	Query      string
+	Categories []primitive.ObjectID
+	MinPrice   *int64
+	MaxPrice *int64
+	Show     *bool
+	Offset   int
+	Limit    int
+}
+
+// Hit es un producto encontrado con su relevancia y los términos del índice que
+// coincidieron con la búsqueda, que pueden diferir de los buscados por las erratas
+type Hit struct {
+	Product models.Product
+	Score   float64
+	Terms   []string
+}
+
+// FacetCount es el número de productos de un valor de una faceta
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// PriceFacet es el número de productos de un rango de precio, Max es nil en el último rango
+type PriceFacet struct {
+	Min   models.Money  `json:"min"`
+	Max   *models.Money `json:"max,omitempty"`
+	Count int           `json:"count"`
+}
+
+// Facets son los recuentos por categoría y por rango de precio. Cada faceta tiene en
+// cuenta todos los filtros menos el suyo, para poder cambiar de categoría o de rango.
+type Facets struct {
+	Categories []FacetCount `json:"categories"`
+	Prices     []PriceFacet `json:"prices"`
+}
+
+// Result es el resultado de una búsqueda
+type Result struct {
+	Total  int
+	Hits   []Hit
+	Facets Facets
+}
+
+type match struct {
+	score float64
+	terms []string
+}
+
+// Search busca los productos que contienen todos los términos de la consulta. Cada
+// término admite erratas según su longitud y el último también vale como prefijo.
+// Sin consulta devuelve todos los productos que cumplen los filtros.
+func (idx *Index) Search(req Request) Result {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	matches := idx.match(Tokenize(req.Query))
+
+	categoryIDs := make(map[primitive.ObjectID]bool, len(req.Categories))
+	for _, id := range req.Categories {
+		categoryIDs[id] = true
+	}
+	inCategory := func(p models.Product) bool {
+		return req.Categories == nil || (p.CategoryID != nil && categoryIDs[*p.CategoryID])
+	}
+	inPrice := func(p models.Product) bool {
+		return (req.MinPrice == nil || p.Price.Amount >= *req.MinPrice) && (req.MaxPrice == nil || p.Price.Amount <= *req.MaxPrice)
+	}
+
+	categories := make(map[string]int)
+	prices := make([]int, len(PriceBuckets)+1)
+	hits := make([]Hit, 0)
+	for id, m := range matches {
+		product := idx.docs[id].Product
+		if req.Show != nil && product.Show != *req.Show {
+			continue
+		}
+		if inPrice(product) && product.Category != "" {
+			categories[product.Category]++
+		}
+		if inCategory(product) {
+			prices[priceBucket(product.Price)]++
+		}
+		if inCategory(product) && inPrice(product) {
+			hits = append(hits, Hit{Product: product, Score: m.score, Terms: m.terms})
+		}
+	}
+
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		if hits[i].Product.Name != hits[j].Product.Name {
+			return hits[i].Product.Name < hits[j].Product.Name
+		}
+		return hits[i].Product.ID < hits[j].Product.ID
+	})
+
+	result := Result{Total: len(hits), Facets: buildFacets(categories, prices)}
+	if req.Offset < len(hits) {
+		end := req.Offset + req.Limit
+		if req.Limit <= 0 || end > len(hits) {
+			end = len(hits)
+		}
+		result.Hits = hits[req.Offset:end]
+	} else {
+		result.Hits = []Hit{}
+	}
+	return result
+}
+
+// match devuelve los productos que contienen todos los términos con su puntuación
+func (idx *Index) match(terms []string) map[string]match {
+	matches := make(map[string]match)
+	if len(terms) == 0 {
+		for id := range idx.docs {
+			matches[id] = match{}
+		}
+		return matches
+	}
+
+	for i, term := range terms {
+		found := make(map[string]match)
+		for indexed, factor := range idx.expand(term, i == len(terms)-1) {
+			docs := idx.postings[indexed]
+			idf := math.Log(1 + float64(len(idx.docs))/float64(len(docs)))
+			for id, weight := range docs {
+				score := weight * factor * idf
+				if best, ok := found[id]; !ok || score > best.score {
+					found[id] = match{score: score, terms: []string{indexed}}
+				}
+			}
+		}
+
+		// Solo siguen los productos que contienen también este término
+		if i == 0 {
+			matches = found
+			continue
+		}
+		for id, m := range matches {
+			f, ok := found[id]
+			if !ok {
+				delete(matches, id)
+				continue
+			}
+			matches[id] = match{score: m.score + f.score, terms: append(m.terms, f.terms...)}
+		}
+	}
+	return matches
+}
+
+// expand devuelve los términos del índice que valen por term y cuánto puntúan: el
+// término exacto, los que empiezan por él si prefix y los que están a pocas erratas
+func (idx *Index) expand(term string, prefix bool) map[string]float64 {
+	expanded := make(map[string]float64)
+	edits := maxEdits(term)
+	for indexed := range idx.postings {
+		switch {
+		case indexed == term:
+			expanded[indexed] = 1
+		case prefix && len([]rune(term)) >= 2 && strings.HasPrefix(indexed, term):
+			expanded[indexed] = 0.8
+		case edits > 0:
+			if d := editDistance(term, indexed, edits); d <= edits {
+				expanded[indexed] = 1 - 0.3*float64(d)
+			}
+		}
+	}
+	return expanded
+}
+
+func priceBucket(price models.Money) int {
+	scale := int64(math.Pow10(models.CurrencyExponent(price.Currency)))
+	for i, limit := range PriceBuckets {
+		if price.Amount < limit*scale {
+			return i
+		}
+	}
+	return len(PriceBuckets)
+}
+
+func buildFacets(categories map[string]int, prices []int) Facets {
+	facets := Facets{Categories: make([]FacetCount, 0, len(categories)), Prices: make([]PriceFacet, 0, len(prices))}
+	for value, count := range categories {
+		facets.Categories = append(facets.Categories, FacetCount{Value: value, Count: count})
+	}
+	sort.Slice(facets.Categories, func(i, j int) bool {
+		if facets.Categories[i].Count != facets.Categories[j].Count {
+			return facets.Categories[i].Count > facets.Categories[j].Count
+		}
+		return facets.Categories[i].Value < facets.Categories[j].Value
+	})
+
+	currency := models.BaseCurrency
+	scale := int64(math.Pow10(models.CurrencyExponent(currency)))
+	min := models.Money{Currency: currency}
+	for i, count := range prices {
+		bucket := PriceFacet{Min: min, Count: count}
+		if i < len(PriceBuckets) {
+			max := models.Money{Amount: PriceBuckets[i] * scale, Currency: currency}
+			bucket.Max = &max
+			min = max
+		}
+		facets.Prices = append(facets.Prices, bucket)
+	}
+	return facets
+}
+
+// Save guarda los productos del índice en su fichero. Los términos se recalculan al cargarlo.
+func (idx *Index) Save() error {
+	if idx.path == "" {
+		return nil
+	}
+	idx.saveMu.Lock()
+	defer idx.saveMu.Unlock()
+
+	idx.mu.RLock()
+	docs := make([]Document, 0, len(idx.docs))
+	for _, doc := range idx.docs {
+		docs = append(docs, doc)
+	}
+	idx.mu.RUnlock()
+
+	if err := os.MkdirAll(filepath.Dir(idx.path), 0755); err != nil {
+		return err
+	}
+	// Escribir en un fichero temporal y renombrarlo para no dejar el índice a medias
+	tmp := idx.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := gob.NewEncoder(f).Encode(docs); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, idx.path)
+}
+
+// Load carga el índice de su fichero. Devuelve un error de os.IsNotExist si no existe.
+func (idx *Index) Load() error {
+	f, err := os.Open(idx.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var docs []Document
+	if err := gob.NewDecoder(f).Decode(&docs); err != nil {
+		return err
+	}
+
+	idx.mu.Lock()
+	idx.docs = make(map[string]Document, len(docs))
+	idx.postings = make(map[string]map[string]float64)
+	for _, doc := range docs {
+		idx.add(doc)
+	}
+	idx.mu.Unlock()
+	return nil
+}
+
+// scheduleSave guarda el índice poco después del último cambio
+func (idx *Index) scheduleSave() {
+	if idx.path == "" {
+		return
+	}
+	idx.saveMu.Lock()
+	defer idx.saveMu.Unlock()
+	if idx.saveTimer != nil {
+		idx.saveTimer.Stop()
+	}
+	idx.saveTimer = time.AfterFunc(saveDelay, func() {
+		if err := idx.Save(); err != nil {
+			log.Println("search index:", err)
+		}
+	})
+}
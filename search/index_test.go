@@ -0,0 +1,127 @@
+package search
+
+import (
+	"main/models"
+	"path/filepath"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Categorías de los productos de prueba
+var (
+	ropa  = primitive.NewObjectID()
+	hogar = primitive.NewObjectID()
+)
+
+func testProducts() []Document {
+	return []Document{
+		{Product: models.Product{ID: "1", Name: "Camiseta roja", CategoryID: &ropa, Category: "Ropa", Show: true, Price: models.Money{Amount: 1999, Currency: "EUR"}}, SKUs: []string{"CAM-ROJ-M", "CAM-ROJ-L"}},
+		{Product: models.Product{ID: "2", Name: "Camiseta azul", CategoryID: &ropa, Category: "Ropa", Show: true, Price: models.Money{Amount: 2999, Currency: "EUR"}}},
+		{Product: models.Product{ID: "3", Name: "Taza", CategoryID: &hogar, Category: "Hogar", Description: "Taza de cerámica", Price: models.Money{Amount: 899, Currency: "EUR"}}},
+	}
+}
+
+func hitIDs(result Result) []string {
+	ids := make([]string, 0, len(result.Hits))
+	for _, hit := range result.Hits {
+		ids = append(ids, hit.Product.ID)
+	}
+	return ids
+}
+
+func TestIndexSearch(t *testing.T) {
+	models.BaseCurrency = "EUR"
+	idx := NewIndex("")
+	idx.Replace(testProducts())
+
+	show := true
+	tests := []struct {
+		name string
+		req  Request
+		want []string
+	}{
+		{"name", Request{Query: "camiseta"}, []string{"2", "1"}},
+		{"typo", Request{Query: "camiseat azul"}, []string{"2"}},
+		{"prefix", Request{Query: "taz"}, []string{"3"}},
+		{"sku", Request{Query: "cam-roj-l"}, []string{"1"}},
+		{"category", Request{Categories: []primitive.ObjectID{hogar}}, []string{"3"}},
+		{"several categories", Request{Categories: []primitive.ObjectID{ropa, hogar}, Show: &show}, []string{"2", "1"}},
+		{"unknown category", Request{Categories: []primitive.ObjectID{}}, []string{}},
+		{"show", Request{Show: &show, Query: "taza"}, []string{}},
+		{"limit", Request{Query: "camiseta", Offset: 1, Limit: 1}, []string{"1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := hitIDs(idx.Search(tt.req))
+			if len(got) != len(tt.want) {
+				t.Fatalf("hits = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("hits = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestIndexFacets(t *testing.T) {
+	models.BaseCurrency = "EUR"
+	idx := NewIndex("")
+	idx.Replace(testProducts())
+
+	facets := idx.Search(Request{Categories: []primitive.ObjectID{ropa}}).Facets
+	// La faceta de categoría ignora su propio filtro
+	if len(facets.Categories) != 2 || facets.Categories[0] != (FacetCount{Value: "Ropa", Count: 2}) {
+		t.Errorf("categories = %v", facets.Categories)
+	}
+	// 19,99 y 29,99 caen en los rangos 0-25 y 25-50
+	if facets.Prices[0].Count != 1 || facets.Prices[1].Count != 1 {
+		t.Errorf("prices = %v", facets.Prices)
+	}
+}
+
+func TestIndexPutDelete(t *testing.T) {
+	idx := NewIndex("")
+	idx.Replace(testProducts())
+
+	// Al actualizar un producto deja de encontrarse por sus términos anteriores
+	product := testProducts()[0].Product
+	product.Name = "Sudadera roja"
+	idx.Put(product, "SUD-ROJ-M")
+	if got := hitIDs(idx.Search(Request{Query: "cam-roj-m"})); len(got) != 0 {
+		t.Errorf("old sku hits = %v", got)
+	}
+	if got := hitIDs(idx.Search(Request{Query: "sudadera"})); len(got) != 1 || got[0] != "1" {
+		t.Errorf("new name hits = %v", got)
+	}
+
+	idx.Delete("1")
+	if idx.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", idx.Len())
+	}
+	if got := hitIDs(idx.Search(Request{Query: "sud-roj-m"})); len(got) != 0 {
+		t.Errorf("deleted hits = %v", got)
+	}
+}
+
+func TestIndexSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "products.index")
+	idx := NewIndex(path)
+	idx.Replace(testProducts())
+	if err := idx.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := NewIndex(path)
+	if err := loaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Len() != 3 {
+		t.Errorf("Len() = %d, want 3", loaded.Len())
+	}
+	if got := hitIDs(loaded.Search(Request{Query: "cam-roj-l"})); len(got) != 1 || got[0] != "1" {
+		t.Errorf("sku hits after load = %v", got)
+	}
+}
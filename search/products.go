@@ -0,0 +1,132 @@
+// Package search es el índice de búsqueda de productos de la API. Cada instancia de la
+// API tiene su propia copia en memoria y en disco: los cambios que hace una instancia
+// se indexan al momento en ella, y las demás los recogen al reconstruir el índice desde
+// la BD cada SEARCH_REFRESH_INTERVAL (StartRefresh).
+package search
+
+import (
+	"context"
+	"log"
+	"main/config"
+	"main/database"
+	"main/models"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Fichero del índice de productos si no se configura SEARCH_INDEX_PATH
+const defaultIndexPath = "data/products.index"
+
+// Products es el índice de búsqueda de productos de la API
+var Products = NewIndex("")
+
+// OpenProducts carga el índice de productos de SEARCH_INDEX_PATH. Si el fichero no
+// existe o no se puede leer, lo construye desde la colección Products.
+func OpenProducts(ctx context.Context) error {
+	Products = NewIndex(indexPath())
+
+	err := Products.Load()
+	if err == nil {
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		log.Println("search index: rebuilding,", err)
+	}
+	return RebuildProducts(ctx)
+}
+
+// RebuildProducts vuelve a indexar todos los productos de la BD y guarda el índice
+func RebuildProducts(ctx context.Context) error {
+	if Products.path == "" {
+		Products = NewIndex(indexPath())
+	}
+
+	cursor, err := database.Mg.Db.Collection("Products").Find(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	products := make([]models.Product, 0)
+	if err := cursor.All(ctx, &products); err != nil {
+		return err
+	}
+	skus, err := variantSKUs(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+
+	docs := make([]Document, 0, len(products))
+	for _, product := range products {
+		docs = append(docs, Document{Product: product, SKUs: skus[product.ID]})
+	}
+	Products.Replace(docs)
+	return Products.Save()
+}
+
+// ReindexProduct vuelve a indexar un producto con lo que hay en la BD, o lo quita del
+// índice si ya no existe
+func ReindexProduct(ctx context.Context, id string) error {
+	productID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	var product models.Product
+	err = database.Mg.Db.Collection("Products").FindOne(ctx, bson.M{"_id": productID}).Decode(&product)
+	if err == mongo.ErrNoDocuments {
+		Products.Delete(id)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	skus, err := variantSKUs(ctx, bson.M{"product_id": productID})
+	if err != nil {
+		return err
+	}
+
+	Products.Put(product, skus[id]...)
+	return nil
+}
+
+// StartRefresh reconstruye el índice cada interval en segundo plano, para recoger los
+// cambios hechos desde otras instancias de la API
+func StartRefresh(interval time.Duration) {
+	go func() {
+		for {
+			time.Sleep(interval)
+			if err := RebuildProducts(context.Background()); err != nil {
+				log.Println("search index: refresh,", err)
+			}
+		}
+	}()
+}
+
+// variantSKUs devuelve los SKU de las variantes del filtro por ID de producto
+func variantSKUs(ctx context.Context, filter bson.M) (map[string][]string, error) {
+	cursor, err := database.Mg.Db.Collection("variants").Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	var variants []models.Variant
+	if err := cursor.All(ctx, &variants); err != nil {
+		return nil, err
+	}
+
+	skus := make(map[string][]string)
+	for _, variant := range variants {
+		id := variant.ProductID.Hex()
+		skus[id] = append(skus[id], variant.SKU)
+	}
+	return skus, nil
+}
+
+func indexPath() string {
+	if path := config.Config("SEARCH_INDEX_PATH"); path != "" {
+		return path
+	}
+	return defaultIndexPath
+}
@@ -0,0 +1,113 @@
+package utils
+
+import (
+	"context"
+	"main/database"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Contadores con caducidad para los intentos fallidos de login.
+// Se guardan en Redis y, si Redis no está disponible, en la colección login_attempts.
+
+type attemptCounter struct {
+	Key       string    `bson:"key"`
+	Count     int64     `bson:"count"`
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+// IncrementAttempts suma un intento fallido y renueva la caducidad del contador
+func IncrementAttempts(ctx context.Context, key string, window time.Duration) (int64, error) {
+	if Cache != nil {
+		if n, err := Cache.Increment(attemptsKey(key), window); err == nil {
+			return n, nil
+		}
+	}
+
+	collection := database.Mg.Db.Collection("login_attempts")
+	now := time.Now()
+	if _, err := collection.DeleteMany(ctx, bson.M{"key": key, "expires_at": bson.M{"$lte": now}}); err != nil {
+		return 0, err
+	}
+	var counter attemptCounter
+	err := collection.FindOneAndUpdate(ctx,
+		bson.M{"key": key},
+		bson.M{"$inc": bson.M{"count": 1}, "$set": bson.M{"expires_at": now.Add(window)}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&counter)
+	if err != nil {
+		return 0, err
+	}
+	return counter.Count, nil
+}
+
+// Attempts devuelve los intentos fallidos que siguen dentro de la ventana y cuándo
+// caduca el contador, que es la ventana contada desde el último fallo
+func Attempts(ctx context.Context, key string) (int64, time.Time, error) {
+	if Cache != nil {
+		if n, ttl, err := Cache.CounterTTL(attemptsKey(key)); err == nil {
+			return n, time.Now().Add(ttl), nil
+		}
+	}
+
+	var counter attemptCounter
+	err := database.Mg.Db.Collection("login_attempts").FindOne(ctx, bson.M{
+		"key":        key,
+		"expires_at": bson.M{"$gt": time.Now()},
+	}).Decode(&counter)
+	if err == mongo.ErrNoDocuments {
+		return 0, time.Time{}, nil
+	}
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	return counter.Count, counter.ExpiresAt, nil
+}
+
+// AddAttemptSource guarda de dónde vino un intento fallido del contador, por ejemplo
+// la IP, para poder limpiar también sus contadores
+func AddAttemptSource(ctx context.Context, key string, source string, window time.Duration) error {
+	_, err := database.Mg.Db.Collection("login_attempts").UpdateOne(ctx,
+		bson.M{"key": sourcesKey(key)},
+		bson.M{"$addToSet": bson.M{"sources": source}, "$set": bson.M{"expires_at": time.Now().Add(window)}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// AttemptSources devuelve de dónde vinieron los intentos fallidos del contador
+func AttemptSources(ctx context.Context, key string) ([]string, error) {
+	var doc struct {
+		Sources []string `bson:"sources"`
+	}
+	err := database.Mg.Db.Collection("login_attempts").FindOne(ctx, bson.M{
+		"key":        sourcesKey(key),
+		"expires_at": bson.M{"$gt": time.Now()},
+	}).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	return doc.Sources, err
+}
+
+// ResetAttempts borra el contador y sus orígenes
+func ResetAttempts(ctx context.Context, key string) error {
+	if _, err := database.Mg.Db.Collection("login_attempts").DeleteMany(ctx, bson.M{"key": bson.M{"$in": bson.A{key, sourcesKey(key)}}}); err != nil {
+		return err
+	}
+	if Cache != nil {
+		return Cache.Delete(attemptsKey(key))
+	}
+	return nil
+}
+
+func attemptsKey(key string) string {
+	return "attempts:" + key
+}
+
+func sourcesKey(key string) string {
+	return key + ":sources"
+}
@@ -0,0 +1,175 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"main/models"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Duración de los tokens
+const (
+	AccessTokenDuration       = time.Minute * 15
+	RefreshTokenDuration      = time.Hour * 24 * 30
+	VerificationTokenDuration = time.Hour * 24
+	ChallengeTokenDuration    = time.Minute * 5
+	OIDCStateDuration         = time.Minute * 10
+)
+
+// Audiencias de los tokens que no son de acceso
+const (
+	verificationAudience = "email-verification"
+	challengeAudience    = "2fa-challenge"
+	oidcStateAudience    = "oidc-state"
+)
+
+// GenerateToken firma un token JWT para el usuario con un jti único
+func GenerateToken(user models.Users) (string, *models.Claims, error) {
+	claims := &models.Claims{
+		Email: user.Email,
+		Role:  user.Role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:  user.ID.Hex(),
+			Issuer:   models.IssuerStaff,
+			Audience: jwt.ClaimStrings{models.AudienceStaff},
+		},
+	}
+	if !user.IsEmailVerified() {
+		claims.Scope = models.ScopeUnverified
+	}
+	return signAccessToken(claims)
+}
+
+// GenerateCustomerToken firma un token JWT para el cliente. Su audiencia es
+// distinta a la de los usuarios, así que no sirve en las rutas del staff.
+func GenerateCustomerToken(customer models.Customer) (string, *models.Claims, error) {
+	claims := &models.Claims{
+		Email: customer.Email,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:  customer.ID,
+			Issuer:   models.IssuerCustomers,
+			Audience: jwt.ClaimStrings{models.AudienceCustomers},
+		},
+	}
+	if !customer.IsEmailVerified() {
+		claims.Scope = models.ScopeUnverified
+	}
+	return signAccessToken(claims)
+}
+
+func signAccessToken(claims *models.Claims) (string, *models.Claims, error) {
+	jti, err := RandomToken(16)
+	if err != nil {
+		return "", nil, err
+	}
+	claims.ID = jti
+	claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(AccessTokenDuration))
+	signedToken, err := Keys.Sign(claims)
+	if err != nil {
+		return "", nil, err
+	}
+	return signedToken, claims, nil
+}
+
+// ParseToken parsea el token JWT, valida la firma con la clave de su kid y
+// comprueba que sea un token de acceso de la audiencia indicada
+func ParseToken(tokenString string, audience string) (*jwt.Token, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &models.Claims{}, Keys.Keyfunc)
+	if err != nil {
+		return nil, err
+	}
+	if !token.Claims.(*models.Claims).VerifyAudience(audience, true) {
+		return nil, errors.New("invalid audience")
+	}
+	return token, nil
+}
+
+// GenerateVerificationToken firma el token del enlace de verificación de email
+func GenerateVerificationToken(realm string, id string, email string) (string, error) {
+	claims := models.VerificationClaims{
+		Email: email,
+		Realm: realm,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   id,
+			Audience:  jwt.ClaimStrings{verificationAudience},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(VerificationTokenDuration)),
+		},
+	}
+	return Keys.Sign(claims)
+}
+
+// ParseVerificationToken valida el token del enlace de verificación de email
+func ParseVerificationToken(tokenString string) (*models.VerificationClaims, error) {
+	claims := &models.VerificationClaims{}
+	if _, err := jwt.ParseWithClaims(tokenString, claims, Keys.Keyfunc); err != nil {
+		return nil, err
+	}
+	if !claims.VerifyAudience(verificationAudience, true) {
+		return nil, errors.New("invalid audience")
+	}
+	return claims, nil
+}
+
+// GenerateChallengeToken firma el token que Login devuelve cuando el usuario tiene 2FA
+func GenerateChallengeToken(userID string) (string, error) {
+	claims := jwt.RegisteredClaims{
+		Subject:   userID,
+		Audience:  jwt.ClaimStrings{challengeAudience},
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(ChallengeTokenDuration)),
+	}
+	return Keys.Sign(claims)
+}
+
+// ParseChallengeToken valida el challenge token de 2FA y devuelve el ID del usuario
+func ParseChallengeToken(tokenString string) (string, error) {
+	claims := &jwt.RegisteredClaims{}
+	if _, err := jwt.ParseWithClaims(tokenString, claims, Keys.Keyfunc); err != nil {
+		return "", err
+	}
+	if !claims.VerifyAudience(challengeAudience, true) {
+		return "", errors.New("invalid audience")
+	}
+	return claims.Subject, nil
+}
+
+// GenerateOIDCStateToken firma el state del login OIDC para guardarlo en una cookie
+// del navegador que empieza el login
+func GenerateOIDCStateToken(state string) (string, error) {
+	claims := jwt.RegisteredClaims{
+		Subject:   state,
+		Audience:  jwt.ClaimStrings{oidcStateAudience},
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(OIDCStateDuration)),
+	}
+	return Keys.Sign(claims)
+}
+
+// ParseOIDCStateToken valida la cookie del login OIDC y devuelve su state
+func ParseOIDCStateToken(tokenString string) (string, error) {
+	claims := &jwt.RegisteredClaims{}
+	if _, err := jwt.ParseWithClaims(tokenString, claims, Keys.Keyfunc); err != nil {
+		return "", err
+	}
+	if !claims.VerifyAudience(oidcStateAudience, true) {
+		return "", errors.New("invalid audience")
+	}
+	return claims.Subject, nil
+}
+
+// RandomToken genera un token aleatorio de n bytes codificado en hex
+func RandomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// HashToken devuelve el SHA-256 del token, que es lo que se guarda en la base de datos
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,265 @@
+package utils
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"main/config"
+	"math/big"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// SigningKey es una clave para firmar y verificar tokens JWT
+type SigningKey struct {
+	ID     string
+	Method jwt.SigningMethod
+	// Clave para firmar: []byte (HS256), *rsa.PrivateKey (RS256) o ed25519.PrivateKey (EdDSA).
+	// Puede ser nil en una clave anterior de la que solo tenemos la clave pública.
+	Private interface{}
+	// Clave para verificar: []byte (HS256), *rsa.PublicKey (RS256) o ed25519.PublicKey (EdDSA)
+	Public interface{}
+	// Fin de la ventana de rotación. Vacío para la clave actual.
+	ValidUntil time.Time
+}
+
+// KeyManager guarda la clave actual y las anteriores que siguen siendo válidas
+type KeyManager struct {
+	Current  *SigningKey
+	Previous []*SigningKey
+}
+
+// JWK es una clave pública en formato JSON Web Key (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+var Keys KeyManager
+
+// LoadKeys carga las claves de firma desde la configuración.
+//
+//	JWT_KEY_ID, JWT_ALGORITHM (HS256, RS256 o EdDSA), JWT_SECRET, JWT_PRIVATE_KEY_FILE
+//	JWT_PREVIOUS_KEY_ID, JWT_PREVIOUS_ALGORITHM, JWT_PREVIOUS_SECRET,
+//	JWT_PREVIOUS_PRIVATE_KEY_FILE o JWT_PREVIOUS_PUBLIC_KEY_FILE, JWT_PREVIOUS_KEY_UNTIL (RFC 3339)
+func LoadKeys() error {
+	current, err := loadKey("JWT_")
+	if err != nil {
+		return err
+	}
+	if current == nil {
+		// Sin configuración se usa una clave temporal: los tokens no sobreviven a un reinicio
+		log.Println("JWT_SECRET is not set, using a random signing key")
+		secret, err := RandomToken(32)
+		if err != nil {
+			return err
+		}
+		current = &SigningKey{ID: "dev", Method: jwt.SigningMethodHS256, Private: []byte(secret), Public: []byte(secret)}
+	}
+	if current.Private == nil {
+		return errors.New("JWT_PRIVATE_KEY_FILE is required for the current key")
+	}
+
+	Keys = KeyManager{Current: current}
+
+	previous, err := loadKey("JWT_PREVIOUS_")
+	if err != nil {
+		return err
+	}
+	if previous != nil {
+		until := config.Config("JWT_PREVIOUS_KEY_UNTIL")
+		if until == "" {
+			return errors.New("JWT_PREVIOUS_KEY_UNTIL is required with JWT_PREVIOUS_KEY_ID")
+		}
+		previous.ValidUntil, err = time.Parse(time.RFC3339, until)
+		if err != nil {
+			return fmt.Errorf("invalid JWT_PREVIOUS_KEY_UNTIL: %v", err)
+		}
+		if previous.ID == current.ID {
+			return errors.New("JWT_PREVIOUS_KEY_ID must be different from JWT_KEY_ID")
+		}
+		Keys.Previous = append(Keys.Previous, previous)
+	}
+
+	return nil
+}
+
+func loadKey(prefix string) (*SigningKey, error) {
+	kid := config.Config(prefix + "KEY_ID")
+	alg := config.Config(prefix + "ALGORITHM")
+	secret := config.Config(prefix + "SECRET")
+	privateKeyFile := config.Config(prefix + "PRIVATE_KEY_FILE")
+	publicKeyFile := config.Config(prefix + "PUBLIC_KEY_FILE")
+
+	if kid == "" && secret == "" && privateKeyFile == "" && publicKeyFile == "" {
+		return nil, nil
+	}
+	if kid == "" {
+		return nil, fmt.Errorf("%sKEY_ID is required", prefix)
+	}
+	if alg == "" {
+		alg = jwt.SigningMethodHS256.Alg()
+	}
+
+	key := &SigningKey{ID: kid}
+
+	switch alg {
+	case jwt.SigningMethodHS256.Alg():
+		if secret == "" {
+			return nil, fmt.Errorf("%sSECRET is required for HS256", prefix)
+		}
+		key.Method = jwt.SigningMethodHS256
+		key.Private = []byte(secret)
+		key.Public = []byte(secret)
+
+	case jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg():
+		if alg == jwt.SigningMethodRS256.Alg() {
+			key.Method = jwt.SigningMethodRS256
+		} else {
+			key.Method = jwt.SigningMethodEdDSA
+		}
+		if privateKeyFile != "" {
+			private, err := readPrivateKey(privateKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			switch k := private.(type) {
+			case *rsa.PrivateKey:
+				key.Private, key.Public = k, &k.PublicKey
+			case ed25519.PrivateKey:
+				key.Private, key.Public = k, k.Public()
+			}
+		} else if publicKeyFile != "" {
+			public, err := readPublicKey(publicKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			key.Public = public
+		} else {
+			return nil, fmt.Errorf("%sPRIVATE_KEY_FILE is required for %s", prefix, alg)
+		}
+		if !keyMatchesMethod(key) {
+			return nil, fmt.Errorf("%s key does not match algorithm %s", kid, alg)
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported %sALGORITHM %s", prefix, alg)
+	}
+
+	return key, nil
+}
+
+func keyMatchesMethod(key *SigningKey) bool {
+	switch key.Public.(type) {
+	case *rsa.PublicKey:
+		return key.Method == jwt.SigningMethodRS256
+	case ed25519.PublicKey:
+		return key.Method == jwt.SigningMethodEdDSA
+	}
+	return false
+}
+
+func readPEM(file string) (*pem.Block, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %s", file)
+	}
+	return block, nil
+}
+
+func readPrivateKey(file string) (interface{}, error) {
+	block, err := readPEM(file)
+	if err != nil {
+		return nil, err
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	return x509.ParsePKCS1PrivateKey(block.Bytes)
+}
+
+func readPublicKey(file string) (interface{}, error) {
+	block, err := readPEM(file)
+	if err != nil {
+		return nil, err
+	}
+	if key, err := x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	return x509.ParsePKCS1PublicKey(block.Bytes)
+}
+
+// Sign firma los claims con la clave actual y añade el kid en la cabecera
+func (m *KeyManager) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(m.Current.Method, claims)
+	token.Header["kid"] = m.Current.ID
+	return token.SignedString(m.Current.Private)
+}
+
+// Keyfunc devuelve la clave de verificación según el kid del token
+func (m *KeyManager) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key := m.find(kid)
+	if key == nil {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	// Verificar que se está usando el algoritmo de firma de la clave
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.Public, nil
+}
+
+func (m *KeyManager) find(kid string) *SigningKey {
+	if m.Current != nil && m.Current.ID == kid {
+		return m.Current
+	}
+	for _, key := range m.Previous {
+		if key.ID == kid && time.Now().Before(key.ValidUntil) {
+			return key
+		}
+	}
+	return nil
+}
+
+// JWKS devuelve las claves públicas asimétricas válidas. Las claves HS256 nunca se publican.
+func (m *KeyManager) JWKS() []JWK {
+	keys := make([]JWK, 0)
+	for _, key := range append([]*SigningKey{m.Current}, m.Previous...) {
+		if key == nil || (!key.ValidUntil.IsZero() && time.Now().After(key.ValidUntil)) {
+			continue
+		}
+		jwk := JWK{Kid: key.ID, Use: "sig", Alg: key.Method.Alg()}
+		switch k := key.Public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(k.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(k)
+		default:
+			continue
+		}
+		keys = append(keys, jwk)
+	}
+	return keys
+}
@@ -0,0 +1,278 @@
+package utils
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Variables de entorno de LoadKeys
+var keyEnv = []string{
+	"JWT_KEY_ID", "JWT_ALGORITHM", "JWT_SECRET", "JWT_PRIVATE_KEY_FILE", "JWT_PUBLIC_KEY_FILE",
+	"JWT_PREVIOUS_KEY_ID", "JWT_PREVIOUS_ALGORITHM", "JWT_PREVIOUS_SECRET", "JWT_PREVIOUS_PRIVATE_KEY_FILE",
+	"JWT_PREVIOUS_PUBLIC_KEY_FILE", "JWT_PREVIOUS_KEY_UNTIL",
+}
+
+// setKeyEnv deja solo las variables de env y devuelve la función que restaura las anteriores
+func setKeyEnv(env map[string]string) func() {
+	saved := make(map[string]string)
+	for _, name := range keyEnv {
+		if value, ok := os.LookupEnv(name); ok {
+			saved[name] = value
+		}
+		os.Unsetenv(name)
+	}
+	for name, value := range env {
+		os.Setenv(name, value)
+	}
+	return func() {
+		for _, name := range keyEnv {
+			os.Unsetenv(name)
+		}
+		for name, value := range saved {
+			os.Setenv(name, value)
+		}
+	}
+}
+
+// writePEM guarda der en un fichero PEM de dir y devuelve su ruta
+func writePEM(t *testing.T, dir string, name string, blockType string, der []byte) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+type testKeys struct {
+	rsa       *rsa.PrivateKey
+	ed        ed25519.PrivateKey
+	rsaFile   string
+	rsaPKCS1  string
+	rsaPublic string
+	edFile    string
+	edPublic  string
+}
+
+func newTestKeys(t *testing.T, dir string) testKeys {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := testKeys{rsa: rsaKey, ed: edKey}
+
+	der, _ := x509.MarshalPKCS8PrivateKey(rsaKey)
+	keys.rsaFile = writePEM(t, dir, "rsa.pem", "PRIVATE KEY", der)
+	keys.rsaPKCS1 = writePEM(t, dir, "rsa1.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey))
+	der, _ = x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	keys.rsaPublic = writePEM(t, dir, "rsa.pub", "PUBLIC KEY", der)
+	der, _ = x509.MarshalPKCS8PrivateKey(edKey)
+	keys.edFile = writePEM(t, dir, "ed.pem", "PRIVATE KEY", der)
+	der, _ = x509.MarshalPKIXPublicKey(edKey.Public())
+	keys.edPublic = writePEM(t, dir, "ed.pub", "PUBLIC KEY", der)
+	return keys
+}
+
+func TestLoadKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keys := newTestKeys(t, dir)
+	until := time.Now().Add(time.Hour).Format(time.RFC3339)
+
+	tests := []struct {
+		name     string
+		env      map[string]string
+		alg      string
+		previous string
+		err      string
+	}{
+		{"random key", nil, "HS256", "", ""},
+		{"hs256", map[string]string{"JWT_KEY_ID": "k1", "JWT_SECRET": "s"}, "HS256", "", ""},
+		{"rs256 pkcs8", map[string]string{"JWT_KEY_ID": "k1", "JWT_ALGORITHM": "RS256", "JWT_PRIVATE_KEY_FILE": keys.rsaFile}, "RS256", "", ""},
+		{"rs256 pkcs1", map[string]string{"JWT_KEY_ID": "k1", "JWT_ALGORITHM": "RS256", "JWT_PRIVATE_KEY_FILE": keys.rsaPKCS1}, "RS256", "", ""},
+		{"eddsa", map[string]string{"JWT_KEY_ID": "k1", "JWT_ALGORITHM": "EdDSA", "JWT_PRIVATE_KEY_FILE": keys.edFile}, "EdDSA", "", ""},
+		{"previous public key", map[string]string{
+			"JWT_KEY_ID": "k2", "JWT_ALGORITHM": "EdDSA", "JWT_PRIVATE_KEY_FILE": keys.edFile,
+			"JWT_PREVIOUS_KEY_ID": "k1", "JWT_PREVIOUS_ALGORITHM": "RS256", "JWT_PREVIOUS_PUBLIC_KEY_FILE": keys.rsaPublic, "JWT_PREVIOUS_KEY_UNTIL": until,
+		}, "EdDSA", "k1", ""},
+		{"missing kid", map[string]string{"JWT_SECRET": "s"}, "", "", "JWT_KEY_ID is required"},
+		{"missing secret", map[string]string{"JWT_KEY_ID": "k1"}, "", "", "JWT_SECRET is required for HS256"},
+		{"unknown algorithm", map[string]string{"JWT_KEY_ID": "k1", "JWT_ALGORITHM": "none", "JWT_SECRET": "s"}, "", "", "unsupported JWT_ALGORITHM"},
+		{"key does not match algorithm", map[string]string{"JWT_KEY_ID": "k1", "JWT_ALGORITHM": "EdDSA", "JWT_PRIVATE_KEY_FILE": keys.rsaFile}, "", "", "does not match"},
+		{"current without private key", map[string]string{"JWT_KEY_ID": "k1", "JWT_ALGORITHM": "RS256", "JWT_PUBLIC_KEY_FILE": keys.rsaPublic}, "", "", "JWT_PRIVATE_KEY_FILE is required"},
+		{"previous without until", map[string]string{
+			"JWT_KEY_ID": "k2", "JWT_SECRET": "s2", "JWT_PREVIOUS_KEY_ID": "k1", "JWT_PREVIOUS_SECRET": "s1",
+		}, "", "", "JWT_PREVIOUS_KEY_UNTIL is required"},
+		{"previous with the same kid", map[string]string{
+			"JWT_KEY_ID": "k1", "JWT_SECRET": "s2", "JWT_PREVIOUS_KEY_ID": "k1", "JWT_PREVIOUS_SECRET": "s1", "JWT_PREVIOUS_KEY_UNTIL": until,
+		}, "", "", "must be different"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer setKeyEnv(tt.env)()
+			Keys = KeyManager{}
+			err := LoadKeys()
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("LoadKeys = %v, want error containing %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if Keys.Current.Method.Alg() != tt.alg || Keys.Current.Private == nil {
+				t.Errorf("current key = %s, private %v", Keys.Current.Method.Alg(), Keys.Current.Private != nil)
+			}
+			if tt.previous != "" && (len(Keys.Previous) != 1 || Keys.Previous[0].ID != tt.previous || Keys.Previous[0].Private != nil) {
+				t.Errorf("previous keys = %+v", Keys.Previous)
+			}
+		})
+	}
+	Keys = KeyManager{}
+}
+
+// parseWith verifica token con los claims registrados y las claves de m
+func parseWith(m *KeyManager, token string) error {
+	_, err := jwt.ParseWithClaims(token, &jwt.RegisteredClaims{}, m.Keyfunc)
+	return err
+}
+
+func TestKeyManagerVerify(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPublic, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := &SigningKey{ID: "old", Method: jwt.SigningMethodRS256, Private: rsaKey, Public: &rsaKey.PublicKey}
+	current := &SigningKey{ID: "new", Method: jwt.SigningMethodEdDSA, Private: edKey, Public: edPublic}
+	claims := jwt.RegisteredClaims{Subject: "user-1", ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))}
+
+	before := KeyManager{Current: old}
+	oldToken, err := before.Sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated := KeyManager{Current: current, Previous: []*SigningKey{{ID: "old", Method: jwt.SigningMethodRS256, Public: &rsaKey.PublicKey, ValidUntil: time.Now().Add(time.Hour)}}}
+	newToken, err := rotated.Sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired := KeyManager{Current: current, Previous: []*SigningKey{{ID: "old", Method: jwt.SigningMethodRS256, Public: &rsaKey.PublicKey, ValidUntil: time.Now().Add(-time.Minute)}}}
+
+	// Tokens con el kid de la clave RS256 pero firmados de otra forma
+	hsConfusion := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	hsConfusion.Header["kid"] = "old"
+	publicDER := x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey)
+	confused, _ := hsConfusion.SignedString(pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: publicDER}))
+	none := jwt.NewWithClaims(jwt.SigningMethodNone, claims)
+	none.Header["kid"] = "new"
+	unsigned, _ := none.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	unknown := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+	unknown.Header["kid"] = "other"
+	unknownKid, _ := unknown.SignedString(edKey)
+	noKid, _ := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims).SignedString(edKey)
+
+	tests := []struct {
+		name    string
+		manager KeyManager
+		token   string
+		ok      bool
+	}{
+		{"current key", rotated, newToken, true},
+		{"previous key during rotation", rotated, oldToken, true},
+		{"previous key after rotation", expired, oldToken, false},
+		{"token of a newer key", before, newToken, false},
+		{"unknown kid", rotated, unknownKid, false},
+		{"no kid", rotated, noKid, false},
+		{"hs256 with the public key", rotated, confused, false},
+		{"alg none", rotated, unsigned, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := parseWith(&tt.manager, tt.token)
+			if tt.ok && err != nil {
+				t.Errorf("token rejected: %v", err)
+			}
+			if !tt.ok && err == nil {
+				t.Error("token accepted")
+			}
+		})
+	}
+
+	// Sign pone el kid de la clave actual
+	token, _, err := new(jwt.Parser).ParseUnverified(newToken, &jwt.RegisteredClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Header["kid"] != "new" || token.Method.Alg() != "EdDSA" {
+		t.Errorf("header = %v", token.Header)
+	}
+}
+
+func TestKeyManagerJWKS(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPublic, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := KeyManager{
+		Current: &SigningKey{ID: "ed", Method: jwt.SigningMethodEdDSA, Private: edKey, Public: edPublic},
+		Previous: []*SigningKey{
+			{ID: "rsa", Method: jwt.SigningMethodRS256, Public: &rsaKey.PublicKey, ValidUntil: time.Now().Add(time.Hour)},
+			{ID: "expired", Method: jwt.SigningMethodRS256, Public: &rsaKey.PublicKey, ValidUntil: time.Now().Add(-time.Hour)},
+			{ID: "secret", Method: jwt.SigningMethodHS256, Public: []byte("secret"), ValidUntil: time.Now().Add(time.Hour)},
+		},
+	}
+
+	jwks := m.JWKS()
+	if len(jwks) != 2 {
+		t.Fatalf("JWKS = %+v, want the EdDSA and RS256 keys", jwks)
+	}
+
+	ed := jwks[0]
+	x, _ := base64.RawURLEncoding.DecodeString(ed.X)
+	if ed.Kid != "ed" || ed.Kty != "OKP" || ed.Crv != "Ed25519" || ed.Alg != "EdDSA" || ed.Use != "sig" || !edPublic.Equal(ed25519.PublicKey(x)) {
+		t.Errorf("EdDSA JWK = %+v", ed)
+	}
+
+	rs := jwks[1]
+	n, _ := base64.RawURLEncoding.DecodeString(rs.N)
+	e, _ := base64.RawURLEncoding.DecodeString(rs.E)
+	if rs.Kid != "rsa" || rs.Kty != "RSA" || rs.Alg != "RS256" || rs.Use != "sig" || rs.E != "AQAB" {
+		t.Errorf("RS256 JWK = %+v", rs)
+	}
+	if new(big.Int).SetBytes(n).Cmp(rsaKey.N) != 0 || int(new(big.Int).SetBytes(e).Int64()) != rsaKey.E {
+		t.Error("RS256 JWK does not match the public key")
+	}
+
+	// Solo con una clave HS256 no se publica nada
+	if jwks := (&KeyManager{Current: m.Previous[2]}).JWKS(); len(jwks) != 0 {
+		t.Errorf("JWKS = %+v, want no keys", jwks)
+	}
+}
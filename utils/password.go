@@ -0,0 +1,180 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"main/config"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Algoritmos de hash de contraseñas soportados
+const (
+	AlgorithmArgon2id = "argon2id"
+	AlgorithmBcrypt   = "bcrypt"
+)
+
+// Argon2Params son los parámetros de argon2id. Memory va en KiB.
+type Argon2Params struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// PasswordHasher genera y verifica hashes de contraseñas. Los hashes describen
+// su algoritmo y parámetros: formato PHC para argon2id ($argon2id$v=19$m=...,t=...,p=...$salt$hash)
+// y el formato estándar $2a$cost$... para bcrypt.
+type PasswordHasher struct {
+	Algorithm  string
+	BcryptCost int
+	Argon2     Argon2Params
+}
+
+var ErrInvalidHash = errors.New("invalid password hash")
+
+// Passwords es el hasher que usa la API
+var Passwords = PasswordHasher{
+	Algorithm:  AlgorithmArgon2id,
+	BcryptCost: 12,
+	Argon2: Argon2Params{
+		Memory:      64 * 1024,
+		Iterations:  3,
+		Parallelism: 2,
+		SaltLength:  16,
+		KeyLength:   32,
+	},
+}
+
+// LoadPasswordHasher carga el algoritmo y los parámetros desde la configuración:
+// PASSWORD_ALGORITHM, BCRYPT_COST, ARGON2_MEMORY, ARGON2_ITERATIONS y ARGON2_PARALLELISM
+func LoadPasswordHasher() error {
+	if alg := config.Config("PASSWORD_ALGORITHM"); alg != "" {
+		if alg != AlgorithmArgon2id && alg != AlgorithmBcrypt {
+			return fmt.Errorf("unsupported PASSWORD_ALGORITHM %s", alg)
+		}
+		Passwords.Algorithm = alg
+	}
+
+	settings := []struct {
+		key string
+		min uint64
+		max uint64
+		set func(uint64)
+	}{
+		{"BCRYPT_COST", uint64(bcrypt.MinCost), uint64(bcrypt.MaxCost), func(v uint64) { Passwords.BcryptCost = int(v) }},
+		{"ARGON2_MEMORY", 8 * 1024, 4 * 1024 * 1024, func(v uint64) { Passwords.Argon2.Memory = uint32(v) }},
+		{"ARGON2_ITERATIONS", 1, 100, func(v uint64) { Passwords.Argon2.Iterations = uint32(v) }},
+		{"ARGON2_PARALLELISM", 1, 255, func(v uint64) { Passwords.Argon2.Parallelism = uint8(v) }},
+	}
+	for _, s := range settings {
+		value := config.Config(s.key)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.ParseUint(value, 10, 32)
+		if err != nil || n < s.min || n > s.max {
+			return fmt.Errorf("invalid %s %q", s.key, value)
+		}
+		s.set(n)
+	}
+	return nil
+}
+
+// Hash genera el hash de la contraseña con el algoritmo configurado
+func (h *PasswordHasher) Hash(password string) (string, error) {
+	if h.Algorithm == AlgorithmBcrypt {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), h.BcryptCost)
+		if err != nil {
+			return "", err
+		}
+		return string(hash), nil
+	}
+
+	salt := make([]byte, h.Argon2.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	p := h.Argon2
+	key := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.Memory, p.Iterations, p.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// Verify comprueba la contraseña contra el hash. needsRehash es true cuando la
+// contraseña es correcta pero el hash usa otro algoritmo o parámetros antiguos.
+func (h *PasswordHasher) Verify(password string, encoded string) (ok bool, needsRehash bool, err error) {
+	if strings.HasPrefix(encoded, "$argon2id$") {
+		params, salt, key, err := decodeArgon2(encoded)
+		if err != nil {
+			return false, false, err
+		}
+		other := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+		if subtle.ConstantTimeCompare(key, other) != 1 {
+			return false, false, nil
+		}
+		needsRehash = h.Algorithm != AlgorithmArgon2id ||
+			params.Memory != h.Argon2.Memory ||
+			params.Iterations != h.Argon2.Iterations ||
+			params.Parallelism != h.Argon2.Parallelism ||
+			uint32(len(salt)) != h.Argon2.SaltLength ||
+			uint32(len(key)) != h.Argon2.KeyLength
+		return true, needsRehash, nil
+	}
+
+	cost, err := bcrypt.Cost([]byte(encoded))
+	if err != nil {
+		return false, false, ErrInvalidHash
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password)); err != nil {
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return false, false, nil
+		}
+		return false, false, err
+	}
+	return true, h.Algorithm != AlgorithmBcrypt || cost != h.BcryptCost, nil
+}
+
+func decodeArgon2(encoded string) (Argon2Params, []byte, []byte, error) {
+	var params Argon2Params
+
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, hash
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return params, nil, nil, ErrInvalidHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, ErrInvalidHash
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return params, nil, nil, ErrInvalidHash
+	}
+	// argon2.IDKey entra en pánico con estos valores
+	if params.Iterations < 1 || params.Parallelism < 1 || params.Memory < 8*uint32(params.Parallelism) {
+		return params, nil, nil, ErrInvalidHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, ErrInvalidHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, ErrInvalidHash
+	}
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+	return params, salt, key, nil
+}
@@ -0,0 +1,126 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Parámetros pequeños para que los tests sean rápidos
+var testArgon2 = Argon2Params{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+func testHasher(alg string) *PasswordHasher {
+	return &PasswordHasher{Algorithm: alg, BcryptCost: bcrypt.MinCost, Argon2: testArgon2}
+}
+
+func TestArgon2HashFormat(t *testing.T) {
+	encoded, err := testHasher(AlgorithmArgon2id).Hash("secreto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	format := regexp.MustCompile(`^\$argon2id\$v=19\$m=64,t=1,p=1\$[A-Za-z0-9+/]{22}\$[A-Za-z0-9+/]{43}$`)
+	if !format.MatchString(encoded) {
+		t.Errorf("hash %q is not in PHC format", encoded)
+	}
+
+	params, salt, key, err := decodeArgon2(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params != testArgon2 || len(salt) != 16 || len(key) != 32 {
+		t.Errorf("decoded %+v, salt %d bytes, key %d bytes", params, len(salt), len(key))
+	}
+
+	other, _ := testHasher(AlgorithmArgon2id).Hash("secreto")
+	if other == encoded {
+		t.Error("two hashes of the same password share the salt")
+	}
+}
+
+func TestPasswordVerify(t *testing.T) {
+	argon, err := testHasher(AlgorithmArgon2id).Hash("secreto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bcryptHash, err := testHasher(AlgorithmBcrypt).Hash("secreto")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changed := func(change func(h *PasswordHasher)) *PasswordHasher {
+		h := testHasher(AlgorithmArgon2id)
+		change(h)
+		return h
+	}
+
+	tests := []struct {
+		name        string
+		hasher      *PasswordHasher
+		encoded     string
+		password    string
+		ok          bool
+		needsRehash bool
+	}{
+		{"argon2id", testHasher(AlgorithmArgon2id), argon, "secreto", true, false},
+		{"argon2id wrong password", testHasher(AlgorithmArgon2id), argon, "otro", false, false},
+		{"argon2id more memory", changed(func(h *PasswordHasher) { h.Argon2.Memory = 128 }), argon, "secreto", true, true},
+		{"argon2id more iterations", changed(func(h *PasswordHasher) { h.Argon2.Iterations = 2 }), argon, "secreto", true, true},
+		{"argon2id more parallelism", changed(func(h *PasswordHasher) { h.Argon2.Parallelism = 2 }), argon, "secreto", true, true},
+		{"argon2id longer key", changed(func(h *PasswordHasher) { h.Argon2.KeyLength = 64 }), argon, "secreto", true, true},
+		{"argon2id to bcrypt", testHasher(AlgorithmBcrypt), argon, "secreto", true, true},
+		{"bcrypt", testHasher(AlgorithmBcrypt), bcryptHash, "secreto", true, false},
+		{"bcrypt wrong password", testHasher(AlgorithmBcrypt), bcryptHash, "otro", false, false},
+		{"bcrypt higher cost", changed(func(h *PasswordHasher) { h.Algorithm = AlgorithmBcrypt; h.BcryptCost = 8 }), bcryptHash, "secreto", true, true},
+		{"bcrypt to argon2id", testHasher(AlgorithmArgon2id), bcryptHash, "secreto", true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, needsRehash, err := tt.hasher.Verify(tt.password, tt.encoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != tt.ok || needsRehash != tt.needsRehash {
+				t.Errorf("Verify = %v, %v, want %v, %v", ok, needsRehash, tt.ok, tt.needsRehash)
+			}
+		})
+	}
+}
+
+func TestDecodeArgon2Invalid(t *testing.T) {
+	salt := "c2FsdHNhbHRzYWx0c2FsdA"
+	key := "a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2U"
+	hash := func(version string, params string, salt string, key string) string {
+		return fmt.Sprintf("$argon2id$%s$%s$%s$%s", version, params, salt, key)
+	}
+
+	tests := []struct {
+		name    string
+		encoded string
+	}{
+		{"missing parts", "$argon2id$v=19$m=64,t=1,p=1$" + salt},
+		{"other version", hash("v=16", "m=64,t=1,p=1", salt, key)},
+		{"bad params", hash("v=19", "m=64;t=1;p=1", salt, key)},
+		{"zero iterations", hash("v=19", "m=64,t=0,p=1", salt, key)},
+		{"zero parallelism", hash("v=19", "m=64,t=1,p=0", salt, key)},
+		{"memory below parallelism", hash("v=19", "m=8,t=1,p=2", salt, key)},
+		{"bad salt", hash("v=19", "m=64,t=1,p=1", "***", key)},
+		{"empty key", hash("v=19", "m=64,t=1,p=1", salt, "")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, _, err := decodeArgon2(tt.encoded); err != ErrInvalidHash {
+				t.Errorf("decodeArgon2 err = %v, want ErrInvalidHash", err)
+			}
+			// Un hash corrupto no se acepta ni hace entrar en pánico a Verify
+			if ok, _, err := testHasher(AlgorithmArgon2id).Verify("secreto", tt.encoded); ok || err == nil {
+				t.Errorf("Verify = %v, %v", ok, err)
+			}
+		})
+	}
+
+	if ok, _, err := testHasher(AlgorithmBcrypt).Verify("secreto", "plaintext"); ok || err != ErrInvalidHash {
+		t.Errorf("Verify plaintext = %v, %v, want ErrInvalidHash", ok, err)
+	}
+}
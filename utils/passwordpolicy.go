@@ -0,0 +1,276 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"main/config"
+	"main/models"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// PasswordPolicy define los requisitos de las contraseñas nuevas
+type PasswordPolicy struct {
+	MinLength     int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	// BreachedPasswordsPath es un directorio con un fichero por prefijo de 5
+	// caracteres del SHA-1 (formato k-anonymity de Have I Been Pwned, líneas
+	// SUFIJO:CUENTA) o un único fichero con líneas HASH:CUENTA ordenadas por hash.
+	BreachedPasswordsPath string
+}
+
+// Policy es la política que usa la API
+var Policy = PasswordPolicy{
+	MinLength:    10,
+	RequireUpper: true,
+	RequireLower: true,
+	RequireDigit: true,
+}
+
+// LoadPasswordPolicy carga la política desde la configuración: PASSWORD_MIN_LENGTH,
+// PASSWORD_REQUIRE_UPPER, PASSWORD_REQUIRE_LOWER, PASSWORD_REQUIRE_DIGIT,
+// PASSWORD_REQUIRE_SYMBOL y BREACHED_PASSWORDS_PATH
+func LoadPasswordPolicy() error {
+	if value := config.Config("PASSWORD_MIN_LENGTH"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid PASSWORD_MIN_LENGTH %q", value)
+		}
+		Policy.MinLength = n
+	}
+
+	flags := []struct {
+		key string
+		set *bool
+	}{
+		{"PASSWORD_REQUIRE_UPPER", &Policy.RequireUpper},
+		{"PASSWORD_REQUIRE_LOWER", &Policy.RequireLower},
+		{"PASSWORD_REQUIRE_DIGIT", &Policy.RequireDigit},
+		{"PASSWORD_REQUIRE_SYMBOL", &Policy.RequireSymbol},
+	}
+	for _, f := range flags {
+		value := config.Config(f.key)
+		if value == "" {
+			continue
+		}
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q", f.key, value)
+		}
+		*f.set = b
+	}
+
+	if path := config.Config("BREACHED_PASSWORDS_PATH"); path != "" {
+		if _, err := os.Stat(path); err != nil {
+			return fmt.Errorf("invalid BREACHED_PASSWORDS_PATH: %v", err)
+		}
+		Policy.BreachedPasswordsPath = path
+	}
+	return nil
+}
+
+// Validate comprueba la contraseña y devuelve un error por cada requisito que no cumple.
+// email y name son los de la cuenta y la contraseña no puede contenerlos.
+func (p *PasswordPolicy) Validate(password string, email string, name string) []models.FieldError {
+	errs := make([]models.FieldError, 0)
+	add := func(code string, message string) {
+		errs = append(errs, models.FieldError{Field: "password", Code: code, Message: message})
+	}
+
+	if len([]rune(password)) < p.MinLength {
+		add("min_length", fmt.Sprintf("Password must be at least %d characters long", p.MinLength))
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			symbol = true
+		}
+	}
+	if p.RequireUpper && !upper {
+		add("uppercase", "Password must contain an uppercase letter")
+	}
+	if p.RequireLower && !lower {
+		add("lowercase", "Password must contain a lowercase letter")
+	}
+	if p.RequireDigit && !digit {
+		add("digit", "Password must contain a digit")
+	}
+	if p.RequireSymbol && !symbol {
+		add("symbol", "Password must contain a symbol")
+	}
+
+	if containsPersonalData(password, email, name) {
+		add("personal_data", "Password must not contain your email or name")
+	}
+
+	if p.BreachedPasswordsPath != "" {
+		breached, err := isBreached(p.BreachedPasswordsPath, password)
+		if err != nil {
+			// Sin lista no se bloquea el cambio de contraseña, solo se avisa en el log
+			log.Println("breached passwords:", err)
+		} else if breached {
+			add("breached", "Password has appeared in a data breach, choose a different one")
+		}
+	}
+
+	return errs
+}
+
+// containsPersonalData comprueba si la contraseña contiene el email, la parte local
+// del email o alguna palabra del nombre de al menos 3 caracteres
+func containsPersonalData(password string, email string, name string) bool {
+	lowered := strings.ToLower(password)
+	candidates := []string{strings.ToLower(email)}
+	if i := strings.Index(email, "@"); i > 0 {
+		candidates = append(candidates, strings.ToLower(email[:i]))
+	}
+	candidates = append(candidates, strings.Fields(strings.ToLower(name))...)
+
+	for _, candidate := range candidates {
+		if len([]rune(candidate)) >= 3 && strings.Contains(lowered, candidate) {
+			return true
+		}
+	}
+	return false
+}
+
+// isBreached busca el SHA-1 de la contraseña en la lista local de contraseñas filtradas
+func isBreached(path string, password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+
+	// Directorio k-anonymity: solo se lee el fichero del prefijo
+	if info.IsDir() {
+		for _, name := range []string{prefix, prefix + ".txt"} {
+			f, err := os.Open(filepath.Join(path, name))
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return false, err
+			}
+			defer f.Close()
+			return scanHashes(f, suffix)
+		}
+		return false, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	return searchSortedHashes(f, info.Size(), hash)
+}
+
+// scanHashes busca el hash en líneas HASH:CUENTA
+func scanHashes(r io.Reader, hash string) (bool, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			line = line[:i]
+		}
+		if strings.EqualFold(strings.TrimSpace(line), hash) {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+// searchSortedHashes hace una búsqueda binaria del hash en un fichero con líneas
+// HASH:CUENTA ordenadas, sin cargarlo en memoria
+func searchSortedHashes(f *os.File, size int64, hash string) (bool, error) {
+	target := []byte(hash)
+	lo, hi := int64(0), size
+	for lo < hi {
+		mid := (lo + hi) / 2
+		line, start, err := lineAt(f, mid, size)
+		if err != nil {
+			return false, err
+		}
+		if start >= hi {
+			// No hay más líneas completas en el rango: se busca desde lo
+			hi = mid
+			continue
+		}
+		key := line
+		if i := bytes.IndexByte(key, ':'); i >= 0 {
+			key = key[:i]
+		}
+		switch cmp := bytes.Compare(bytes.ToUpper(bytes.TrimSpace(key)), target); {
+		case cmp == 0:
+			return true, nil
+		case cmp < 0:
+			lo = start + int64(len(line)) + 1
+		default:
+			hi = mid
+		}
+	}
+
+	// lo puede quedar al principio de una línea que no se ha comparado
+	line, start, err := lineAt(f, lo, size)
+	if err != nil || start >= size {
+		return false, err
+	}
+	if i := bytes.IndexByte(line, ':'); i >= 0 {
+		line = line[:i]
+	}
+	return bytes.Equal(bytes.ToUpper(bytes.TrimSpace(line)), target), nil
+}
+
+// lineAt devuelve la primera línea completa que empieza en offset o después
+func lineAt(f *os.File, offset int64, size int64) ([]byte, int64, error) {
+	start := offset
+	if offset > 0 {
+		// Saltar hasta el final de la línea en la que cae offset
+		if _, err := f.Seek(offset-1, io.SeekStart); err != nil {
+			return nil, 0, err
+		}
+		reader := bufio.NewReader(f)
+		skipped, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			return nil, size, nil
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		start = offset - 1 + int64(len(skipped))
+	}
+	if start >= size {
+		return nil, size, nil
+	}
+	if _, err := f.Seek(start, io.SeekStart); err != nil {
+		return nil, 0, err
+	}
+	line, err := bufio.NewReader(f).ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return nil, 0, err
+	}
+	return bytes.TrimRight(line, "\r\n"), start, nil
+}
@@ -0,0 +1,132 @@
+package utils
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func sha1Hex(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+func errorCodes(policy PasswordPolicy, password string, email string, name string) []string {
+	codes := make([]string, 0)
+	for _, e := range policy.Validate(password, email, name) {
+		codes = append(codes, e.Field+":"+e.Code)
+	}
+	return codes
+}
+
+func TestPasswordPolicyValidate(t *testing.T) {
+	strict := PasswordPolicy{MinLength: 10, RequireUpper: true, RequireLower: true, RequireDigit: true, RequireSymbol: true}
+	lax := PasswordPolicy{MinLength: 4}
+
+	tests := []struct {
+		name     string
+		policy   PasswordPolicy
+		password string
+		want     []string
+	}{
+		{"valid", strict, "Caballo-Correcto-9", nil},
+		{"empty", strict, "", []string{"password:min_length", "password:uppercase", "password:lowercase", "password:digit", "password:symbol"}},
+		{"short", strict, "Ab1-", []string{"password:min_length"}},
+		{"no uppercase", strict, "caballo-correcto-9", []string{"password:uppercase"}},
+		{"no lowercase", strict, "CABALLO-CORRECTO-9", []string{"password:lowercase"}},
+		{"no digit", strict, "Caballo-Correcto", []string{"password:digit"}},
+		{"no symbol", strict, "CaballoCorrecto9", []string{"password:symbol"}},
+		{"space counts as symbol", strict, "Caballo Correcto 9", nil},
+		{"length in characters", lax, "ñññ", []string{"password:min_length"}},
+		{"unicode letters", lax, "ÑandúÁrbol", nil},
+		{"contains email", lax, "xana.lopez@example.comx", []string{"password:personal_data"}},
+		{"contains local part", lax, "ANA.LOPEZ2024", []string{"password:personal_data"}},
+		{"contains name", lax, "soyruizdelaserna", []string{"password:personal_data"}},
+		{"short name words ignored", lax, "deladela", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := errorCodes(tt.policy, tt.password, "ana.lopez@example.com", "Ana Ruiz de la Serna")
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("errors = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBreachedPasswordsDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "breached")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Formato k-anonymity: un fichero por prefijo con líneas SUFIJO:CUENTA
+	hash := sha1Hex("password1")
+	content := "0000000000000000000000000000000000A:1\r\n" + hash[5:] + ":2413945\r\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, hash[:5]+".txt"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	policy := PasswordPolicy{BreachedPasswordsPath: dir}
+	if got := errorCodes(policy, "password1", "", ""); strings.Join(got, ",") != "password:breached" {
+		t.Errorf("password1: errors = %v, want breached", got)
+	}
+	if got := errorCodes(policy, "no-filtrada", "", ""); len(got) != 0 {
+		t.Errorf("no-filtrada: errors = %v", got)
+	}
+}
+
+func TestBreachedPasswordsSortedFile(t *testing.T) {
+	passwords := make([]string, 0, 200)
+	for i := 0; i < 200; i++ {
+		passwords = append(passwords, fmt.Sprintf("password%d", i))
+	}
+	lines := make([]string, 0, len(passwords))
+	for _, password := range passwords {
+		lines = append(lines, sha1Hex(password)+":"+fmt.Sprint(len(password)))
+	}
+	sort.Strings(lines)
+
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"trailing newline", strings.Join(lines, "\n") + "\n"},
+		{"no trailing newline", strings.Join(lines, "\n")},
+		{"CRLF", strings.Join(lines, "\r\n") + "\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ioutil.TempFile("", "breached")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.Remove(f.Name())
+			if _, err := f.WriteString(tt.content); err != nil {
+				t.Fatal(err)
+			}
+			f.Close()
+
+			// Todas las contraseñas de la lista se encuentran, también la primera y la última
+			for _, password := range passwords {
+				breached, err := isBreached(f.Name(), password)
+				if err != nil || !breached {
+					t.Fatalf("isBreached(%q) = %v, %v, want true", password, breached, err)
+				}
+			}
+			for _, password := range []string{"", "password200", "Password1", "otra"} {
+				breached, err := isBreached(f.Name(), password)
+				if err != nil || breached {
+					t.Errorf("isBreached(%q) = %v, %v, want false", password, breached, err)
+				}
+			}
+		})
+	}
+}
@@ -1,6 +1,8 @@
 package utils
 
 import (
+	"time"
+
 	"github.com/go-redis/redis"
 )
 
@@ -18,6 +20,11 @@ func (r *Redis) SetValue(key string, value string) error {
 	return nil
 }
 
+// Method to set Redis value that expires after ttl
+func (r *Redis) SetValueTTL(key string, value string, ttl time.Duration) error {
+	return r.client.Set(key, value, ttl).Err()
+}
+
 // Method to get Redis value
 func (r *Redis) GetValue(key string) (string, error) {
 	val, err := r.client.Get(key).Result()
@@ -27,6 +34,20 @@ func (r *Redis) GetValue(key string) (string, error) {
 	return val, nil
 }
 
+// Method to check if a Redis key exists
+func (r *Redis) Exists(key string) (bool, error) {
+	n, err := r.client.Exists(key).Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// Method to delete Redis keys
+func (r *Redis) Delete(keys ...string) error {
+	return r.client.Del(keys...).Err()
+}
+
 func NewRedis(address, password string) *Redis {
 	client := redis.NewClient(&redis.Options{
 		Addr:     address,
@@ -38,3 +59,38 @@ func NewRedis(address, password string) *Redis {
 		client: client,
 	}
 }
+
+// Method to increment a Redis counter and reset its expiration
+func (r *Redis) Increment(key string, ttl time.Duration) (int64, error) {
+	pipe := r.client.TxPipeline()
+	incr := pipe.Incr(key)
+	pipe.Expire(key, ttl)
+	if _, err := pipe.Exec(); err != nil {
+		return 0, err
+	}
+	return incr.Val(), nil
+}
+
+// Method to get a Redis counter, 0 if it does not exist
+func (r *Redis) Counter(key string) (int64, error) {
+	n, err := r.client.Get(key).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return n, err
+}
+
+// Method to get a Redis counter and the time until it expires, 0 if it does not exist
+func (r *Redis) CounterTTL(key string) (int64, time.Duration, error) {
+	pipe := r.client.TxPipeline()
+	get := pipe.Get(key)
+	ttl := pipe.PTTL(key)
+	if _, err := pipe.Exec(); err != nil && err != redis.Nil {
+		return 0, 0, err
+	}
+	n, err := get.Int64()
+	if err == redis.Nil {
+		return 0, 0, nil
+	}
+	return n, ttl.Val(), err
+}
@@ -0,0 +1,63 @@
+package utils
+
+import (
+	"context"
+	"main/database"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Cache es la conexión a Redis. Es nil si REDIS_ADDR no está configurado.
+var Cache *Redis
+
+// RevokedToken es una entrada de la lista de tokens revocados en Mongo
+type RevokedToken struct {
+	JTI       string    `bson:"jti"`
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+// RevokeToken añade el jti a la lista de tokens revocados hasta que el token caduque.
+// Se guarda en Redis y, si Redis no está disponible, en la colección revoked_tokens.
+func RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+
+	if Cache != nil {
+		if err := Cache.SetValueTTL(revokedKey(jti), "1", ttl); err == nil {
+			return nil
+		}
+	}
+
+	_, err := database.Mg.Db.Collection("revoked_tokens").InsertOne(ctx, RevokedToken{
+		JTI:       jti,
+		ExpiresAt: expiresAt,
+	})
+	return err
+}
+
+// IsTokenRevoked comprueba si el jti está en la lista de tokens revocados
+func IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	if Cache != nil {
+		if revoked, err := Cache.Exists(revokedKey(jti)); err == nil {
+			if revoked {
+				return true, nil
+			}
+		}
+	}
+
+	count, err := database.Mg.Db.Collection("revoked_tokens").CountDocuments(ctx, bson.M{
+		"jti":        jti,
+		"expires_at": bson.M{"$gt": time.Now()},
+	})
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func revokedKey(jti string) string {
+	return "revoked:" + jti
+}
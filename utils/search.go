@@ -0,0 +1,145 @@
+package utils
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Longitud máxima de los fragmentos de texto de los resultados de búsqueda
+const snippetLength = 160
+
+// SearchTerms separa la búsqueda en palabras en minúsculas
+func SearchTerms(search string) []string {
+	return strings.FieldsFunc(strings.ToLower(search), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// ContainsPattern devuelve una expresión regular que busca el texto literal. El texto
+// se escapa para que no se puedan inyectar expresiones regulares.
+func ContainsPattern(search string) string {
+	return regexp.QuoteMeta(search)
+}
+
+// PrefixPattern devuelve una expresión regular que busca palabras que empiezan por term
+func PrefixPattern(term string) string {
+	return `(^|[^\p{L}\p{N}])` + regexp.QuoteMeta(term)
+}
+
+// Highlight devuelve un fragmento del texto alrededor de la primera palabra que coincide
+// con algún término, con las coincidencias entre <mark> y el resto escapado como HTML.
+// Devuelve "" si no hay coincidencias.
+func Highlight(text string, terms []string) string {
+	words := wordSpans(text)
+	first := -1
+	matches := make([]bool, len(words))
+	for i, w := range words {
+		if matchesTerm(foldWord(text[w[0]:w[1]]), terms) {
+			matches[i] = true
+			if first < 0 {
+				first = i
+			}
+		}
+	}
+	if first < 0 {
+		return ""
+	}
+
+	// Ventana de snippetLength bytes con la primera coincidencia cerca del principio,
+	// empezando y acabando en límites de palabra
+	start := 0
+	for _, w := range words[:first+1] {
+		if w[0] >= words[first][0]-snippetLength/4 {
+			start = w[0]
+			break
+		}
+	}
+	if first == 0 || start == words[0][0] {
+		start = 0
+	}
+	end := start + snippetLength
+	if end >= len(text) {
+		end = len(text)
+	} else {
+		for end > words[first][1] && !utf8.RuneStart(text[end]) {
+			end--
+		}
+		for _, w := range words {
+			if w[0] < end && end < w[1] {
+				end = w[0]
+			}
+		}
+	}
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	pos := start
+	for i, w := range words {
+		if w[0] < start || w[1] > end || !matches[i] {
+			continue
+		}
+		b.WriteString(html.EscapeString(text[pos:w[0]]))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(text[w[0]:w[1]]))
+		b.WriteString("</mark>")
+		pos = w[1]
+	}
+	b.WriteString(html.EscapeString(text[pos:end]))
+	if end < len(text) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
+
+// foldWord pasa la palabra a minúsculas y le quita las tildes
+func foldWord(word string) string {
+	var b strings.Builder
+	for _, r := range norm.NFD.String(strings.ToLower(word)) {
+		if !unicode.Is(unicode.Mn, r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// matchesTerm indica si la palabra empieza por algún término o, para cubrir plurales
+// y otras variantes que encuentra el índice de texto, si algún término empieza por ella
+func matchesTerm(word string, terms []string) bool {
+	for _, term := range terms {
+		term = foldWord(term)
+		if strings.HasPrefix(word, term) {
+			return true
+		}
+		if len(word) >= 3 && strings.HasPrefix(term, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// wordSpans devuelve las posiciones de inicio y fin de cada palabra del texto
+func wordSpans(text string) [][2]int {
+	var spans [][2]int
+	start := -1
+	for i, r := range text {
+		isWord := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if isWord && start < 0 {
+			start = i
+		}
+		if !isWord && start >= 0 {
+			spans = append(spans, [2]int{start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		spans = append(spans, [2]int{start, len(text)})
+	}
+	return spans
+}
@@ -0,0 +1,61 @@
+package utils
+
+import (
+	"regexp"
+	"testing"
+)
+
+// Textos con metacaracteres de expresiones regulares
+var regexInputs = []string{`.*`, `(a+)+$`, `[`, `\`, `a|b`, `^x`, `{1,2}`, `?`, `C++`, `$9.99`}
+
+func TestContainsPattern(t *testing.T) {
+	for _, search := range regexInputs {
+		re, err := regexp.Compile(ContainsPattern(search))
+		if err != nil {
+			t.Errorf("ContainsPattern(%q) does not compile: %v", search, err)
+			continue
+		}
+		// Solo encuentra el texto literal
+		if !re.MatchString("precio " + search + " final") {
+			t.Errorf("ContainsPattern(%q) does not match the literal text", search)
+		}
+		if re.MatchString("aaaa xyz b") {
+			t.Errorf("ContainsPattern(%q) matches other text", search)
+		}
+	}
+}
+
+func TestPrefixPattern(t *testing.T) {
+	for _, term := range regexInputs {
+		re, err := regexp.Compile(PrefixPattern(term))
+		if err != nil {
+			t.Errorf("PrefixPattern(%q) does not compile: %v", term, err)
+			continue
+		}
+		if !re.MatchString(term+"abc") || !re.MatchString("x "+term) {
+			t.Errorf("PrefixPattern(%q) does not match the literal text", term)
+		}
+		if re.MatchString("aaaa xyz b") {
+			t.Errorf("PrefixPattern(%q) matches other text", term)
+		}
+	}
+
+	tests := []struct {
+		text string
+		want bool
+	}{
+		{"camiseta roja", true},
+		{"la camiseta", true},
+		{"(camiseta)", true},
+		{"camisetas", true},
+		{"supercamiseta", false},
+		{"ñcamiseta", false},
+		{"2camiseta", false},
+	}
+	re := regexp.MustCompile(PrefixPattern("camiseta"))
+	for _, tt := range tests {
+		if got := re.MatchString(tt.text); got != tt.want {
+			t.Errorf("PrefixPattern(camiseta) on %q = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+}
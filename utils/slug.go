@@ -0,0 +1,61 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Slugify convierte el texto en un slug para URLs: minúsculas, sin tildes y con
+// guiones entre palabras. "Camión Rojo" -> "camion-rojo"
+func Slugify(text string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range norm.NFD.String(strings.ToLower(text)) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			continue
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		default:
+			dash = true
+		}
+	}
+	return b.String()
+}
+
+// UniqueSlug devuelve el slug del texto, con un sufijo numérico si ya lo usa otro
+// documento de la colección. excludeID es el documento que se está editando, puede ser nil.
+func UniqueSlug(ctx context.Context, collection *mongo.Collection, text string, excludeID interface{}) (string, error) {
+	base := Slugify(text)
+	if base == "" {
+		base = "item"
+	}
+
+	for i := 1; ; i++ {
+		slug := base
+		if i > 1 {
+			slug = fmt.Sprintf("%s-%d", base, i)
+		}
+		filter := bson.M{"slug": slug}
+		if excludeID != nil {
+			filter["_id"] = bson.M{"$ne": excludeID}
+		}
+		count, err := collection.CountDocuments(ctx, filter)
+		if err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return slug, nil
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package utils
+
+import (
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// Secreto de los vectores de prueba de la RFC 6238 ("12345678901234567890") en base32
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	// Apéndice B de la RFC 6238 (SHA1), con los 6 últimos dígitos
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		got, err := totpCode(rfcSecret, tt.unix/totpPeriod)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("totpCode at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := now.Unix() / totpPeriod
+	code := func(s int64) string {
+		c, err := totpCode(rfcSecret, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name     string
+		secret   string
+		code     string
+		ok       bool
+		wantStep int64
+	}{
+		{"current", rfcSecret, code(step), true, step},
+		{"lowercase secret", "gezdgnbvgy3tqojqgezdgnbvgy3tqojq", code(step), true, step},
+		{"with spaces", rfcSecret, " " + code(step) + " ", true, step},
+		{"previous step", rfcSecret, code(step - 1), true, step - 1},
+		{"next step", rfcSecret, code(step + 1), true, step + 1},
+		{"too old", rfcSecret, code(step - 2), false, 0},
+		{"too new", rfcSecret, code(step + 2), false, 0},
+		{"wrong code", rfcSecret, "000000", false, 0},
+		{"short code", rfcSecret, code(step)[:5], false, 0},
+		{"invalid secret", "not base32!", "123456", false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotStep, ok := ValidateTOTP(tt.secret, tt.code, now)
+			if ok != tt.ok || gotStep != tt.wantStep {
+				t.Errorf("ValidateTOTP = %d, %v, want %d, %v", gotStep, ok, tt.wantStep, tt.ok)
+			}
+		})
+	}
+}
+
+func TestGenerateTOTPSecret(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil || len(key) != 20 {
+		t.Errorf("secret %q decodes to %d bytes, %v", secret, len(key), err)
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri, err := url.Parse(TOTPURI("Golang API", "ana@example.com", rfcSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || uri.Path != "/Golang API:ana@example.com" {
+		t.Errorf("uri = %s", uri)
+	}
+	params := uri.Query()
+	want := map[string]string{"secret": rfcSecret, "issuer": "Golang API", "algorithm": "SHA1", "digits": "6", "period": "30"}
+	for key, value := range want {
+		if params.Get(key) != value {
+			t.Errorf("%s = %q, want %q", key, params.Get(key), value)
+		}
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	format := regexp.MustCompile(`^[0-9a-f]{4}-[0-9a-f]{4}$`)
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if !format.MatchString(code) {
+			t.Errorf("code %q does not match xxxx-xxxx", code)
+		}
+		seen[code] = true
+	}
+	if len(codes) != 10 || len(seen) != 10 {
+		t.Errorf("got %d codes, %d distinct", len(codes), len(seen))
+	}
+	if got := NormalizeRecoveryCode(" AB12-CD34 "); got != "ab12-cd34" {
+		t.Errorf("NormalizeRecoveryCode = %q", got)
+	}
+}